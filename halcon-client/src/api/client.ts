@@ -1,6 +1,33 @@
 import axios from 'axios'
 
-const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080'
+export const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080'
+
+// Header naming the tenant in multi-tenant deployments, see TENANT_HEADER on the server
+export const TENANT_HEADER = import.meta.env.VITE_TENANT_HEADER || 'X-Tenant'
+
+// Slug of the company the app is used for, remembered from ?tenant= links.
+// Empty when the server picks the tenant from the subdomain or runs single-tenant.
+export const getTenant = () => localStorage.getItem('tenant') || ''
+
+export const setTenant = (slug: string) => {
+    if (slug) {
+        localStorage.setItem('tenant', slug)
+    } else {
+        localStorage.removeItem('tenant')
+    }
+}
+
+// Headers for requests made outside apiClient
+export const tenantHeaders = (): Record<string, string> => {
+    const tenant = getTenant()
+    return tenant ? { [TENANT_HEADER]: tenant } : {}
+}
+
+// Request config for PATCH calls, whose bodies are JSON Merge Patch documents:
+// members left out keep their value and null clears them
+export const mergePatch = {
+    headers: { 'Content-Type': 'application/merge-patch+json' },
+}
 
 const apiClient = axios.create({
     baseURL: `${API_URL}/api`,
@@ -16,6 +43,10 @@ apiClient.interceptors.request.use(
         if (token) {
             config.headers.Authorization = `Bearer ${token}`
         }
+        const tenant = getTenant()
+        if (tenant) {
+            config.headers[TENANT_HEADER] = tenant
+        }
         return config
     },
     (error) => {
@@ -23,13 +54,71 @@ apiClient.interceptors.request.use(
     }
 )
 
+// Public auth endpoints whose 401s are shown to the user instead of ending the session
+const publicAuthPaths = ['/auth/login', '/auth/2fa/verify', '/auth/refresh', '/auth/forgot-password', '/auth/reset-password', '/auth/oidc/exchange']
+
+// Puts back the admin's own session saved when they started impersonating;
+// returns false when not impersonating
+export const restoreImpersonator = () => {
+    const saved = localStorage.getItem('impersonator_session')
+    if (!saved) {
+        return false
+    }
+    const session = JSON.parse(saved)
+    localStorage.setItem('token', session.token)
+    if (session.refresh_token) {
+        localStorage.setItem('refresh_token', session.refresh_token)
+    }
+    localStorage.setItem('user', session.user)
+    localStorage.removeItem('impersonator_session')
+    return true
+}
+
+// Shared refresh so concurrent 401s only rotate the refresh token once
+let refreshing: Promise<string> | null = null
+
+const refreshAccessToken = async (): Promise<string> => {
+    const refreshToken = localStorage.getItem('refresh_token')
+    if (!refreshToken) {
+        throw new Error('No refresh token')
+    }
+
+    const response = await axios.post(`${API_URL}/api/auth/refresh`, { refresh_token: refreshToken }, { headers: tenantHeaders() })
+    localStorage.setItem('token', response.data.token)
+    localStorage.setItem('refresh_token', response.data.refresh_token)
+    localStorage.setItem('user', JSON.stringify(response.data.user))
+    return response.data.token
+}
+
 // Response interceptor to handle errors
 apiClient.interceptors.response.use(
     (response) => response,
-    (error) => {
-        if (error.response?.status === 401) {
-            // Clear token and redirect to login
+    async (error) => {
+        const original = error.config
+        if (error.response?.status === 401 && original && !original._retry && !publicAuthPaths.includes(original.url ?? '')) {
+            // Retry once with a refreshed access token
+            original._retry = true
+            try {
+                refreshing = refreshing || refreshAccessToken()
+                const token = await refreshing
+                original.headers.Authorization = `Bearer ${token}`
+                return apiClient(original)
+            } catch {
+                // Fall through to the login redirect
+            } finally {
+                refreshing = null
+            }
+        }
+
+        if (error.response?.status === 401 && !publicAuthPaths.includes(original?.url ?? '')) {
+            // An expired impersonation returns the admin to their own session
+            if (restoreImpersonator()) {
+                window.location.href = '/dashboard/users'
+                return Promise.reject(error)
+            }
+            // Clear tokens and redirect to login
             localStorage.removeItem('token')
+            localStorage.removeItem('refresh_token')
             localStorage.removeItem('user')
             window.location.href = '/login'
         }
@@ -6,6 +6,13 @@ import { createPinia } from 'pinia'
 import App from './App.vue'
 import router from './router'
 import { useAuthStore } from './stores/auth'
+import { setTenant } from './api/client'
+
+// Links such as https://app.example.com/login?tenant=acme pick the company
+const tenantParam = new URLSearchParams(window.location.search).get('tenant')
+if (tenantParam !== null) {
+  setTenant(tenantParam.trim().toLowerCase())
+}
 
 const app = createApp(App)
 
@@ -15,5 +22,9 @@ app.use(router)
 // Initialize auth from localStorage
 const authStore = useAuthStore()
 authStore.initAuth()
+// Refresh the saved user so roles and permissions changed by an admin apply
+if (authStore.isAuthenticated) {
+  authStore.fetchCurrentUser()
+}
 
 app.mount('#app')
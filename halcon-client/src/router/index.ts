@@ -20,6 +20,30 @@ const router = createRouter({
       component: () => import('@/views/Login.vue'),
       meta: { public: true },
     },
+    {
+      path: '/forgot-password',
+      name: 'forgot-password',
+      component: () => import('@/views/ForgotPassword.vue'),
+      meta: { public: true },
+    },
+    {
+      path: '/reset-password',
+      name: 'reset-password',
+      component: () => import('@/views/ResetPassword.vue'),
+      meta: { public: true },
+    },
+    {
+      path: '/accept-invitation',
+      name: 'accept-invitation',
+      component: () => import('@/views/AcceptInvitation.vue'),
+      meta: { public: true },
+    },
+    {
+      path: '/sso/callback',
+      name: 'sso-callback',
+      component: () => import('@/views/SsoCallback.vue'),
+      meta: { public: true },
+    },
     {
       path: '/dashboard',
       name: 'dashboard',
@@ -39,7 +63,7 @@ const router = createRouter({
           path: 'orders/create',
           name: 'orders-create',
           component: () => import('@/views/orders/OrderCreate.vue'),
-          meta: { roles: ['Sales'] },
+          meta: { permissions: ['orders.create'] },
         },
         {
           path: 'orders/:id',
@@ -50,25 +74,37 @@ const router = createRouter({
           path: 'recycle-bin',
           name: 'recycle-bin',
           component: () => import('@/views/orders/RecycleBin.vue'),
-          meta: { roles: ['Admin', 'Sales'] },
+          meta: { permissions: ['orders.restore'] },
         },
         {
           path: 'users',
           name: 'users',
           component: () => import('@/views/users/UserList.vue'),
-          meta: { roles: ['Admin'] },
+          meta: { permissions: ['users.read'] },
         },
         {
           path: 'users/create',
           name: 'users-create',
           component: () => import('@/views/users/UserForm.vue'),
-          meta: { roles: ['Admin'] },
+          meta: { permissions: ['users.manage'] },
         },
         {
           path: 'users/:id/edit',
           name: 'users-edit',
           component: () => import('@/views/users/UserForm.vue'),
-          meta: { roles: ['Admin'] },
+          meta: { permissions: ['users.manage'] },
+        },
+        {
+          path: 'users/invitations',
+          name: 'invitations',
+          component: () => import('@/views/users/InvitationList.vue'),
+          meta: { permissions: ['users.manage'] },
+        },
+        {
+          path: 'settings',
+          name: 'tenant-settings',
+          component: () => import('@/views/TenantSettings.vue'),
+          meta: { permissions: ['tenant.manage'] },
         },
       ],
     },
@@ -85,9 +121,8 @@ router.beforeEach((to, from, next) => {
     next('/login')
   } else if (to.path === '/login' && authStore.isAuthenticated) {
     next('/dashboard')
-  } else if (to.meta.roles) {
-    const allowedRoles = to.meta.roles as string[]
-    if (authStore.userRole && allowedRoles.includes(authStore.userRole)) {
+  } else if (to.meta.permissions) {
+    if (authStore.can(...(to.meta.permissions as string[]))) {
       next()
     } else {
       next('/dashboard')
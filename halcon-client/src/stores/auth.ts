@@ -1,25 +1,51 @@
 import { defineStore } from 'pinia'
 import { ref, computed } from 'vue'
-import apiClient from '@/api/client'
+import apiClient, { API_URL, getTenant, restoreImpersonator } from '@/api/client'
 
 export interface User {
     id: number
     username: string
-    role: 'Admin' | 'Sales' | 'Purchasing' | 'Warehouse' | 'Route'
+    // Primary role; roles holds every role of the user
+    role: string
+    roles: string[]
+    permissions: string[]
     department: string
+    team: string
+    branch_id: number | null
     full_name: string
     email: string
+    sso: boolean
+    // The admin acting as this user, when impersonating
+    impersonator?: { id: number; username: string }
 }
 
+// Where the admin's own session waits while they impersonate someone, see
+// restoreImpersonator
+const IMPERSONATOR_SESSION = 'impersonator_session'
+
 export const useAuthStore = defineStore('auth', () => {
     const user = ref<User | null>(null)
     const token = ref<string | null>(null)
     const loading = ref(false)
     const error = ref<string | null>(null)
+    // Set between the password and second factor steps of a 2FA login
+    const twoFactorToken = ref<string | null>(null)
+    const ssoEnabled = ref(false)
 
     const isAuthenticated = computed(() => !!token.value)
     const userRole = computed(() => user.value?.role)
 
+    // Whether the user has any of the permissions
+    const can = (...permissions: string[]) =>
+        permissions.some((p) => user.value?.permissions?.includes(p) ?? false)
+
+    // The order list scopes the user may choose, narrowest first
+    const orderScopes = computed(() => {
+        if (can('orders.scope.all')) return ['mine', 'team', 'department', 'all']
+        if (can('orders.scope.department')) return ['mine', 'team', 'department']
+        return ['mine', 'team']
+    })
+
     // Initialize from localStorage
     const initAuth = () => {
         const savedToken = localStorage.getItem('token')
@@ -37,37 +63,218 @@ export const useAuthStore = defineStore('auth', () => {
 
         try {
             const response = await apiClient.post('/auth/login', { username, password })
-            const { token: authToken, user: userData } = response.data
+            if (response.data.two_factor_required) {
+                twoFactorToken.value = response.data.two_factor_token
+                return false
+            }
+
+            startSession(response.data)
+            return true
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Login failed'
+            return false
+        } finally {
+            loading.value = false
+        }
+    }
+
+    // Completes a 2FA login with an authenticator or recovery code
+    const verifyTwoFactor = async (code: string) => {
+        loading.value = true
+        error.value = null
+
+        const trimmed = code.trim()
+        const body = /^\d{6}$/.test(trimmed)
+            ? { two_factor_token: twoFactorToken.value, code: trimmed }
+            : { two_factor_token: twoFactorToken.value, recovery_code: trimmed }
+
+        try {
+            const response = await apiClient.post('/auth/2fa/verify', body)
+            twoFactorToken.value = null
+            startSession(response.data)
+            return true
+        } catch (err: any) {
+            if (err.response?.data?.code === 'invalid_token') {
+                // The intermediate token expired, start over
+                twoFactorToken.value = null
+            }
+            error.value = err.response?.data?.detail || 'Verification failed'
+            return false
+        } finally {
+            loading.value = false
+        }
+    }
 
-            token.value = authToken
-            user.value = userData
+    const fetchSSOConfig = async () => {
+        try {
+            const response = await apiClient.get('/auth/sso')
+            ssoEnabled.value = response.data.enabled
+        } catch {
+            ssoEnabled.value = false
+        }
+    }
 
-            localStorage.setItem('token', authToken)
-            localStorage.setItem('user', JSON.stringify(userData))
+    // Leaves the app for the identity provider, which returns to /sso/callback
+    const startSSO = (redirect = '/dashboard') => {
+        // A navigation can't carry the tenant header, so name the tenant in the URL
+        const tenant = getTenant()
+        const tenantParam = tenant ? `&tenant=${encodeURIComponent(tenant)}` : ''
+        window.location.href = `${API_URL}/api/auth/oidc/login?redirect=${encodeURIComponent(redirect)}${tenantParam}`
+    }
+
+    // Trades the one-time code from the SSO callback for a session; returns
+    // false when a 2FA code is still needed or the exchange failed
+    const completeSSO = async (code: string) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            const response = await apiClient.post('/auth/oidc/exchange', { code })
+            if (response.data.two_factor_required) {
+                twoFactorToken.value = response.data.two_factor_token
+                return false
+            }
 
+            startSession(response.data)
             return true
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Login failed'
+            error.value = err.response?.data?.detail || 'Single sign-on failed'
             return false
         } finally {
             loading.value = false
         }
     }
 
-    const logout = () => {
+    const startSession = (data: any) => {
+        token.value = data.token
+        user.value = data.user
+
+        localStorage.setItem('token', data.token)
+        localStorage.setItem('refresh_token', data.refresh_token)
+        localStorage.setItem('user', JSON.stringify(data.user))
+    }
+
+    const clearSession = () => {
         user.value = null
         token.value = null
         localStorage.removeItem('token')
+        localStorage.removeItem('refresh_token')
         localStorage.removeItem('user')
     }
 
+    // Acts as another user with a short-lived token, keeping the admin's own
+    // session to return to
+    const impersonate = async (userId: number, reason: string) => {
+        error.value = null
+        try {
+            const response = await apiClient.post(`/users/${userId}/impersonate`, { reason })
+            localStorage.setItem(IMPERSONATOR_SESSION, JSON.stringify({
+                token: localStorage.getItem('token'),
+                refresh_token: localStorage.getItem('refresh_token'),
+                user: localStorage.getItem('user'),
+            }))
+            token.value = response.data.token
+            user.value = response.data.user
+            localStorage.setItem('token', response.data.token)
+            localStorage.removeItem('refresh_token')
+            localStorage.setItem('user', JSON.stringify(response.data.user))
+            return true
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Failed to impersonate user'
+            return false
+        }
+    }
+
+    // Ends the impersonation and returns to the admin's own session
+    const stopImpersonating = async () => {
+        try {
+            await apiClient.post('/auth/logout', { refresh_token: '' })
+        } catch {
+            // The impersonation token already expired
+        }
+        restoreImpersonator()
+        initAuth()
+    }
+
+    const logout = async () => {
+        // Revoke the session server-side; the local session is cleared regardless
+        const refreshToken = localStorage.getItem('refresh_token')
+        if (token.value) {
+            try {
+                await apiClient.post('/auth/logout', { refresh_token: refreshToken || '' })
+            } catch {
+                // Token already expired or revoked
+            }
+        }
+        clearSession()
+    }
+
+    // Asks for a password reset email; the server answers the same for
+    // unknown addresses
+    const forgotPassword = async (email: string) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            await apiClient.post('/auth/forgot-password', { email })
+            return true
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Request failed'
+            return false
+        } finally {
+            loading.value = false
+        }
+    }
+
+    const resetPassword = async (resetToken: string, newPassword: string) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            await apiClient.post('/auth/reset-password', { token: resetToken, new_password: newPassword })
+            return true
+        } catch (err: any) {
+            const fieldErrors: { message: string }[] = err.response?.data?.errors || []
+            error.value = fieldErrors.length
+                ? fieldErrors.map((e) => e.message).join(', ')
+                : err.response?.data?.detail || 'Password reset failed'
+            return false
+        } finally {
+            loading.value = false
+        }
+    }
+
+    // Creates the account of an invited user, who logs in afterwards
+    const acceptInvitation = async (invitationToken: string, username: string, fullName: string, password: string) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            await apiClient.post('/auth/accept-invitation', {
+                token: invitationToken,
+                username,
+                full_name: fullName,
+                password,
+            })
+            return true
+        } catch (err: any) {
+            const fieldErrors: { message: string }[] = err.response?.data?.errors || []
+            error.value = fieldErrors.length
+                ? fieldErrors.map((e) => e.message).join(', ')
+                : err.response?.data?.detail || 'Failed to accept the invitation'
+            return false
+        } finally {
+            loading.value = false
+        }
+    }
+
     const fetchCurrentUser = async () => {
         try {
             const response = await apiClient.get('/auth/me')
             user.value = response.data
             localStorage.setItem('user', JSON.stringify(response.data))
         } catch (err) {
-            logout()
+            clearSession()
         }
     }
 
@@ -76,11 +283,24 @@ export const useAuthStore = defineStore('auth', () => {
         token,
         loading,
         error,
+        twoFactorToken,
+        ssoEnabled,
         isAuthenticated,
         userRole,
+        can,
+        orderScopes,
         initAuth,
         login,
+        verifyTwoFactor,
+        fetchSSOConfig,
+        startSSO,
+        completeSSO,
+        forgotPassword,
+        resetPassword,
+        acceptInvitation,
         logout,
         fetchCurrentUser,
+        impersonate,
+        stopImpersonating,
     }
 })
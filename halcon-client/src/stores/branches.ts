@@ -0,0 +1,33 @@
+import { defineStore } from 'pinia'
+import { ref } from 'vue'
+import apiClient from '@/api/client'
+
+export interface Branch {
+    id: number
+    code: string
+    name: string
+    address: string
+    is_default: boolean
+    is_active: boolean
+}
+
+export const useBranchesStore = defineStore('branches', () => {
+    const branches = ref<Branch[]>([])
+    const error = ref<string | null>(null)
+
+    const fetchBranches = async () => {
+        error.value = null
+        try {
+            const response = await apiClient.get('/branches')
+            branches.value = response.data
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Failed to fetch branches'
+        }
+    }
+
+    return {
+        branches,
+        error,
+        fetchBranches,
+    }
+})
@@ -0,0 +1,110 @@
+import { defineStore } from 'pinia'
+import { ref } from 'vue'
+import apiClient from '@/api/client'
+import type { Branch } from '@/stores/branches'
+
+export type InvitationStatus = 'pending' | 'accepted' | 'revoked' | 'expired'
+
+export interface Invitation {
+    id: number
+    email: string
+    full_name: string
+    role: string
+    department: string
+    branch_id: number | null
+    branch?: Branch
+    status: InvitationStatus
+    expires_at: string
+    sent_count: number
+    last_sent_at?: string
+    invited_by: number
+    invited_by_user?: {
+        id: number
+        username: string
+        full_name: string
+    }
+    accepted_at?: string
+    user_id?: number
+    revoked_at?: string
+    created_at: string
+}
+
+export interface NewInvitation {
+    email: string
+    full_name: string
+    role: string
+    department: string
+    branch_id: number | null
+}
+
+export const useInvitationsStore = defineStore('invitations', () => {
+    const invitations = ref<Invitation[]>([])
+    const loading = ref(false)
+    const error = ref<string | null>(null)
+
+    // Lists invitations; the server defaults to pending ones
+    const fetchInvitations = async (status: InvitationStatus | 'all' = 'pending') => {
+        loading.value = true
+        error.value = null
+
+        try {
+            const response = await apiClient.get('/invitations', { params: { status } })
+            invitations.value = response.data
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Failed to fetch invitations'
+        } finally {
+            loading.value = false
+        }
+    }
+
+    const createInvitation = async (invitation: NewInvitation) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            const response = await apiClient.post('/invitations', invitation)
+            invitations.value.unshift(response.data)
+            return response.data as Invitation
+        } catch (err: any) {
+            const fieldErrors: { field: string; message: string }[] = err.response?.data?.errors || []
+            error.value = fieldErrors.length
+                ? fieldErrors.map((e) => `${e.field}: ${e.message}`).join(', ')
+                : err.response?.data?.detail || 'Failed to send invitation'
+            return null
+        } finally {
+            loading.value = false
+        }
+    }
+
+    // Resend and revoke replace the invitation with the server's copy
+    const changeInvitation = async (id: number, request: Promise<{ data: Invitation }>, failure: string) => {
+        error.value = null
+        try {
+            const response = await request
+            const index = invitations.value.findIndex((i) => i.id === id)
+            if (index !== -1) {
+                invitations.value[index] = response.data
+            }
+            return true
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || failure
+            return false
+        }
+    }
+
+    const resendInvitation = (id: number) =>
+        changeInvitation(id, apiClient.post(`/invitations/${id}/resend`), 'Failed to resend invitation')
+
+    const revokeInvitation = (id: number) =>
+        changeInvitation(id, apiClient.delete(`/invitations/${id}`), 'Failed to revoke invitation')
+
+    return {
+        invitations,
+        loading,
+        error,
+        fetchInvitations,
+        createInvitation,
+        resendInvitation,
+        revokeInvitation,
+    }
+})
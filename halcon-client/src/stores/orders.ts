@@ -1,6 +1,7 @@
 import { defineStore } from 'pinia'
 import { ref } from 'vue'
-import apiClient from '@/api/client'
+import apiClient, { mergePatch } from '@/api/client'
+import type { Branch } from '@/stores/branches'
 
 export type OrderStatus = 'Ordered' | 'In Process' | 'In Route' | 'Delivered'
 
@@ -14,6 +15,10 @@ export interface Order {
     notes: string
     evidence_photo_url: string
     is_deleted: boolean
+    branch_id: number
+    branch?: Branch
+    // Set from the company's delivery SLA when the order was created
+    due_at?: string
     created_by: number
     last_modified_by: number
     created_at: string
@@ -30,12 +35,24 @@ export interface Order {
     }
 }
 
+// A JSON Merge Patch of an order: members left out keep their value, null
+// clears the delivery address and the notes
+export interface OrderPatch {
+    status?: OrderStatus
+    delivery_address?: string | null
+    notes?: string | null
+}
+
 export interface OrderFilters {
     invoice_number?: string
     customer_name?: string
     customer_number?: string
     status?: string
     include_deleted?: boolean
+    // Whose orders to list: mine, team, department or all
+    scope?: string
+    // Only undelivered orders past their due date
+    overdue?: boolean
 }
 
 export const useOrdersStore = defineStore('orders', () => {
@@ -55,11 +72,13 @@ export const useOrdersStore = defineStore('orders', () => {
             if (filters?.customer_number) params.append('customer_number', filters.customer_number)
             if (filters?.status) params.append('status', filters.status)
             if (filters?.include_deleted) params.append('include_deleted', 'true')
+            if (filters?.scope) params.append('scope', filters.scope)
+            if (filters?.overdue) params.append('overdue', 'true')
 
             const response = await apiClient.get(`/orders?${params.toString()}`)
             orders.value = response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to fetch orders'
+            error.value = err.response?.data?.detail || 'Failed to fetch orders'
         } finally {
             loading.value = false
         }
@@ -74,7 +93,7 @@ export const useOrdersStore = defineStore('orders', () => {
             currentOrder.value = response.data
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to fetch order'
+            error.value = err.response?.data?.detail || 'Failed to fetch order'
             return null
         } finally {
             loading.value = false
@@ -90,19 +109,19 @@ export const useOrdersStore = defineStore('orders', () => {
             orders.value.unshift(response.data)
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to create order'
+            error.value = err.response?.data?.detail || 'Failed to create order'
             return null
         } finally {
             loading.value = false
         }
     }
 
-    const updateOrder = async (id: number, orderData: Partial<Order>) => {
+    const updateOrder = async (id: number, changes: OrderPatch) => {
         loading.value = true
         error.value = null
 
         try {
-            const response = await apiClient.put(`/orders/${id}`, orderData)
+            const response = await apiClient.patch(`/orders/${id}`, changes, mergePatch)
             const index = orders.value.findIndex((o) => o.id === id)
             if (index !== -1) {
                 orders.value[index] = response.data
@@ -112,7 +131,26 @@ export const useOrdersStore = defineStore('orders', () => {
             }
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to update order'
+            error.value = err.response?.data?.detail || 'Failed to update order'
+            return null
+        } finally {
+            loading.value = false
+        }
+    }
+
+    // Moves an order to another branch
+    const transferOrder = async (id: number, branchId: number, note: string) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            const response = await apiClient.post(`/orders/${id}/transfer`, { branch_id: branchId, note })
+            if (currentOrder.value?.id === id) {
+                currentOrder.value = response.data
+            }
+            return response.data
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Failed to transfer order'
             return null
         } finally {
             loading.value = false
@@ -128,7 +166,7 @@ export const useOrdersStore = defineStore('orders', () => {
             orders.value = orders.value.filter((o) => o.id !== id)
             return true
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to delete order'
+            error.value = err.response?.data?.detail || 'Failed to delete order'
             return false
         } finally {
             loading.value = false
@@ -143,7 +181,7 @@ export const useOrdersStore = defineStore('orders', () => {
             const response = await apiClient.post(`/orders/${id}/restore`)
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to restore order'
+            error.value = err.response?.data?.detail || 'Failed to restore order'
             return null
         } finally {
             loading.value = false
@@ -168,7 +206,7 @@ export const useOrdersStore = defineStore('orders', () => {
             })
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to upload evidence'
+            error.value = err.response?.data?.detail || 'Failed to upload evidence'
             return null
         } finally {
             loading.value = false
@@ -184,6 +222,7 @@ export const useOrdersStore = defineStore('orders', () => {
         fetchOrder,
         createOrder,
         updateOrder,
+        transferOrder,
         deleteOrder,
         restoreOrder,
         uploadEvidence,
@@ -0,0 +1,56 @@
+import { defineStore } from 'pinia'
+import { ref } from 'vue'
+import apiClient from '@/api/client'
+
+export interface Tenant {
+    id: number
+    slug: string
+    name: string
+    logo_url: string
+    allow_direct_dispatch: boolean
+    require_delivery_evidence: boolean
+    delivery_sla_hours: number
+}
+
+export type TenantSettings = Pick<Tenant, 'name' | 'logo_url' | 'allow_direct_dispatch' | 'require_delivery_evidence' | 'delivery_sla_hours'>
+
+export const useTenantStore = defineStore('tenant', () => {
+    const tenant = ref<Tenant | null>(null)
+    const loading = ref(false)
+    const error = ref<string | null>(null)
+
+    // Loads the name, logo and workflow settings of the company the app is used for
+    const fetchTenant = async () => {
+        error.value = null
+        try {
+            const response = await apiClient.get('/tenant')
+            tenant.value = response.data
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Failed to load company'
+        }
+    }
+
+    const updateTenant = async (settings: TenantSettings) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            const response = await apiClient.put('/tenant', settings)
+            tenant.value = response.data
+            return true
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Failed to update company settings'
+            return false
+        } finally {
+            loading.value = false
+        }
+    }
+
+    return {
+        tenant,
+        loading,
+        error,
+        fetchTenant,
+        updateTenant,
+    }
+})
@@ -1,39 +1,82 @@
 import { defineStore } from 'pinia'
 import { ref } from 'vue'
-import apiClient from '@/api/client'
+import apiClient, { mergePatch } from '@/api/client'
 
 export interface User {
     id: number
     username: string
-    role: 'Admin' | 'Sales' | 'Purchasing' | 'Warehouse' | 'Route'
+    role: string
+    roles?: { id: number; name: string }[]
     department: string
+    team: string
+    branch_id: number | null
     full_name: string
     email: string
     is_active: boolean
+    is_service_account: boolean
     created_at: string
     updated_at: string
 }
 
+// A JSON Merge Patch of a user: members left out keep their value, null
+// clears the profile fields and the branch
+export type UserPatch = {
+    [K in 'role' | 'department' | 'team' | 'branch_id' | 'full_name' | 'email' | 'is_active']?: User[K] | null
+} & { password?: string; roles?: string[] | null; must_change_password?: boolean }
+
+export interface UserFilters {
+    search?: string
+    role?: string
+    department?: string
+    active?: string
+    // List deleted users instead of current ones
+    deleted?: boolean
+    page?: number
+    per_page?: number
+}
+
 export const useUsersStore = defineStore('users', () => {
     const users = ref<User[]>([])
+    // Number of users matching the last fetch, across all pages
+    const total = ref(0)
     const currentUser = ref<User | null>(null)
     const loading = ref(false)
     const error = ref<string | null>(null)
 
-    const fetchUsers = async () => {
+    const fetchUsers = async (filters?: UserFilters) => {
         loading.value = true
         error.value = null
 
         try {
-            const response = await apiClient.get('/users')
+            const params = new URLSearchParams()
+            if (filters?.search) params.append('search', filters.search)
+            if (filters?.role) params.append('role', filters.role)
+            if (filters?.department) params.append('department', filters.department)
+            if (filters?.active) params.append('active', filters.active)
+            if (filters?.deleted) params.append('deleted', 'true')
+            if (filters?.page) params.append('page', String(filters.page))
+            if (filters?.per_page) params.append('per_page', String(filters.per_page))
+
+            const response = await apiClient.get(`/users?${params.toString()}`)
             users.value = response.data
+            total.value = Number(response.headers['x-total-count'] ?? response.data.length)
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to fetch users'
+            error.value = err.response?.data?.detail || 'Failed to fetch users'
         } finally {
             loading.value = false
         }
     }
 
+    // Looks up users without replacing the listed ones
+    const searchUsers = async (filters: UserFilters): Promise<User[]> => {
+        try {
+            const response = await apiClient.get('/users', { params: filters })
+            return response.data
+        } catch {
+            return []
+        }
+    }
+
     const fetchUser = async (id: number) => {
         loading.value = true
         error.value = null
@@ -43,7 +86,7 @@ export const useUsersStore = defineStore('users', () => {
             currentUser.value = response.data
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to fetch user'
+            error.value = err.response?.data?.detail || 'Failed to fetch user'
             return null
         } finally {
             loading.value = false
@@ -59,42 +102,63 @@ export const useUsersStore = defineStore('users', () => {
             users.value.unshift(response.data)
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to create user'
+            error.value = err.response?.data?.detail || 'Failed to create user'
             return null
         } finally {
             loading.value = false
         }
     }
 
-    const updateUser = async (id: number, userData: Partial<User> & { password?: string }) => {
+    const updateUser = async (id: number, changes: UserPatch) => {
         loading.value = true
         error.value = null
 
         try {
-            const response = await apiClient.put(`/users/${id}`, userData)
+            const response = await apiClient.patch(`/users/${id}`, changes, mergePatch)
             const index = users.value.findIndex((u) => u.id === id)
             if (index !== -1) {
                 users.value[index] = response.data
             }
             return response.data
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to update user'
+            error.value = err.response?.data?.detail || 'Failed to update user'
+            return null
+        } finally {
+            loading.value = false
+        }
+    }
+
+    // Deletes a user, optionally handing their undelivered orders to another
+    // user; returns the number of reassigned orders, or null on failure
+    const deleteUser = async (id: number, reassignTo?: number) => {
+        loading.value = true
+        error.value = null
+
+        try {
+            const params = reassignTo ? { reassign_to: reassignTo } : undefined
+            const response = await apiClient.delete(`/users/${id}`, { params })
+            users.value = users.value.filter((u) => u.id !== id)
+            total.value--
+            return response.data.reassigned_orders as number
+        } catch (err: any) {
+            error.value = err.response?.data?.detail || 'Failed to delete user'
             return null
         } finally {
             loading.value = false
         }
     }
 
-    const deleteUser = async (id: number) => {
+    const restoreUser = async (id: number) => {
         loading.value = true
         error.value = null
 
         try {
-            await apiClient.delete(`/users/${id}`)
+            await apiClient.post(`/users/${id}/restore`)
             users.value = users.value.filter((u) => u.id !== id)
+            total.value--
             return true
         } catch (err: any) {
-            error.value = err.response?.data?.message || 'Failed to delete user'
+            error.value = err.response?.data?.detail || 'Failed to restore user'
             return false
         } finally {
             loading.value = false
@@ -103,13 +167,16 @@ export const useUsersStore = defineStore('users', () => {
 
     return {
         users,
+        total,
         currentUser,
         loading,
         error,
         fetchUsers,
         fetchUser,
+        searchUsers,
         createUser,
         updateUser,
         deleteUser,
+        restoreUser,
     }
 })
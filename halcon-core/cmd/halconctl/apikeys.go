@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// apiKeyCreateResult is the JSON output of "apikey create"
+type apiKeyCreateResult struct {
+	APIKey models.APIKey `json:"api_key"`
+	Key    string        `json:"key"`
+}
+
+// apiKeyCommand dispatches "apikey list|create|revoke"
+func apiKeyCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: halconctl apikey list|create|revoke <service-account> ...")
+	}
+	if err := connectTenant(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return apiKeyList(args[1:])
+	case "create":
+		return apiKeyCreate(args[1:])
+	case "revoke":
+		return apiKeyRevoke(args[1:])
+	}
+	return fmt.Errorf("unknown apikey command %q", args[0])
+}
+
+// apiKeyList handles "apikey list <service-account>"
+func apiKeyList(args []string) error {
+	user, _, err := findUserArg("apikey list", args)
+	if err != nil {
+		return err
+	}
+
+	var keys []models.APIKey
+	if err := tenantDB().Where("user_id = ?", user.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	lines := make([]string, 0, len(keys)+1)
+	lines = append(lines, fmt.Sprintf("%-6s %-12s %-20s %-30s %-10s %s", "ID", "PREFIX", "NAME", "SCOPES", "USES", "STATUS"))
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%-6d %-12s %-20s %-30s %-10d %s", key.ID, key.Prefix, key.Name, key.Scopes, key.UsageCount, apiKeyState(&key)))
+	}
+	output(keys, strings.Join(lines, "\n"))
+	return nil
+}
+
+// apiKeyCreate handles "apikey create <service-account>"
+func apiKeyCreate(args []string) error {
+	fs := flag.NewFlagSet("apikey create", flag.ExitOnError)
+	name := fs.String("name", "", "name describing the integration (required)")
+	scopes := fs.String("scopes", "", "comma-separated scopes: "+strings.Join(models.APIKeyScopes, ", ")+" (required)")
+	allowIPs := fs.String("allow-ips", "", "comma-separated IP addresses or CIDR ranges (any address when empty)")
+	expiresInDays := fs.Int("expires-in-days", 0, "expire the key after n days (never when 0)")
+
+	user, _, err := findUserArg("apikey create", args, fs)
+	if err != nil {
+		return err
+	}
+	if *name == "" || *scopes == "" {
+		return fmt.Errorf("--name and --scopes are required")
+	}
+
+	var expiresAt *time.Time
+	if *expiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, *expiresInDays)
+		expiresAt = &t
+	}
+
+	key, raw, err := auth.CreateAPIKey(&user, *name, strings.Split(*scopes, ","), strings.Split(*allowIPs, ","), expiresAt, nil)
+	if err != nil {
+		return err
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventAPIKeyCreated, UserID: &user.ID, TenantID: user.TenantID, Username: user.Username, Detail: "key " + key.Prefix + " via halconctl"}, auth.ClientInfo{})
+
+	output(apiKeyCreateResult{APIKey: *key, Key: raw},
+		fmt.Sprintf("Created API key %d for %s\nKey (shown only once): %s", key.ID, user.Username, raw))
+	return nil
+}
+
+// apiKeyRevoke handles "apikey revoke <service-account> <id>"
+func apiKeyRevoke(args []string) error {
+	user, rest, err := findUserArg("apikey revoke", args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("usage: halconctl apikey revoke <service-account> <id>")
+	}
+
+	var key models.APIKey
+	if err := tenantDB().Where("id = ? AND user_id = ?", rest[0], user.ID).First(&key).Error; err != nil {
+		return fmt.Errorf("API key %s of %s not found", rest[0], user.Username)
+	}
+	if err := auth.RevokeAPIKey(&key); err != nil {
+		return err
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventAPIKeyRevoked, UserID: &user.ID, TenantID: user.TenantID, Username: user.Username, Detail: "key " + key.Prefix + " via halconctl"}, auth.ClientInfo{})
+
+	output(key, fmt.Sprintf("Revoked API key %d (%s) of %s", key.ID, key.Prefix, user.Username))
+	return nil
+}
+
+// apiKeyState describes whether a key is accepted
+func apiKeyState(key *models.APIKey) string {
+	switch {
+	case key.RevokedAt != nil:
+		return "revoked"
+	case key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()):
+		return "expired"
+	case key.ExpiresAt != nil:
+		return "active until " + key.ExpiresAt.Format("2006-01-02")
+	}
+	return "active"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// branchCommand dispatches the "branch" subcommands
+func branchCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: halconctl branch list|create ...")
+	}
+	if err := connectTenant(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return branchList()
+	case "create":
+		return branchCreate(args[1:])
+	}
+	return fmt.Errorf("unknown branch command %q", args[0])
+}
+
+// branchList handles "branch list"
+func branchList() error {
+	var branches []models.Branch
+	if err := tenantDB().Order("code").Find(&branches).Error; err != nil {
+		return fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	lines := []string{fmt.Sprintf("%-6s %-10s %-30s %-8s %s", "ID", "CODE", "NAME", "ACTIVE", "DEFAULT")}
+	for _, b := range branches {
+		lines = append(lines, fmt.Sprintf("%-6d %-10s %-30s %-8s %s", b.ID, b.Code, b.Name, yesNo(b.IsActive), yesNo(b.IsDefault)))
+	}
+	output(branches, strings.Join(lines, "\n"))
+	return nil
+}
+
+// branchCreate handles "branch create"
+func branchCreate(args []string) error {
+	fs := flag.NewFlagSet("branch create", flag.ExitOnError)
+	code := fs.String("code", "", "short unique code, e.g. MTY (required)")
+	name := fs.String("name", "", "branch name (required)")
+	address := fs.String("address", "", "address")
+	fs.Parse(args)
+
+	if *code == "" || *name == "" {
+		return fmt.Errorf("--code and --name are required")
+	}
+
+	branch := models.Branch{Code: strings.ToUpper(*code), Name: *name, Address: *address, IsActive: true}
+	if err := tenantDB().Create(&branch).Error; err != nil {
+		return fmt.Errorf("failed to create branch: %w", err)
+	}
+	output(branch, fmt.Sprintf("Created branch %s (id %d)", branch.Code, branch.ID))
+	return nil
+}
+
+// findBranch looks a branch up by code
+func findBranch(code string) (*models.Branch, error) {
+	var branch models.Branch
+	if err := tenantDB().Where("code = ?", strings.ToUpper(code)).First(&branch).Error; err != nil {
+		return nil, fmt.Errorf("branch %q not found; run \"halconctl branch list\" to see the branches", code)
+	}
+	return &branch, nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
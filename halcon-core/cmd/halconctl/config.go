@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/keys"
+	"github.com/nietzshn/halcon-core/internal/oidc"
+)
+
+// configCheckResult is one line of "config check" output
+type configCheckResult struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// configCheck handles "config check". It reports configuration problems,
+// then checks the upload directory, signing keys, the breached password
+// list, the mail directory, database connectivity and the schema version.
+func configCheck(loadErr error) error {
+	var checks []configCheckResult
+
+	add := func(name string, err error, okMessage string) {
+		if err != nil {
+			checks = append(checks, configCheckResult{Name: name, Message: err.Error()})
+			return
+		}
+		checks = append(checks, configCheckResult{Name: name, OK: true, Message: okMessage})
+	}
+
+	var validationErr *config.ValidationError
+	switch {
+	case errors.As(loadErr, &validationErr):
+		for _, problem := range validationErr.Problems {
+			add("config", errors.New(problem), "")
+		}
+	case loadErr != nil:
+		add("config", loadErr, "")
+	default:
+		add("config", nil, fmt.Sprintf("configuration is valid (ENV=%s)", config.AppConfig.Env))
+	}
+
+	// The remaining checks need a loaded configuration
+	if loadErr == nil {
+		cfg := config.AppConfig
+		add("upload_dir", checkWritableDir(cfg.UploadDir), fmt.Sprintf("%s is writable", cfg.UploadDir))
+
+		signingKeys, keysErr := keys.List(cfg.JWTKeysDir)
+		if keysErr == nil && len(signingKeys) == 0 {
+			keysErr = fmt.Errorf("no signing keys in %s; run \"halconctl keys generate\"", cfg.JWTKeysDir)
+		}
+		keysMessage := ""
+		if keysErr == nil {
+			keysMessage = fmt.Sprintf("%d key(s), signing with %s", len(signingKeys), signingKeys[0].ID)
+		}
+		add("signing_keys", keysErr, keysMessage)
+
+		if cfg.PasswordBreachedListFile != "" {
+			add("breached_passwords", auth.LoadBreachedPasswords(), fmt.Sprintf("%s is readable", cfg.PasswordBreachedListFile))
+		}
+		if cfg.MailDriver == "file" {
+			add("mail_dir", checkWritableDir(cfg.MailDir), fmt.Sprintf("%s is writable", cfg.MailDir))
+		}
+		if cfg.OIDCEnabled() {
+			oidc.Init()
+			add("oidc", oidc.Default.Discover(), fmt.Sprintf("discovered %s", cfg.OIDCIssuerURL))
+		}
+
+		dbErr := connect()
+		add("database", dbErr, fmt.Sprintf("connected to %s@%s:%s/%s", cfg.DBUser, cfg.DBHost, cfg.DBPort, cfg.DBName))
+		if dbErr == nil {
+			schemaErr := database.CheckSchema()
+			add("schema", schemaErr, "schema is up to date")
+			if schemaErr == nil {
+				add("roles", auth.CheckRoleConfig(), "configured roles exist")
+			}
+		}
+	}
+
+	var lines []string
+	failed := 0
+	for _, check := range checks {
+		status := "ok"
+		if !check.OK {
+			status = "FAIL"
+			failed++
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", status, check.Name, check.Message))
+	}
+
+	output(checks, strings.Join(lines, "\n"))
+	if failed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// configPrint handles "config print"
+func configPrint() error {
+	if jsonOutput {
+		output(config.AppConfig.Redacted(), "")
+		return nil
+	}
+
+	out, err := config.AppConfig.RedactedYAML()
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
+
+// checkWritableDir verifies that files can be created in dir
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".halconctl-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
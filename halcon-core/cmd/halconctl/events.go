@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// eventsPurge handles "events purge", removing auth events recorded more
+// than AUTH_EVENT_RETENTION_DAYS ago. The server does the same daily; this
+// is for purging on demand or with a shorter period.
+func eventsPurge(args []string) error {
+	fs := flag.NewFlagSet("events purge", flag.ExitOnError)
+	days := fs.Int("older-than-days", config.AppConfig.AuthEventRetentionDays, "purge events recorded more than this many days ago (default AUTH_EVENT_RETENTION_DAYS)")
+	dryRun := fs.Bool("dry-run", false, "only count the events that would be purged")
+	fs.Parse(args)
+
+	if *days < 1 {
+		if *days == 0 && config.AppConfig.AuthEventRetentionDays == 0 {
+			output(purgeResult{DryRun: *dryRun}, "AUTH_EVENT_RETENTION_DAYS is 0, auth events are kept")
+			return nil
+		}
+		return fmt.Errorf("--older-than-days must be at least 1")
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -*days)
+	result := purgeResult{Cutoff: cutoff, DryRun: *dryRun}
+	if *dryRun {
+		err := database.System().Model(&models.AuthEvent{}).Where("created_at < ?", cutoff).Count(&result.Count).Error
+		if err != nil {
+			return fmt.Errorf("failed to count auth events: %w", err)
+		}
+		output(result, fmt.Sprintf("%d auth event(s) recorded before %s would be purged", result.Count, cutoff.Format("2006-01-02")))
+		return nil
+	}
+
+	count, err := auth.PurgeAuthEvents(cutoff)
+	if err != nil {
+		return err
+	}
+	result.Count = count
+	output(result, fmt.Sprintf("Purged %d auth event(s) recorded before %s", count, cutoff.Format("2006-01-02")))
+	return nil
+}
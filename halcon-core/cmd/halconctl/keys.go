@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/keys"
+)
+
+// keyResult is the JSON form of a signing key
+type keyResult struct {
+	ID        string    `json:"kid"`
+	Algorithm string    `json:"alg"`
+	CreatedAt time.Time `json:"created_at"`
+	Signing   bool      `json:"signing"`
+}
+
+// rotateResult is the JSON output of "keys rotate"
+type rotateResult struct {
+	Generated keyResult `json:"generated"`
+	Removed   []string  `json:"removed"`
+}
+
+// keysCommand handles "keys list|generate|rotate|remove"
+func keysCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: halconctl keys list|generate|rotate|remove")
+	}
+
+	dir := config.AppConfig.JWTKeysDir
+	switch args[0] {
+	case "list":
+		return keysList(dir)
+	case "generate":
+		fs := flag.NewFlagSet("keys generate", flag.ExitOnError)
+		alg := fs.String("alg", config.AppConfig.JWTAlgorithm, "key algorithm: EdDSA or RS256")
+		fs.Parse(args[1:])
+
+		key, err := keys.Generate(dir, *alg)
+		if err != nil {
+			return err
+		}
+		signing, err := isSigning(dir, key)
+		if err != nil {
+			return err
+		}
+		output(newKeyResult(key, signing), fmt.Sprintf("Generated %s key %s in %s%s", key.Algorithm, key.ID, dir, signingNote(signing)))
+		return nil
+	case "rotate":
+		return keysRotate(dir, args[1:])
+	case "remove":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: halconctl keys remove <kid>")
+		}
+		return keysRemove(dir, args[1])
+	}
+	return fmt.Errorf("unknown keys command %q", args[0])
+}
+
+// keysList prints every key, newest first, marking the signing key
+func keysList(dir string) error {
+	list, err := keys.List(dir)
+	if err != nil {
+		return err
+	}
+
+	signing := keys.Signing(list, time.Now())
+	results := make([]keyResult, len(list))
+	lines := make([]string, len(list))
+	for i, key := range list {
+		results[i] = newKeyResult(key, key == signing)
+		marker := ""
+		if key == signing {
+			marker = "  (signing)"
+		}
+		lines[i] = fmt.Sprintf("%s  %-5s  %s%s", key.ID, key.Algorithm, key.CreatedAt.Format(time.RFC3339), marker)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("No keys in %s", dir))
+	}
+	output(results, strings.Join(lines, "\n"))
+	return nil
+}
+
+// keysRotate generates a new signing key and removes all but the newest
+// --keep keys. Kept older keys still verify tokens issued before rotation.
+func keysRotate(dir string, args []string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+	alg := fs.String("alg", config.AppConfig.JWTAlgorithm, "key algorithm: EdDSA or RS256")
+	keep := fs.Int("keep", 2, "number of keys to keep, including the new one")
+	fs.Parse(args)
+
+	if *keep < 2 {
+		return fmt.Errorf("--keep must be at least 2 so tokens signed by the previous key stay valid")
+	}
+
+	key, err := keys.Generate(dir, *alg)
+	if err != nil {
+		return err
+	}
+
+	list, err := keys.List(dir)
+	if err != nil {
+		return err
+	}
+	result := rotateResult{Generated: newKeyResult(key, keys.Signing(list, time.Now()).ID == key.ID), Removed: []string{}}
+	for _, old := range list[min(*keep, len(list)):] {
+		if err := keys.Remove(dir, old.ID); err != nil {
+			return err
+		}
+		result.Removed = append(result.Removed, old.ID)
+	}
+
+	text := fmt.Sprintf("Generated %s key %s%s", key.Algorithm, key.ID, signingNote(result.Generated.Signing))
+	if len(result.Removed) > 0 {
+		text += fmt.Sprintf("\nRemoved %s", strings.Join(result.Removed, ", "))
+	}
+	output(result, text)
+	return nil
+}
+
+// keysRemove deletes one key, refusing to remove the only remaining key
+func keysRemove(dir, kid string) error {
+	list, err := keys.List(dir)
+	if err != nil {
+		return err
+	}
+	if len(list) == 1 && list[0].ID == kid {
+		return fmt.Errorf("refusing to remove the only signing key")
+	}
+
+	if err := keys.Remove(dir, kid); err != nil {
+		return err
+	}
+	output(map[string]string{"removed": kid}, fmt.Sprintf("Removed key %s", kid))
+	return nil
+}
+
+// isSigning reports whether a freshly generated key already signs tokens,
+// which is only the case when it is the only key in dir
+func isSigning(dir string, key *keys.Key) (bool, error) {
+	list, err := keys.List(dir)
+	if err != nil {
+		return false, err
+	}
+	return keys.Signing(list, time.Now()).ID == key.ID, nil
+}
+
+// signingNote tells when a new key that is not signing yet takes over
+func signingNote(signing bool) string {
+	if signing {
+		return ""
+	}
+	return fmt.Sprintf("\nIt is published now and signs new tokens in %s", keys.PublishDelay)
+}
+
+func newKeyResult(key *keys.Key, signing bool) keyResult {
+	return keyResult{ID: key.ID, Algorithm: key.Algorithm, CreatedAt: key.CreatedAt, Signing: signing}
+}
@@ -0,0 +1,245 @@
+// Command halconctl is the administrative CLI for Halcon Core. It shares the
+// server's configuration and internal packages and works directly against
+// the database, so it can be used when the API is down.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/tenant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `usage: halconctl [--json] [--config <file>] [--tenant <slug>] <command> [arguments]
+
+commands:
+  user create       create a user
+  user disable      deactivate a user
+  user enable       reactivate a user
+  user reset-password
+                    set a new password for a user
+  user set-role     assign roles to a user, the first one being primary
+  user unlock       lift a login lockout
+  user reset-2fa    remove a user's two-factor authentication
+  role list         list the roles and their permissions
+  tenant list       list the tenants
+  tenant create     add a tenant with the built-in roles, a branch and an admin
+  branch list       list the branches
+  branch create     add a branch
+  apikey list       list the API keys of a service account
+  apikey create     issue an API key for a service account
+  apikey revoke     revoke an API key
+  migrate           run database migrations (up, down, status, to)
+  seed demo         create demo users and orders
+  orders purge      permanently delete soft-deleted orders older than N days
+  tokens purge      delete expired tokens and idempotency records
+  events purge      delete auth events older than the retention period
+  keys list         list the token signing keys
+  keys generate     add a signing key (EdDSA or RS256)
+  keys rotate       add a new signing key and drop all but the newest --keep
+  keys remove       delete a signing key by kid
+  config check      validate the configuration and database connectivity
+  config print      print the resolved configuration with secrets redacted
+
+User, role, branch, API key and seed commands work on the tenant named by
+--tenant, the default tenant when omitted. Order, token and event purges
+cover all tenants.
+
+Run "halconctl <command> --help" for command options.`
+
+// jsonOutput switches all command output to JSON for use in scripts
+var jsonOutput bool
+
+// tenantSlug names the tenant the commands work on
+var tenantSlug string
+
+// tenantID is the tenant named by tenantSlug, set by connectTenant
+var tenantID uint
+
+func main() {
+	global := flag.NewFlagSet("halconctl", flag.ExitOnError)
+	global.BoolVar(&jsonOutput, "json", false, "print results as JSON")
+	configFile := global.String("config", "", "path to a YAML or TOML config file (overrides CONFIG_FILE)")
+	global.StringVar(&tenantSlug, "tenant", "", "slug of the tenant to work on (default: the default tenant)")
+	global.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	global.Parse(os.Args[1:])
+
+	args := global.Args()
+	if len(args) == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+	if *configFile != "" {
+		os.Setenv(config.ConfigFileEnv, *configFile)
+	}
+
+	if err := run(args); err != nil {
+		fail(err)
+	}
+}
+
+// run dispatches to the command named by the first arguments
+func run(args []string) error {
+	loadErr := config.LoadConfig()
+
+	// config check reports configuration problems itself
+	if args[0] == "config" {
+		if len(args) < 2 {
+			return fmt.Errorf("usage: halconctl config check|print")
+		}
+		switch args[1] {
+		case "check":
+			return configCheck(loadErr)
+		case "print":
+			if loadErr != nil {
+				return loadErr
+			}
+			return configPrint()
+		}
+		return fmt.Errorf("unknown config command %q", args[1])
+	}
+	if loadErr != nil {
+		return loadErr
+	}
+
+	switch args[0] {
+	case "user":
+		if len(args) < 2 {
+			return fmt.Errorf("missing user command\n%s", usage)
+		}
+		if err := connectTenant(); err != nil {
+			return err
+		}
+		switch args[1] {
+		case "create":
+			return userCreate(args[2:])
+		case "disable":
+			return userSetActive(args[2:], false)
+		case "enable":
+			return userSetActive(args[2:], true)
+		case "reset-password":
+			return userResetPassword(args[2:])
+		case "set-role":
+			return userSetRole(args[2:])
+		case "unlock":
+			return userUnlock(args[2:])
+		case "reset-2fa":
+			return userResetTwoFactor(args[2:])
+		}
+		return fmt.Errorf("unknown user command %q\n%s", args[1], usage)
+	case "migrate":
+		if err := connect(); err != nil {
+			return err
+		}
+		return migrate(args[1:])
+	case "seed":
+		if len(args) < 2 || args[1] != "demo" {
+			return fmt.Errorf("usage: halconctl seed demo [--password <password>]")
+		}
+		if err := connectTenant(); err != nil {
+			return err
+		}
+		return seedDemo(args[2:])
+	case "orders":
+		if len(args) < 2 || args[1] != "purge" {
+			return fmt.Errorf("usage: halconctl orders purge --older-than-days <n> [--dry-run]")
+		}
+		if err := connect(); err != nil {
+			return err
+		}
+		return ordersPurge(args[2:])
+	case "role":
+		if len(args) < 2 || args[1] != "list" {
+			return fmt.Errorf("usage: halconctl role list")
+		}
+		if err := connectTenant(); err != nil {
+			return err
+		}
+		return roleList()
+	case "tenant":
+		return tenantCommand(args[1:])
+	case "branch":
+		return branchCommand(args[1:])
+	case "apikey":
+		return apiKeyCommand(args[1:])
+	case "keys":
+		return keysCommand(args[1:])
+	case "tokens":
+		if len(args) < 2 || args[1] != "purge" {
+			return fmt.Errorf("usage: halconctl tokens purge")
+		}
+		if err := connect(); err != nil {
+			return err
+		}
+		return tokensPurge()
+	case "events":
+		if len(args) < 2 || args[1] != "purge" {
+			return fmt.Errorf("usage: halconctl events purge [--older-than-days <n>] [--dry-run]")
+		}
+		if err := connect(); err != nil {
+			return err
+		}
+		return eventsPurge(args[2:])
+	}
+
+	return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+}
+
+// connect opens the database with SQL logging disabled so it doesn't
+// interleave with command output
+func connect() error {
+	database.LogLevel = logger.Silent
+	return database.Connect()
+}
+
+// connectTenant opens the database and resolves the tenant named by
+// --tenant
+func connectTenant() error {
+	if err := connect(); err != nil {
+		return err
+	}
+	if tenantSlug == "" {
+		tenantID = tenant.DefaultID
+		return nil
+	}
+
+	var t models.Tenant
+	if err := database.DB.Where("slug = ?", tenantSlug).First(&t).Error; err != nil {
+		return fmt.Errorf("tenant %q not found; run \"halconctl tenant list\" to see the tenants", tenantSlug)
+	}
+	tenantID = t.ID
+	return nil
+}
+
+// tenantDB returns the database limited to the tenant named by --tenant
+func tenantDB() *gorm.DB {
+	return database.ForTenant(tenantID)
+}
+
+// output prints v as JSON when --json is set, otherwise the text message
+func output(v interface{}, text string) {
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	fmt.Println(text)
+}
+
+// fail reports an error in the selected output format and exits
+func fail(err error) {
+	if jsonOutput {
+		json.NewEncoder(os.Stdout).Encode(map[string]string{"error": err.Error()})
+	} else {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/database"
+)
+
+// migrateResult is the JSON output of migrate commands
+type migrateResult struct {
+	Version    int64                      `json:"version"`
+	Migrations []database.MigrationStatus `json:"migrations,omitempty"`
+}
+
+// migrate handles "migrate up|down [steps]|status|to <version>"
+func migrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: halconctl migrate up|down [steps]|status|to <version>")
+	}
+
+	var err error
+	switch args[0] {
+	case "up":
+		err = database.MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+		}
+		err = database.MigrateDown(steps)
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: halconctl migrate to <version>")
+		}
+		version, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = database.MigrateTo(version)
+	case "status":
+		return migrateStatus()
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := database.CurrentVersion()
+	if err != nil {
+		return err
+	}
+	output(migrateResult{Version: version}, fmt.Sprintf("Schema is at version %d", version))
+	return nil
+}
+
+// migrateStatus prints every migration and whether it is applied
+func migrateStatus() error {
+	statuses, err := database.Status()
+	if err != nil {
+		return err
+	}
+	version, err := database.CurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Schema is at version %d\n", version)
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(&b, "  %04d %-30s %s\n", status.Version, status.Name, state)
+	}
+
+	output(migrateResult{Version: version, Migrations: statuses}, strings.TrimRight(b.String(), "\n"))
+	return nil
+}
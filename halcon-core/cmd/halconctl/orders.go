@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// purgeResult is the JSON output of "orders purge"
+type purgeResult struct {
+	Cutoff time.Time `json:"cutoff"`
+	DryRun bool      `json:"dry_run"`
+	Count  int64     `json:"count"`
+}
+
+// ordersPurge handles "orders purge", permanently removing orders that were
+// soft-deleted more than N days ago
+func ordersPurge(args []string) error {
+	fs := flag.NewFlagSet("orders purge", flag.ExitOnError)
+	days := fs.Int("older-than-days", 0, "purge orders deleted more than this many days ago (required)")
+	dryRun := fs.Bool("dry-run", false, "only count the orders that would be purged")
+	fs.Parse(args)
+
+	if *days < 1 {
+		return fmt.Errorf("--older-than-days must be at least 1")
+	}
+
+	// Soft deletes only flip is_deleted, so updated_at records when it happened
+	cutoff := time.Now().AddDate(0, 0, -*days)
+	query := database.System().Unscoped().Model(&models.Order{}).
+		Where("is_deleted = ? AND updated_at < ?", true, cutoff)
+
+	result := purgeResult{Cutoff: cutoff, DryRun: *dryRun}
+	if *dryRun {
+		if err := query.Count(&result.Count).Error; err != nil {
+			return fmt.Errorf("failed to count orders: %w", err)
+		}
+		output(result, fmt.Sprintf("%d order(s) deleted before %s would be purged", result.Count, cutoff.Format("2006-01-02")))
+		return nil
+	}
+
+	var orders []models.Order
+	if err := query.Find(&orders).Error; err != nil {
+		return fmt.Errorf("failed to load orders: %w", err)
+	}
+	if len(orders) > 0 {
+		tx := database.System().Unscoped().Delete(&orders)
+		if tx.Error != nil {
+			return fmt.Errorf("failed to purge orders: %w", tx.Error)
+		}
+		result.Count = tx.RowsAffected
+	}
+
+	// Remove the evidence photos of purged orders
+	for _, order := range orders {
+		if order.EvidencePhotoURL == "" {
+			continue
+		}
+		path := filepath.Join(config.AppConfig.UploadDir, filepath.Base(order.EvidencePhotoURL))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove %s: %v\n", path, err)
+		}
+	}
+
+	output(result, fmt.Sprintf("Purged %d order(s) deleted before %s", result.Count, cutoff.Format("2006-01-02")))
+	return nil
+}
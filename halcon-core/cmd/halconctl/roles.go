@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// roleResult is the JSON output of "role list"
+type roleResult struct {
+	models.Role
+	Permissions []models.Permission `json:"permissions"`
+}
+
+// roleList handles "role list"
+func roleList() error {
+	var roles []models.Role
+	if err := tenantDB().Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	results := make([]roleResult, len(roles))
+	lines := make([]string, 0, len(roles)+1)
+	lines = append(lines, fmt.Sprintf("%-6s %-20s %-8s %s", "ID", "NAME", "SYSTEM", "PERMISSIONS"))
+	for i, role := range roles {
+		permissions := make([]models.Permission, len(role.Permissions))
+		names := make([]string, len(role.Permissions))
+		for j, p := range role.Permissions {
+			permissions[j] = p.Permission
+			names[j] = string(p.Permission)
+		}
+		results[i] = roleResult{Role: role, Permissions: permissions}
+		lines = append(lines, fmt.Sprintf("%-6d %-20s %-8s %s", role.ID, role.Name, yesNo(role.IsSystem), strings.Join(names, ", ")))
+	}
+	output(results, strings.Join(lines, "\n"))
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+)
+
+// seedDemo handles "seed demo"
+func seedDemo(args []string) error {
+	fs := flag.NewFlagSet("seed demo", flag.ExitOnError)
+	password := fs.String("password", "", "password for the demo users (a random one is generated when empty)")
+	fs.Parse(args)
+
+	// Demo users share one password, which must never exist in production
+	if config.AppConfig.IsProduction() {
+		return fmt.Errorf("seeding demo data is not allowed when ENV=production")
+	}
+
+	generated, err := passwordOrRandom(password)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.CheckPassword(*password, ""); err != nil {
+		return err
+	}
+
+	result, err := database.SeedDemo(tenantID, *password)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Created %d user(s): %s\nCreated %d order(s): %s",
+		len(result.Users), strings.Join(result.Users, ", "),
+		len(result.Orders), strings.Join(result.Orders, ", "))
+	if generated != "" && len(result.Users) > 0 {
+		text += fmt.Sprintf("\nDemo user password: %s", generated)
+	}
+
+	output(struct {
+		*database.DemoSeedResult
+		GeneratedPassword string `json:"generated_password,omitempty"`
+	}{result, generated}, text)
+	return nil
+}
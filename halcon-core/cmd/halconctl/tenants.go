@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/tenant"
+	"gorm.io/gorm"
+)
+
+// tenantCreateResult is the JSON output of "tenant create"
+type tenantCreateResult struct {
+	Tenant            models.Tenant `json:"tenant"`
+	Admin             models.User   `json:"admin"`
+	GeneratedPassword string        `json:"generated_password,omitempty"`
+}
+
+// tenantCommand dispatches the "tenant" subcommands
+func tenantCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: halconctl tenant list|create ...")
+	}
+	if err := connect(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return tenantList()
+	case "create":
+		return tenantCreate(args[1:])
+	}
+	return fmt.Errorf("unknown tenant command %q", args[0])
+}
+
+// tenantList handles "tenant list"
+func tenantList() error {
+	var tenants []models.Tenant
+	if err := database.DB.Order("id").Find(&tenants).Error; err != nil {
+		return fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	lines := []string{fmt.Sprintf("%-6s %-20s %-30s %s", "ID", "SLUG", "NAME", "ACTIVE")}
+	for _, t := range tenants {
+		lines = append(lines, fmt.Sprintf("%-6d %-20s %-30s %s", t.ID, t.Slug, t.Name, yesNo(t.IsActive)))
+	}
+	output(tenants, strings.Join(lines, "\n"))
+	return nil
+}
+
+// tenantCreate handles "tenant create". The new tenant gets copies of the
+// default tenant's roles, a MAIN branch and an admin user, who must change
+// the password at first login.
+func tenantCreate(args []string) error {
+	fs := flag.NewFlagSet("tenant create", flag.ExitOnError)
+	slug := fs.String("slug", "", "lowercase slug used in the tenant header and as subdomain, e.g. acme (required)")
+	name := fs.String("name", "", "company name (required)")
+	logoURL := fs.String("logo-url", "", "URL of the company logo")
+	adminUsername := fs.String("admin-username", "admin", "username of the tenant's admin")
+	adminEmail := fs.String("admin-email", "", "email address of the tenant's admin")
+	password := fs.String("admin-password", "", "admin password (a random one is generated when empty)")
+	fs.Parse(args)
+
+	if *slug == "" || *name == "" {
+		return fmt.Errorf("--slug and --name are required")
+	}
+	if !auth.ValidTenantSlug(*slug) {
+		return fmt.Errorf("--slug must be lowercase letters, digits and dashes, at most 50 characters")
+	}
+
+	generated, err := passwordOrRandom(password)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(*password, *adminUsername)
+	if err != nil {
+		return err
+	}
+
+	var templates []models.Role
+	if err := database.ForTenant(tenant.DefaultID).Preload("Permissions").Order("id").Find(&templates).Error; err != nil {
+		return fmt.Errorf("failed to load roles: %w", err)
+	}
+
+	t := models.Tenant{Slug: *slug, Name: *name, LogoURL: *logoURL, IsActive: true}
+	admin := models.User{
+		Username:           *adminUsername,
+		PasswordHash:       hash,
+		Role:               models.RoleAdmin,
+		Department:         "Administration",
+		FullName:           "Administrator",
+		Email:              *adminEmail,
+		IsActive:           true,
+		MustChangePassword: true,
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&t).Error; err != nil {
+			return fmt.Errorf("failed to create tenant: %w", err)
+		}
+		tx = tx.WithContext(tenant.NewContext(context.Background(), t.ID))
+
+		for _, template := range templates {
+			role := models.Role{Name: template.Name, Description: template.Description, IsSystem: template.IsSystem}
+			for _, p := range template.Permissions {
+				role.Permissions = append(role.Permissions, models.RolePermission{Permission: p.Permission})
+			}
+			if err := tx.Create(&role).Error; err != nil {
+				return fmt.Errorf("failed to create role %s: %w", role.Name, err)
+			}
+		}
+
+		branch := models.Branch{Code: "MAIN", Name: "Main branch", IsDefault: true, IsActive: true}
+		if err := tx.Create(&branch).Error; err != nil {
+			return fmt.Errorf("failed to create branch: %w", err)
+		}
+		if err := tx.Create(&admin).Error; err != nil {
+			return fmt.Errorf("failed to create admin: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Created tenant %s (id %d) with %d role(s) and admin user %s", t.Slug, t.ID, len(templates), admin.Username)
+	if generated != "" {
+		text += fmt.Sprintf("\nGenerated password: %s", generated)
+	}
+	output(tenantCreateResult{Tenant: t, Admin: admin, GeneratedPassword: generated}, text)
+	return nil
+}
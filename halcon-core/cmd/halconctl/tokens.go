@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+)
+
+// tokensPurgeResult is the JSON output of "tokens purge"
+type tokensPurgeResult struct {
+	Count int64 `json:"count"`
+}
+
+// tokensPurge handles "tokens purge", removing refresh tokens, access token
+// revocations, password reset tokens and idempotency records that have
+// expired and can no longer be presented or replayed
+func tokensPurge() error {
+	count, err := auth.PurgeExpiredTokens(time.Now())
+	if err != nil {
+		return err
+	}
+
+	output(tokensPurgeResult{Count: count}, fmt.Sprintf("Purged %d expired token record(s)", count))
+	return nil
+}
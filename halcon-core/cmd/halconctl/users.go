@@ -0,0 +1,275 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/utils"
+)
+
+// userResult is the JSON output of user commands
+type userResult struct {
+	User              models.User `json:"user"`
+	GeneratedPassword string      `json:"generated_password,omitempty"`
+}
+
+// userCreate handles "user create"
+func userCreate(args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	username := fs.String("username", "", "username (required)")
+	password := fs.String("password", "", "password (a random one is generated when empty)")
+	role := fs.String("role", "", "primary role, e.g. Sales (required)")
+	extraRoles := fs.String("roles", "", "additional roles, comma-separated")
+	department := fs.String("department", "", "department")
+	team := fs.String("team", "", "sales team, whose members share their orders")
+	branchCode := fs.String("branch", "", "branch code (default: the default branch)")
+	fullName := fs.String("full-name", "", "full name")
+	email := fs.String("email", "", "email address")
+	mustChange := fs.Bool("must-change", true, "require a password change at first login")
+	serviceAccount := fs.Bool("service-account", false, "create a service account that authenticates with API keys only")
+	fs.Parse(args)
+
+	if *username == "" || *role == "" {
+		return fmt.Errorf("--username and --role are required")
+	}
+	extra := splitRoles(*extraRoles)
+	if _, err := auth.FindRoles(tenantDB(), append([]models.UserRole{models.UserRole(*role)}, extra...)); err != nil {
+		return fmt.Errorf("%w; run \"halconctl role list\" to see the roles", err)
+	}
+	var branchID *uint
+	if *branchCode != "" {
+		branch, err := findBranch(*branchCode)
+		if err != nil {
+			return err
+		}
+		branchID = &branch.ID
+	}
+
+	var generated, hash string
+	if *serviceAccount {
+		hash = auth.UnusablePasswordHash
+		*mustChange = false
+	} else {
+		var err error
+		if generated, err = passwordOrRandom(password); err != nil {
+			return err
+		}
+		if hash, err = auth.HashPassword(*password, *username); err != nil {
+			return err
+		}
+	}
+
+	user := models.User{
+		Username:           *username,
+		PasswordHash:       hash,
+		Role:               models.UserRole(*role),
+		Department:         *department,
+		Team:               *team,
+		BranchID:           branchID,
+		FullName:           *fullName,
+		Email:              *email,
+		IsActive:           true,
+		MustChangePassword: *mustChange,
+		IsServiceAccount:   *serviceAccount,
+	}
+	if err := tenantDB().Create(&user).Error; err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	if len(extra) > 0 {
+		if err := auth.SetUserRoles(&user, user.Role, extra); err != nil {
+			return err
+		}
+	}
+
+	text := fmt.Sprintf("Created user %s (id %d, role %s)", user.Username, user.ID, user.Role)
+	if generated != "" {
+		text += fmt.Sprintf("\nGenerated password: %s", generated)
+	}
+	output(userResult{User: user, GeneratedPassword: generated}, text)
+	return nil
+}
+
+// userSetActive handles "user disable" and "user enable"
+func userSetActive(args []string, active bool) error {
+	command := "user disable"
+	if active {
+		command = "user enable"
+	}
+
+	user, _, err := findUserArg(command, args)
+	if err != nil {
+		return err
+	}
+
+	if err := tenantDB().Model(&user).Update("is_active", active).Error; err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if !active {
+		if err := auth.RevokeUserSessions(user.ID); err != nil {
+			return err
+		}
+	}
+
+	state := "Disabled"
+	if active {
+		state = "Enabled"
+	}
+	output(userResult{User: user}, fmt.Sprintf("%s user %s", state, user.Username))
+	return nil
+}
+
+// userResetPassword handles "user reset-password"
+func userResetPassword(args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	password := fs.String("password", "", "new password (a random one is generated when empty)")
+	mustChange := fs.Bool("must-change", true, "require a password change at next login")
+
+	user, _, err := findUserArg("user reset-password", args, fs)
+	if err != nil {
+		return err
+	}
+	if user.IsServiceAccount {
+		return fmt.Errorf("%s is a service account and authenticates with API keys only", user.Username)
+	}
+
+	generated, err := passwordOrRandom(password)
+	if err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(*password, user.Username)
+	if err != nil {
+		return err
+	}
+	updates := map[string]interface{}{
+		"password_hash":        hash,
+		"must_change_password": *mustChange,
+	}
+	if err := tenantDB().Model(&user).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := auth.RevokeUserSessions(user.ID); err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Password reset for user %s", user.Username)
+	if generated != "" {
+		text += fmt.Sprintf("\nGenerated password: %s", generated)
+	}
+	output(userResult{User: user, GeneratedPassword: generated}, text)
+	return nil
+}
+
+// userSetRole handles "user set-role <username> <role> [<role>...]". The
+// first role becomes the primary one and the list replaces the user's roles.
+func userSetRole(args []string) error {
+	user, rest, err := findUserArg("user set-role", args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("usage: halconctl user set-role <username> <role> [<role>...]")
+	}
+
+	roles := make([]models.UserRole, len(rest))
+	for i, name := range rest {
+		roles[i] = models.UserRole(name)
+	}
+	if err := auth.SetUserRoles(&user, roles[0], roles[1:]); err != nil {
+		return fmt.Errorf("failed to update roles: %w", err)
+	}
+
+	output(userResult{User: user}, fmt.Sprintf("User %s now has roles %s (primary %s)", user.Username, joinRoles(roles), user.Role))
+	return nil
+}
+
+// userUnlock handles "user unlock", clearing a login lockout. Attempt
+// throttling is kept in the server's memory and expires on its own.
+func userUnlock(args []string) error {
+	user, _, err := findUserArg("user unlock", args)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.Unlock(&user); err != nil {
+		return err
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventAccountUnlocked, UserID: &user.ID, TenantID: user.TenantID, Username: user.Username, Detail: "halconctl"}, auth.ClientInfo{})
+
+	output(userResult{User: user}, fmt.Sprintf("Unlocked user %s", user.Username))
+	return nil
+}
+
+// userResetTwoFactor handles "user reset-2fa" for users who lost their
+// authenticator and recovery codes
+func userResetTwoFactor(args []string) error {
+	user, _, err := findUserArg("user reset-2fa", args)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.DisableTwoFactor(&user); err != nil {
+		return err
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventTwoFactorDisabled, UserID: &user.ID, TenantID: user.TenantID, Username: user.Username, Detail: "halconctl"}, auth.ClientInfo{})
+
+	output(userResult{User: user}, fmt.Sprintf("Two-factor authentication removed for user %s", user.Username))
+	return nil
+}
+
+// findUserArg loads the user named by the first positional argument and
+// parses the remaining arguments with the optional flag set
+func findUserArg(command string, args []string, flags ...*flag.FlagSet) (models.User, []string, error) {
+	var user models.User
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return user, nil, fmt.Errorf("usage: halconctl %s <username> [options]", command)
+	}
+
+	rest := args[1:]
+	for _, fs := range flags {
+		fs.Parse(rest)
+		rest = fs.Args()
+	}
+
+	if err := tenantDB().Where("username = ?", args[0]).First(&user).Error; err != nil {
+		return user, nil, fmt.Errorf("user %q not found", args[0])
+	}
+	return user, rest, nil
+}
+
+// passwordOrRandom fills an empty password with a random one and returns
+// the generated value so it can be shown once
+func passwordOrRandom(password *string) (string, error) {
+	if *password != "" {
+		return "", nil
+	}
+
+	generated, err := utils.RandomToken(12)
+	if err != nil {
+		return "", err
+	}
+	*password = generated
+	return generated, nil
+}
+
+// splitRoles parses a comma-separated list of role names
+func splitRoles(list string) []models.UserRole {
+	var roles []models.UserRole
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			roles = append(roles, models.UserRole(name))
+		}
+	}
+	return roles
+}
+
+func joinRoles(roles []models.UserRole) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,276 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying single
+// sign-on locally. Its login page accepts any user and lets you choose the
+// claims, including groups and department. It is for development only and
+// must never be exposed.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyID identifies the signing key, which is generated at every start
+const keyID = "mockoidc-1"
+
+// authRequest is a pending authorization code with the claims chosen on
+// the login page
+type authRequest struct {
+	ClientID      string
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	Claims        jwt.MapClaims
+	ExpiresAt     time.Time
+}
+
+type server struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authRequest
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock OIDC login</title>
+<style>body{font-family:sans-serif;max-width:420px;margin:40px auto}label{display:block;margin-top:12px}input{width:100%;padding:6px}</style>
+</head>
+<body>
+<h2>Mock OIDC login</h2>
+<p>Development identity provider. Every field becomes an ID token claim.</p>
+<form method="post" action="/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}
+<label>Subject <input name="sub" value="mock-user-1" required></label>
+<label>Username <input name="preferred_username" value="jdoe"></label>
+<label>Name <input name="name" value="Jane Doe"></label>
+<label>Email <input name="email" value="jdoe@example.com"></label>
+<label><input type="checkbox" name="email_verified" value="true" checked style="width:auto"> Email verified</label>
+<label>Groups (comma-separated) <input name="groups" value="halcon-sales"></label>
+<label>Department <input name="department" value="Sales"></label>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, must match OIDC_ISSUER_URL")
+	clientID := flag.String("client-id", "halcon", "accepted client ID")
+	clientSecret := flag.String("client-secret", "halcon-secret", "accepted client secret")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal("Failed to generate signing key: ", err)
+	}
+
+	s := &server{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        map[string]*authRequest{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+
+	log.Printf("Mock OIDC provider listening on %s with issuer %s", *addr, s.issuer)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (s *server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize shows the login page and, once submitted, redirects back to
+// the client with an authorization code
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("client_id") != s.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("response_type") != "code" || r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		params := map[string]string{}
+		for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params[name] = r.Form.Get(name)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, map[string]interface{}{"Params": params})
+		return
+	}
+
+	claims := jwt.MapClaims{"sub": r.PostForm.Get("sub")}
+	for _, name := range []string{"preferred_username", "name", "email", "department"} {
+		if value := strings.TrimSpace(r.PostForm.Get(name)); value != "" {
+			claims[name] = value
+		}
+	}
+	claims["email_verified"] = r.PostForm.Get("email_verified") == "true"
+	groups := []string{}
+	for _, group := range strings.Split(r.PostForm.Get("groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	claims["groups"] = groups
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = &authRequest{
+		ClientID:      s.clientID,
+		RedirectURI:   redirectURI.String(),
+		Nonce:         r.Form.Get("nonce"),
+		CodeChallenge: r.Form.Get("code_challenge"),
+		Claims:        claims,
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", r.Form.Get("state"))
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token redeems an authorization code for an ID token after checking the
+// client credentials, redirect URI and PKCE verifier
+func (s *server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.clientID || secret != s.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	req := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || req == nil || time.Now().After(req.ExpiresAt) ||
+		req.RedirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != req.CodeChallenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   s.issuer,
+		"aud":   req.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": req.Nonce,
+	}
+	for name, value := range req.Claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		http.Error(w, "failed to sign token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (s *server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Failed to read random bytes: ", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
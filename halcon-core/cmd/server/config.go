@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+)
+
+// runConfig handles the "config" subcommand
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: server config print")
+	}
+
+	out, err := config.AppConfig.RedactedYAML()
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
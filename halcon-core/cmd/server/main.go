@@ -1,35 +1,104 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
 	"github.com/nietzshn/halcon-core/internal/config"
 	"github.com/nietzshn/halcon-core/internal/database"
 	"github.com/nietzshn/halcon-core/internal/handlers"
+	"github.com/nietzshn/halcon-core/internal/keys"
+	"github.com/nietzshn/halcon-core/internal/mailer"
 	custommw "github.com/nietzshn/halcon-core/internal/middleware"
 	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/oidc"
+	"github.com/nietzshn/halcon-core/internal/setup"
+	"github.com/nietzshn/halcon-core/internal/validation"
 )
 
 func main() {
+	configFile := flag.String("config", "", "path to a YAML or TOML config file (overrides CONFIG_FILE)")
+	flag.Parse()
+	if *configFile != "" {
+		os.Setenv(config.ConfigFileEnv, *configFile)
+	}
+
 	// Load configuration
-	config.LoadConfig()
+	if err := config.LoadConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Commands that don't need the database
+	if flag.Arg(0) == "config" {
+		if err := runConfig(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// Connect to database
 	if err := database.Connect(); err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	// Run migrations
-	if err := database.Migrate(); err != nil {
-		log.Fatal("Failed to migrate database:", err)
+	// Subcommands
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "migrate":
+			if err := runMigrate(flag.Args()[1:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		default:
+			log.Fatalf("Unknown command %q", flag.Arg(0))
+		}
+	}
+
+	// Apply migrations only when explicitly enabled, otherwise refuse to
+	// start against an outdated schema
+	if config.AppConfig.AutoMigrate {
+		if err := database.MigrateUp(); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+	if err := database.CheckSchema(); err != nil {
+		log.Fatal("Refusing to start: ", err)
+	}
+
+	// Roles named in the configuration must exist in the roles table
+	if err := auth.CheckRoleConfig(); err != nil {
+		log.Fatal("Refusing to start: ", err)
+	}
+
+	// Load the token signing keys
+	if err := keys.Init(); err != nil {
+		log.Fatal("Failed to load signing keys: ", err)
 	}
 
-	// Seed initial data
-	if err := database.Seed(); err != nil {
-		log.Fatal("Failed to seed database:", err)
+	// Fail fast on an unreadable breached password list
+	if err := auth.LoadBreachedPasswords(); err != nil {
+		log.Fatal("Failed to load password policy: ", err)
+	}
+
+	// Select the mail transport for password reset emails
+	if err := mailer.Init(); err != nil {
+		log.Fatal("Failed to configure mailer: ", err)
+	}
+
+	// Single sign-on discovers the identity provider on first use
+	oidc.Init()
+
+	// Delete auth events past their retention period, now and daily
+	auth.StartEventRetention()
+
+	// Create the first admin or activate the one-time setup token
+	if err := setup.Init(); err != nil {
+		log.Fatal("Failed to bootstrap:", err)
 	}
 
 	// Create uploads directory
@@ -39,15 +108,25 @@ func main() {
 
 	// Initialize Echo
 	e := echo.New()
+	e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	e.Validator = validation.New()
 
 	// Middleware
+	allowHeaders := []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, custommw.HeaderAPIKey, custommw.HeaderIdempotencyKey}
+	if config.AppConfig.TenantHeader != "" {
+		allowHeaders = append(allowHeaders, config.AppConfig.TenantHeader)
+	}
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{config.AppConfig.CORSAllowedOrigins},
-		AllowMethods: []string{echo.GET, echo.POST, echo.PUT, echo.DELETE, echo.PATCH},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowOrigins:  []string{config.AppConfig.CORSAllowedOrigins},
+		AllowMethods:  []string{echo.GET, echo.POST, echo.PUT, echo.DELETE, echo.PATCH},
+		AllowHeaders:  allowHeaders,
+		ExposeHeaders: []string{echo.HeaderXRequestID, custommw.HeaderIdempotentReplayed, handlers.HeaderTotalCount},
 	}))
+	// Every request belongs to a tenant; see custommw.TenantMiddleware
+	e.Use(custommw.TenantMiddleware())
 
 	// Static files for uploads
 	e.Static("/uploads", config.AppConfig.UploadDir)
@@ -56,55 +135,114 @@ func main() {
 	e.GET("/health", func(c echo.Context) error {
 		return c.JSON(200, map[string]string{"status": "ok"})
 	})
+	e.GET("/.well-known/jwks.json", handlers.GetJWKS)
 	e.POST("/api/auth/login", handlers.Login)
+	e.POST("/api/auth/refresh", handlers.RefreshToken)
+	e.POST("/api/auth/2fa/verify", handlers.VerifyTwoFactorLogin)
+	e.POST("/api/auth/forgot-password", handlers.ForgotPassword)
+	e.POST("/api/auth/reset-password", handlers.ResetPassword)
+	e.POST("/api/auth/accept-invitation", handlers.AcceptInvitation)
+	e.GET("/api/auth/sso", handlers.GetSSOConfig)
+	e.GET("/api/auth/oidc/login", handlers.SSOLogin)
+	e.GET("/api/auth/oidc/callback", handlers.SSOCallback)
+	e.POST("/api/auth/oidc/exchange", handlers.SSOExchange)
+	e.GET("/api/setup", handlers.GetSetupStatus)
+	e.POST("/api/setup", handlers.CompleteSetup)
 	e.GET("/api/track", handlers.TrackOrder)
 	e.POST("/api/track", handlers.TrackOrder)
+	e.GET("/api/tenant", handlers.GetTenant)
 
 	// Protected routes
 	api := e.Group("/api")
 	api.Use(custommw.AuthMiddleware())
+	api.Use(custommw.IdempotencyMiddleware())
 
 	// Auth routes
 	api.GET("/auth/me", handlers.GetCurrentUser)
-
-	// User management routes (Admin only)
+	api.POST("/auth/change-password", handlers.ChangePassword)
+	api.POST("/auth/logout", handlers.Logout)
+	api.GET("/auth/events", handlers.GetAuthEvents, custommw.RequirePermission(models.PermAuthEventsRead))
+	api.GET("/auth/events/anomalies", handlers.GetAuthAnomalies, custommw.RequirePermission(models.PermAuthEventsRead))
+
+	// Two-factor authentication for the current user
+	api.GET("/auth/2fa", handlers.GetTwoFactorStatus)
+	api.POST("/auth/2fa/setup", handlers.SetupTwoFactor)
+	api.POST("/auth/2fa/enable", handlers.EnableTwoFactor)
+	api.POST("/auth/2fa/disable", handlers.DisableTwoFactor)
+	api.POST("/auth/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+
+	// User management routes
 	users := api.Group("/users")
-	users.Use(custommw.RoleMiddleware(models.RoleAdmin))
-	users.GET("", handlers.GetUsers)
-	users.GET("/:id", handlers.GetUser)
-	users.POST("", handlers.CreateUser)
-	users.PUT("/:id", handlers.UpdateUser)
-	users.DELETE("/:id", handlers.DeleteUser)
+	users.GET("", handlers.GetUsers, custommw.RequirePermission(models.PermUsersRead))
+	users.GET("/:id", handlers.GetUser, custommw.RequirePermission(models.PermUsersRead))
+	users.POST("", handlers.CreateUser, custommw.RequirePermission(models.PermUsersManage))
+	// Both take a JSON Merge Patch; PUT is kept for older clients
+	users.PATCH("/:id", handlers.UpdateUser, custommw.RequirePermission(models.PermUsersManage))
+	users.PUT("/:id", handlers.UpdateUser, custommw.RequirePermission(models.PermUsersManage))
+	users.DELETE("/:id", handlers.DeleteUser, custommw.RequirePermission(models.PermUsersManage))
+	users.POST("/:id/unlock", handlers.UnlockUser, custommw.RequirePermission(models.PermUsersManage))
+	users.POST("/:id/restore", handlers.RestoreUser, custommw.RequirePermission(models.PermUsersManage))
+	users.POST("/:id/reset-2fa", handlers.ResetUserTwoFactor, custommw.RequirePermission(models.PermUsersManage))
+	users.POST("/:id/impersonate", handlers.ImpersonateUser, custommw.RequirePermission(models.PermUsersImpersonate))
+
+	// Invitations emailed to new users, accepted at /api/auth/accept-invitation
+	invitations := api.Group("/invitations")
+	invitations.Use(custommw.RequirePermission(models.PermUsersManage))
+	invitations.GET("", handlers.GetInvitations)
+	invitations.POST("", handlers.CreateInvitation)
+	invitations.POST("/:id/resend", handlers.ResendInvitation)
+	invitations.DELETE("/:id", handlers.RevokeInvitation)
+
+	// Roles and the permissions they bundle
+	api.GET("/permissions", handlers.GetPermissions, custommw.RequirePermission(models.PermRolesManage))
+	roles := api.Group("/roles")
+	roles.Use(custommw.RequirePermission(models.PermRolesManage))
+	roles.GET("", handlers.GetRoles)
+	roles.POST("", handlers.CreateRole)
+	roles.PUT("/:id", handlers.UpdateRole)
+	roles.DELETE("/:id", handlers.DeleteRole)
+
+	// Company name, logo and order workflow settings of the tenant
+	api.PUT("/tenant", handlers.UpdateTenant, custommw.RequirePermission(models.PermTenantManage))
+
+	// Branches
+	api.GET("/branches", handlers.GetBranches)
+	api.POST("/branches", handlers.CreateBranch, custommw.RequirePermission(models.PermBranchesManage))
+	api.PUT("/branches/:id", handlers.UpdateBranch, custommw.RequirePermission(models.PermBranchesManage))
+
+	// Service accounts and their API keys
+	serviceAccounts := api.Group("/service-accounts")
+	serviceAccounts.Use(custommw.RequirePermission(models.PermServiceAccountsManage))
+	serviceAccounts.GET("", handlers.GetServiceAccounts)
+	serviceAccounts.POST("", handlers.CreateServiceAccount)
+	serviceAccounts.GET("/:id/keys", handlers.GetAPIKeys)
+	serviceAccounts.POST("/:id/keys", handlers.CreateAPIKey)
+	serviceAccounts.DELETE("/:id/keys/:key_id", handlers.RevokeAPIKey)
 
 	// Order routes
 	orders := api.Group("/orders")
 
-	// All authenticated users can view orders (with role-based filtering in handler)
-	orders.GET("", handlers.GetOrders)
-	orders.GET("/:id", handlers.GetOrder)
-
-	// Sales can create orders
-	orders.POST("", handlers.CreateOrder, custommw.RoleMiddleware(models.RoleSales))
-
-	// Warehouse and Route can update orders
-	orders.PUT("/:id", handlers.UpdateOrder, custommw.RoleMiddleware(
-		models.RoleWarehouse,
-		models.RoleRoute,
-		models.RoleSales,
-	))
-
-	// Soft delete and restore (Admin and Sales)
-	orders.DELETE("/:id", handlers.SoftDeleteOrder, custommw.RoleMiddleware(
-		models.RoleAdmin,
-		models.RoleSales,
-	))
-	orders.POST("/:id/restore", handlers.RestoreOrder, custommw.RoleMiddleware(
-		models.RoleAdmin,
-		models.RoleSales,
-	))
-
-	// Upload evidence (Route only)
-	orders.POST("/:id/evidence", handlers.UploadEvidence, custommw.RoleMiddleware(models.RoleRoute))
+	// Users limited to orders.read.in_process only see orders in process
+	orders.GET("", handlers.GetOrders, custommw.RequirePermission(models.PermOrdersRead, models.PermOrdersReadInProcess))
+	orders.GET("/:id", handlers.GetOrder, custommw.RequirePermission(models.PermOrdersRead, models.PermOrdersReadInProcess))
+	orders.POST("", handlers.CreateOrder, custommw.RequirePermission(models.PermOrdersCreate))
+
+	// Both take a JSON Merge Patch; PUT is kept for older clients. The order
+	// edit policy decides which fields the caller may change.
+	orderEditors := custommw.RequirePermission(models.PermOrdersUpdate,
+		models.PermOrdersTransitionProcess, models.PermOrdersTransitionRoute, models.PermOrdersTransitionDeliver)
+	orders.PATCH("/:id", handlers.UpdateOrder, orderEditors)
+	orders.PUT("/:id", handlers.UpdateOrder, orderEditors)
+
+	// Soft delete and restore
+	orders.DELETE("/:id", handlers.SoftDeleteOrder, custommw.RequirePermission(models.PermOrdersDelete))
+	orders.POST("/:id/restore", handlers.RestoreOrder, custommw.RequirePermission(models.PermOrdersRestore))
+
+	orders.POST("/:id/evidence", handlers.UploadEvidence, custommw.RequirePermission(models.PermEvidenceUpload))
+
+	// Cross-branch transfers
+	orders.POST("/:id/transfer", handlers.TransferOrder, custommw.RequirePermission(models.PermOrdersTransfer))
+	orders.GET("/:id/transfers", handlers.GetOrderTransfers, custommw.RequirePermission(models.PermOrdersRead, models.PermOrdersReadInProcess))
 
 	// Start server
 	port := config.AppConfig.Port
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/nietzshn/halcon-core/internal/database"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  up              apply all pending migrations
+  down [steps]    roll back the last applied migration (or the last <steps>)
+  status          list migrations and whether they are applied
+  to <version>    migrate up or down to the given version (0 rolls back everything)`
+
+// runMigrate handles the "migrate" subcommand
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		return database.MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid steps %q: %w", args[1], err)
+			}
+			steps = n
+		}
+		return database.MigrateDown(steps)
+	case "status":
+		return printMigrationStatus()
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("%s", migrateUsage)
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[1], err)
+		}
+		return database.MigrateTo(version)
+	}
+
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
+
+// printMigrationStatus writes a table of migrations to stdout
+func printMigrationStatus() error {
+	statuses, err := database.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+		if status.Applied {
+			state = "applied"
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
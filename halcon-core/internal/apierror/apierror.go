@@ -0,0 +1,195 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Stable machine-readable error codes returned in the "code" member of
+// every problem response. Clients may rely on these, so never rename one.
+const (
+	CodeBadRequest          = "bad_request"
+	CodeValidationFailed    = "validation_failed"
+	CodeUnauthorized        = "unauthorized"
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeInvalidToken        = "invalid_token"
+	CodeTokenRevoked        = "token_revoked"
+	CodeInvalidAPIKey       = "invalid_api_key"
+	CodeInvalidRefresh      = "invalid_refresh_token"
+	CodeRefreshReused       = "refresh_token_reused"
+	CodeAccountDisabled     = "account_disabled"
+	CodeAccountLocked       = "account_locked"
+	CodeForbidden           = "forbidden"
+	CodeInsufficientScope   = "insufficient_scope"
+	CodePasswordChange      = "password_change_required"
+	CodeTwoFactorSetup      = "two_factor_setup_required"
+	CodeInvalidTwoFactor    = "invalid_two_factor_code"
+	CodeTwoFactorState      = "two_factor_state_conflict"
+	CodeInvalidResetToken   = "invalid_reset_token"
+	CodeInvalidInvitation   = "invalid_invitation"
+	CodePasswordLoginOff    = "password_login_disabled"
+	CodeSSONotConfigured    = "sso_not_configured"
+	CodeInvalidSSOCode      = "invalid_sso_code"
+	CodeSetupCompleted      = "setup_already_completed"
+	CodeInvalidSetupToken   = "invalid_setup_token"
+	CodeNotFound            = "not_found"
+	CodeUserNotFound        = "user_not_found"
+	CodeOrderNotFound       = "order_not_found"
+	CodeTenantNotFound      = "tenant_not_found"
+	CodeTenantMismatch      = "tenant_mismatch"
+	CodeImpersonation       = "not_allowed_while_impersonating"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeConflict            = "conflict"
+	CodeDuplicateValue      = "duplicate_value"
+	CodeReferenceViolation  = "reference_violation"
+	CodeRequestTooLarge     = "request_too_large"
+	CodeUnsupportedMedia    = "unsupported_media_type"
+	CodeUnprocessable       = "unprocessable_entity"
+	CodeInvalidTransition   = "invalid_status_transition"
+	CodeFieldNotEditable    = "field_not_editable"
+	CodeIdempotencyMismatch = "idempotency_key_mismatch"
+	CodeIdempotencyPending  = "idempotency_key_in_progress"
+	CodeTooManyRequests     = "too_many_requests"
+	CodeInternal            = "internal_error"
+)
+
+// FieldError describes a problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an API error carrying an HTTP status, a stable code and
+// optional per-field details. Handlers return it and HTTPErrorHandler
+// renders it as application/problem+json.
+type Error struct {
+	Status   int
+	Code     string
+	Message  string
+	Fields   []FieldError
+	Internal error
+}
+
+// New creates an API error
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Internal != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Internal)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the underlying error, if any
+func (e *Error) Unwrap() error {
+	return e.Internal
+}
+
+// WithFields attaches per-field details to the error
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	e.Fields = append(e.Fields, fields...)
+	return e
+}
+
+// Wrap records the underlying cause. It is logged but never sent to clients.
+func (e *Error) Wrap(err error) *Error {
+	e.Internal = err
+	return e
+}
+
+// Postgres SQLSTATE codes mapped by FromDB
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+	pgNotNullViolation    = "23502"
+	pgCheckViolation      = "23514"
+	pgStringTooLong       = "22001"
+)
+
+// pgKeyDetail extracts the column list from a detail such as
+// "Key (invoice_number)=(INV-1) already exists."
+var pgKeyDetail = regexp.MustCompile(`Key \(([^)]+)\)=`)
+
+// FromDB converts a database error into an API error. Known Postgres
+// constraint failures get a specific status and name the offending field;
+// anything else becomes a 500 using the given code and message.
+func FromDB(err error, code, message string) *Error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return New(http.StatusNotFound, CodeNotFound, "record not found").Wrap(err)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return New(http.StatusInternalServerError, code, message).Wrap(err)
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		field := constraintField(pgErr)
+		return New(http.StatusConflict, CodeDuplicateValue, fmt.Sprintf("%s already exists", field)).
+			WithFields(FieldError{Field: field, Code: "duplicate", Message: "value is already in use"}).
+			Wrap(err)
+	case pgForeignKeyViolation:
+		field := constraintField(pgErr)
+		return New(http.StatusConflict, CodeReferenceViolation, fmt.Sprintf("%s references a record that does not exist or is still in use", field)).
+			WithFields(FieldError{Field: field, Code: "reference", Message: "invalid reference"}).
+			Wrap(err)
+	case pgNotNullViolation:
+		return New(http.StatusUnprocessableEntity, CodeValidationFailed, "a required field is missing").
+			WithFields(FieldError{Field: pgErr.ColumnName, Code: "required", Message: "field is required"}).
+			Wrap(err)
+	case pgCheckViolation:
+		return New(http.StatusUnprocessableEntity, CodeValidationFailed, "a field has an invalid value").
+			WithFields(FieldError{Field: constraintField(pgErr), Code: "invalid", Message: "value is not allowed"}).
+			Wrap(err)
+	case pgStringTooLong:
+		return New(http.StatusUnprocessableEntity, CodeValidationFailed, "a field value is too long").Wrap(err)
+	}
+
+	return New(http.StatusInternalServerError, code, message).Wrap(err)
+}
+
+// IsUniqueViolation reports whether err is a Postgres unique violation of
+// the named constraint
+func IsUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == constraint
+}
+
+// constraintField names the column behind a constraint violation. The
+// tenant_id column of per-tenant unique indexes is left out.
+func constraintField(pgErr *pgconn.PgError) string {
+	if m := pgKeyDetail.FindStringSubmatch(pgErr.Detail); m != nil {
+		var columns []string
+		for _, column := range strings.Split(m[1], ",") {
+			if column = strings.TrimSpace(column); column != "tenant_id" {
+				columns = append(columns, column)
+			}
+		}
+		if len(columns) > 0 {
+			return strings.Join(columns, ",")
+		}
+	}
+	if pgErr.ColumnName != "" {
+		return pgErr.ColumnName
+	}
+
+	// Fall back to the constraint name, e.g. idx_orders_invoice_number
+	name := strings.Replace(pgErr.ConstraintName, "_tenant_", "_", 1)
+	if pgErr.TableName != "" {
+		if i := strings.Index(name, pgErr.TableName+"_"); i >= 0 {
+			return name[i+len(pgErr.TableName)+1:]
+		}
+	}
+	return name
+}
@@ -0,0 +1,114 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MIMEProblemJSON is the RFC 7807 media type used for error responses
+const MIMEProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem details document extended with a stable
+// error code, the request ID and per-field errors
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// HTTPErrorHandler renders every error returned by a handler or middleware
+// as application/problem+json. Install it on the Echo instance.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	apiErr := toAPIError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		c.Logger().Errorf("%s %s: %v", c.Request().Method, c.Request().URL.Path, err)
+	}
+
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(apiErr.Status),
+		Status:    apiErr.Status,
+		Detail:    apiErr.Message,
+		Instance:  c.Request().URL.Path,
+		Code:      apiErr.Code,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		Errors:    apiErr.Fields,
+	}
+
+	var writeErr error
+	if c.Request().Method == http.MethodHead {
+		writeErr = c.NoContent(apiErr.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEProblemJSON)
+		writeErr = c.JSON(apiErr.Status, problem)
+	}
+	if writeErr != nil {
+		c.Logger().Error(writeErr)
+	}
+}
+
+// toAPIError normalises any error into an *Error
+func toAPIError(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		// Errors raised by Echo itself (routing, binding, body limit) and by
+		// third-party middleware
+		if he.Internal != nil && errors.As(he.Internal, &apiErr) {
+			return apiErr
+		}
+		message := http.StatusText(he.Code)
+		if m, ok := he.Message.(string); ok {
+			message = m
+		} else if he.Message != nil {
+			message = fmt.Sprint(he.Message)
+		}
+		return New(he.Code, codeForStatus(he.Code), message).Wrap(he.Internal)
+	}
+
+	return New(http.StatusInternalServerError, CodeInternal, "internal server error").Wrap(err)
+}
+
+// codeForStatus picks a generic error code for errors that don't carry one
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeRequestTooLarge
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every API key so it can be told apart from a JWT
+const APIKeyPrefix = "hk_"
+
+// apiKeyDisplayLength is how much of a key is kept in clear to identify it
+const apiKeyDisplayLength = 11
+
+var (
+	// ErrInvalidAPIKey is returned for unknown, revoked or expired keys
+	ErrInvalidAPIKey = errors.New("invalid or expired API key")
+	// ErrAPIKeyIPNotAllowed is returned when the caller's IP is not allowlisted
+	ErrAPIKeyIPNotAllowed = errors.New("API key is not allowed from this address")
+	// ErrNotServiceAccount is returned when creating a key for a regular user
+	ErrNotServiceAccount = errors.New("API keys can only be created for service accounts")
+)
+
+// HasScope reports whether the key was granted scope
+func HasScope(key *models.APIKey, scope string) bool {
+	for _, s := range strings.Split(key.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeIPAllowlist validates IP addresses and CIDR ranges and returns
+// them in the comma-separated form stored on the key, with single
+// addresses turned into /32 or /128 ranges
+func NormalizeIPAllowlist(entries []string) (string, error) {
+	normalized := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return "", fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		normalized = append(normalized, network.String())
+	}
+	return strings.Join(normalized, ","), nil
+}
+
+// CreateAPIKey issues a key for a service account and returns it together
+// with the raw key, which is not stored and can't be shown again
+func CreateAPIKey(user *models.User, name string, scopes []string, allowedIPs []string, expiresAt *time.Time, actorID *uint) (*models.APIKey, string, error) {
+	if !user.IsServiceAccount {
+		return nil, "", ErrNotServiceAccount
+	}
+	for _, scope := range scopes {
+		if !models.IsAPIKeyScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	allowlist, err := NormalizeIPAllowlist(allowedIPs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	raw := APIKeyPrefix + secret
+
+	key := &models.APIKey{
+		UserID:     user.ID,
+		Name:       name,
+		Prefix:     raw[:apiKeyDisplayLength],
+		KeyHash:    HashToken(raw),
+		Scopes:     strings.Join(scopes, ","),
+		AllowedIPs: allowlist,
+		ExpiresAt:  expiresAt,
+		CreatedBy:  actorID,
+	}
+	if err := database.DB.Create(key).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to store API key: %w", err)
+	}
+	return key, raw, nil
+}
+
+// AuthenticateAPIKey looks up an active key, checks the caller's IP against
+// its allowlist and records the use. The key is returned with
+// ErrAPIKeyIPNotAllowed so the rejection can be attributed.
+func AuthenticateAPIKey(raw, ip string) (*models.APIKey, error) {
+	if !strings.HasPrefix(raw, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key models.APIKey
+	err := database.DB.
+		Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", HashToken(raw), time.Now()).
+		First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API key: %w", err)
+	}
+
+	if !ipAllowed(key.AllowedIPs, ip) {
+		return &key, ErrAPIKeyIPNotAllowed
+	}
+
+	now := time.Now()
+	err = database.DB.Model(&key).UpdateColumns(map[string]interface{}{
+		"last_used_at": now,
+		"last_used_ip": truncate(ip, 64),
+		"usage_count":  gorm.Expr("usage_count + 1"),
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to record API key use: %w", err)
+	}
+	key.LastUsedAt = &now
+	key.LastUsedIP = ip
+	key.UsageCount++
+	return &key, nil
+}
+
+// RevokeAPIKey stops a key from being accepted
+func RevokeAPIKey(key *models.APIKey) error {
+	if key.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	if err := database.DB.Model(key).UpdateColumn("revoked_at", now).Error; err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	key.RevokedAt = &now
+	return nil
+}
+
+// ipAllowed reports whether ip falls in one of the comma-separated ranges.
+// An empty allowlist accepts every address.
+func ipAllowed(allowlist, ip string) bool {
+	if allowlist == "" {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range strings.Split(allowlist, ",") {
+		if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
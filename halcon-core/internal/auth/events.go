@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// RecordEvent appends an entry to the authentication event log of the
+// event's tenant, else the tenant the request was addressed to. Failing to
+// record is logged but never fails the request that triggered it.
+func RecordEvent(event models.AuthEvent, client ClientInfo) {
+	if event.TenantID == 0 {
+		event.TenantID = client.TenantID
+	}
+	event.Outcome = event.Type.Outcome()
+	event.IPAddress = client.IPAddress
+	event.UserAgent = truncate(client.UserAgent, 500)
+	event.Detail = truncate(event.Detail, 255)
+
+	if err := database.System().Create(&event).Error; err != nil {
+		log.Printf("Failed to record auth event %s for %q: %v", event.Type, event.Username, err)
+	}
+}
+
+// eventPurgeInterval is how often the server applies the retention period
+const eventPurgeInterval = 24 * time.Hour
+
+// StartEventRetention purges auth events older than
+// AUTH_EVENT_RETENTION_DAYS now and then daily, in the background. It does
+// nothing when the retention is 0.
+func StartEventRetention() {
+	days := config.AppConfig.AuthEventRetentionDays
+	if days <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(eventPurgeInterval)
+		defer ticker.Stop()
+		for {
+			count, err := PurgeAuthEvents(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				log.Printf("Failed to apply auth event retention: %v", err)
+			} else if count > 0 {
+				log.Printf("Purged %d auth event(s) older than %d days", count, days)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// PurgeAuthEvents deletes the auth events of every tenant recorded before
+// the cutoff and returns how many were removed
+func PurgeAuthEvents(cutoff time.Time) (int64, error) {
+	result := database.System().Where("created_at < ?", cutoff).Delete(&models.AuthEvent{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge auth events: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/mailer"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidInvitation is returned for unknown, accepted, revoked or
+// expired invitation tokens
+var ErrInvalidInvitation = errors.New("invalid or expired invitation")
+
+// ErrInvitationClosed is returned when resending or revoking an invitation
+// that was already accepted or revoked
+var ErrInvitationClosed = errors.New("invitation was already accepted or revoked")
+
+// ErrUsernameTaken is returned when an invitee picks a username that is
+// already in use in the tenant
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// CreateInvitation stores an invitation made by actor and emails its link.
+// An earlier open invitation for the same address is revoked, so only the
+// newest link works.
+func CreateInvitation(db *gorm.DB, inv *models.Invitation, actorID uint, client ClientInfo) error {
+	raw, err := issueInvitationToken(inv)
+	if err != nil {
+		return err
+	}
+	inv.InvitedBy = actorID
+	inv.SentCount = 1
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Invitation{}).
+			Where("LOWER(email) = LOWER(?) AND accepted_at IS NULL AND revoked_at IS NULL", inv.Email).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("failed to revoke earlier invitations: %w", err)
+		}
+		return tx.Create(inv).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	sendInvitation(inv, raw, actorID, client)
+	return nil
+}
+
+// ResendInvitation emails a new link for an open invitation and restarts
+// its expiry. The previous link stops working.
+func ResendInvitation(db *gorm.DB, inv *models.Invitation, actorID uint, client ClientInfo) error {
+	if status := inv.Status(time.Now()); status == models.InvitationAccepted || status == models.InvitationRevoked {
+		return ErrInvitationClosed
+	}
+	raw, err := issueInvitationToken(inv)
+	if err != nil {
+		return err
+	}
+	inv.SentCount++
+
+	result := db.Model(inv).
+		Where("accepted_at IS NULL AND revoked_at IS NULL").
+		Updates(map[string]interface{}{
+			"token_hash":   inv.TokenHash,
+			"expires_at":   inv.ExpiresAt,
+			"sent_count":   inv.SentCount,
+			"last_sent_at": inv.LastSentAt,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update invitation: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationClosed
+	}
+
+	sendInvitation(inv, raw, actorID, client)
+	return nil
+}
+
+// RevokeInvitation stops the link of an open invitation from working
+func RevokeInvitation(db *gorm.DB, inv *models.Invitation, actorID uint, client ClientInfo) error {
+	now := time.Now()
+	result := db.Model(inv).
+		Where("accepted_at IS NULL AND revoked_at IS NULL").
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationClosed
+	}
+	inv.RevokedAt = &now
+
+	RecordEvent(models.AuthEvent{Type: models.EventInvitationRevoked, ActorID: &actorID, Detail: inv.Email}, client)
+	return nil
+}
+
+// AcceptInvitation creates the invited user with the chosen username and
+// password and consumes the invitation. The user gets the invitation's
+// role, department and branch and can log in right away.
+func AcceptInvitation(raw, username, fullName, password string, client ClientInfo) (*models.User, error) {
+	// The secret token identifies the invitation whichever tenant the link
+	// is opened in
+	var inv models.Invitation
+	err := database.System().
+		Where("token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", HashToken(raw), time.Now()).
+		First(&inv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invitation: %w", err)
+	}
+
+	hash, err := HashPassword(password, username)
+	if err != nil {
+		return nil, err
+	}
+	if fullName == "" {
+		fullName = inv.FullName
+	}
+
+	user := models.User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         inv.Role,
+		Department:   inv.Department,
+		BranchID:     inv.BranchID,
+		FullName:     fullName,
+		Email:        inv.Email,
+		IsActive:     true,
+	}
+	err = database.ForTenant(inv.TenantID).Transaction(func(tx *gorm.DB) error {
+		// Deleted users keep their username
+		var taken int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&taken).Error; err != nil {
+			return fmt.Errorf("failed to check username: %w", err)
+		}
+		if taken > 0 {
+			return ErrUsernameTaken
+		}
+
+		roles, err := FindRoles(tx, []models.UserRole{inv.Role})
+		if err != nil {
+			return err
+		}
+		// User.AfterCreate assigns the role in user_roles
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		user.Roles = roles
+
+		// Claim the invitation so concurrent requests can't both use it
+		result := tx.Model(&inv).
+			Where("accepted_at IS NULL AND revoked_at IS NULL").
+			Updates(map[string]interface{}{"accepted_at": time.Now(), "user_id": user.ID})
+		if result.Error != nil {
+			return fmt.Errorf("failed to accept invitation: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidInvitation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	RecordEvent(models.AuthEvent{Type: models.EventInvitationAccepted, TenantID: inv.TenantID, UserID: &user.ID, Username: user.Username, ActorID: &inv.InvitedBy, Detail: inv.Email}, client)
+	return &user, nil
+}
+
+// issueInvitationToken gives the invitation a new token and expiry and
+// returns the raw token
+func issueInvitationToken(inv *models.Invitation) (string, error) {
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	inv.TokenHash = HashToken(raw)
+	inv.ExpiresAt = now.Add(time.Duration(config.AppConfig.InvitationTTLHours) * time.Hour)
+	inv.LastSentAt = &now
+	return raw, nil
+}
+
+// sendInvitation records the invitation email and sends it in the
+// background
+func sendInvitation(inv *models.Invitation, raw string, actorID uint, client ClientInfo) {
+	RecordEvent(models.AuthEvent{Type: models.EventInvitationSent, ActorID: &actorID, Detail: inv.Email}, client)
+
+	msg := invitationMessage(inv, raw)
+	go func() {
+		if err := mailer.Send(msg); err != nil {
+			log.Printf("Failed to send invitation email: %v", err)
+		}
+	}()
+}
+
+// invitationMessage builds the invitation email
+func invitationMessage(inv *models.Invitation, raw string) mailer.Message {
+	name := inv.FullName
+	if name == "" {
+		name = inv.Email
+	}
+	company := "Halcon"
+	link := strings.TrimRight(config.AppConfig.PublicURL, "/") + "/accept-invitation?token=" + url.QueryEscape(raw)
+	if tenant, err := TenantByID(inv.TenantID); err == nil {
+		company = tenant.Name
+		// The web client remembers the tenant for the login that follows
+		if config.AppConfig.MultiTenant {
+			link += "&tenant=" + url.QueryEscape(tenant.Slug)
+		}
+	}
+
+	body := fmt.Sprintf(`Hello %s,
+
+You have been invited to join %s on Halcon as %s.
+Open this link within %d hours to choose your username and password:
+
+%s
+
+If you weren't expecting this invitation, you can ignore this email.
+`, name, company, inv.Role, config.AppConfig.InvitationTTLHours, link)
+
+	return mailer.Message{
+		To:      (&mail.Address{Name: inv.FullName, Address: inv.Email}).String(),
+		Subject: fmt.Sprintf("You're invited to %s on Halcon", company),
+		Body:    body,
+	}
+}
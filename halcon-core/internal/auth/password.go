@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UnusablePasswordHash is stored for service accounts and single sign-on
+// users. It is not a bcrypt hash, so no password ever matches it.
+const UnusablePasswordHash = "!"
+
+// maxPasswordBytes is the longest password bcrypt can hash
+const maxPasswordBytes = 72
+
+// PasswordPolicyError lists the rules a password breaks
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Problems, "; ")
+}
+
+var (
+	breachedMu   sync.Mutex
+	breachedPath string
+	breachedSet  map[string]struct{} // upper-case SHA-1 hex of breached passwords
+)
+
+// CheckPassword applies the password policy: PASSWORD_MIN_LENGTH, the bcrypt
+// length limit, not containing the username and not appearing in the
+// PASSWORD_BREACHED_LIST_FILE. It returns a *PasswordPolicyError when the
+// password is rejected.
+func CheckPassword(password, username string) error {
+	var problems []string
+
+	if n := utf8.RuneCountInString(password); n < config.AppConfig.PasswordMinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", config.AppConfig.PasswordMinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes", maxPasswordBytes))
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		problems = append(problems, "must not contain the username")
+	}
+
+	breached, err := isBreached(password)
+	if err != nil {
+		return err
+	}
+	if breached {
+		problems = append(problems, "appears in a list of breached passwords, choose another one")
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
+
+// HashPassword checks the password against the policy and returns its
+// bcrypt hash
+func HashPassword(password, username string) (string, error) {
+	if err := CheckPassword(password, username); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// dummyPasswordHash is checked when there is no usable hash, so that a
+// failed login takes as long whether or not the account exists
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("halcon dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// VerifyPassword reports whether the password matches the user's hash. A
+// nil user or one without a usable hash never matches, but still costs a
+// bcrypt comparison.
+func VerifyPassword(user *models.User, password string) bool {
+	if user == nil || user.PasswordHash == UnusablePasswordHash {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// LoadBreachedPasswords reads PASSWORD_BREACHED_LIST_FILE so a missing or
+// unreadable file is reported at startup rather than on first use
+func LoadBreachedPasswords() error {
+	_, err := isBreached("")
+	return err
+}
+
+// isBreached looks the password up in the breached list, loading the file
+// on first use
+func isBreached(password string) (bool, error) {
+	path := config.AppConfig.PasswordBreachedListFile
+	if path == "" {
+		return false, nil
+	}
+
+	breachedMu.Lock()
+	defer breachedMu.Unlock()
+
+	if breachedSet == nil || breachedPath != path {
+		set, err := loadBreachedList(path)
+		if err != nil {
+			return false, err
+		}
+		breachedSet, breachedPath = set, path
+	}
+
+	_, ok := breachedSet[sha1Hex(password)]
+	return ok, nil
+}
+
+// loadBreachedList reads a breached password file. Each line holds either a
+// plain password or an SHA-1 hash, optionally followed by ":count" as in
+// the Have I Been Pwned downloads.
+func loadBreachedList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	set := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			set[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		set[sha1Hex(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return set, nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/mailer"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidResetToken is returned for unknown, used or expired password
+// reset tokens
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// ResetThrottle limits how often password reset emails can be requested
+// per IP address and per email address
+var ResetThrottle = NewThrottle()
+
+// ResetThrottleKey is the ResetThrottle key for an email address
+func ResetThrottleKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// RequestPasswordReset emails a reset link to every active user with the
+// address who may log in with a password. Unknown addresses are silently ignored so callers can't probe
+// which accounts exist, and the email is sent in the background so the
+// response time doesn't tell either.
+func RequestPasswordReset(email string, client ClientInfo) error {
+	var users []models.User
+	if err := database.ForTenant(client.TenantID).Where("LOWER(email) = LOWER(?) AND is_active = ? AND is_service_account = ?", email, true, false).Find(&users).Error; err != nil {
+		return fmt.Errorf("failed to look up users: %w", err)
+	}
+
+	for i := range users {
+		user := &users[i]
+		if !PasswordLoginAllowed(user) {
+			continue
+		}
+		raw, err := createResetToken(user.ID, client)
+		if err != nil {
+			return err
+		}
+		RecordEvent(models.AuthEvent{Type: models.EventPasswordResetSent, UserID: &user.ID, Username: user.Username}, client)
+
+		msg := resetMessage(user, raw)
+		go func() {
+			if err := mailer.Send(msg); err != nil {
+				log.Printf("Failed to send password reset email: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token. The token is
+// consumed, any lockout is lifted and every session of the user is
+// revoked. Two-factor authentication still applies at the next login.
+func ResetPassword(raw, password string, client ClientInfo) (*models.User, error) {
+	var token models.PasswordResetToken
+	err := database.DB.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", HashToken(raw), time.Now()).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load password reset token: %w", err)
+	}
+
+	// The secret token identifies the user whichever tenant the link is
+	// opened in
+	var user models.User
+	err = database.System().Where("id = ? AND is_active = ?", token.UserID, true).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	hash, err := HashPassword(password, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = userDB(&user).Transaction(func(tx *gorm.DB) error {
+		// Claim the token so concurrent requests can't both use it
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return fmt.Errorf("failed to use password reset token: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		// Older links stop working once the password was reset
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete password reset tokens: %w", err)
+		}
+
+		return tx.Model(&user).UpdateColumns(map[string]interface{}{
+			"password_hash":        hash,
+			"must_change_password": false,
+			"failed_login_count":   0,
+			"locked_until":         nil,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.PasswordHash = hash
+	user.MustChangePassword = false
+	user.FailedLoginCount = 0
+	user.LockedUntil = nil
+	InvalidateUser(user.ID)
+	LoginThrottle.Reset(UserThrottleKey(user.TenantID, user.Username))
+
+	if err := RevokeUserSessions(user.ID); err != nil {
+		return nil, err
+	}
+	RecordEvent(models.AuthEvent{Type: models.EventPasswordReset, TenantID: user.TenantID, UserID: &user.ID, Username: user.Username}, client)
+	return &user, nil
+}
+
+// createResetToken stores the hash of a new reset token for the user,
+// replacing any unused one, and returns the raw token
+func createResetToken(userID uint, client ClientInfo) (string, error) {
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    userID,
+			TokenHash: HashToken(raw),
+			ExpiresAt: time.Now().Add(time.Duration(config.AppConfig.PasswordResetTTLMinutes) * time.Minute),
+			IPAddress: client.IPAddress,
+		}).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store password reset token: %w", err)
+	}
+	return raw, nil
+}
+
+// resetMessage builds the password reset email
+func resetMessage(user *models.User, raw string) mailer.Message {
+	name := user.FullName
+	if name == "" {
+		name = user.Username
+	}
+	link := strings.TrimRight(config.AppConfig.PublicURL, "/") + "/reset-password?token=" + url.QueryEscape(raw)
+
+	body := fmt.Sprintf(`Hello %s,
+
+Someone asked to reset the password of your Halcon account %q.
+Open this link within %d minutes to choose a new password:
+
+%s
+
+If you didn't ask for this, you can ignore this email and your password
+stays the same.
+`, name, user.Username, config.AppConfig.PasswordResetTTLMinutes, link)
+
+	return mailer.Message{
+		To:      (&mail.Address{Name: user.FullName, Address: user.Email}).String(),
+		Subject: "Reset your Halcon password",
+		Body:    body,
+	}
+}
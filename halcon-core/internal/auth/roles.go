@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRoleNotFound is returned for unknown role IDs
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrSystemRole is returned when deleting a built-in role
+	ErrSystemRole = errors.New("built-in roles can't be deleted")
+	// ErrRoleInUse is returned when deleting a role users still have
+	ErrRoleInUse = errors.New("role is still assigned to users")
+	// ErrLastRoleManager is returned when a change would leave no active
+	// user able to manage roles
+	ErrLastRoleManager = errors.New("at least one active user must keep the roles.manage permission")
+)
+
+// UnknownRoleError is returned when a role name doesn't exist
+type UnknownRoleError struct {
+	Name models.UserRole
+}
+
+func (e *UnknownRoleError) Error() string {
+	return fmt.Sprintf("unknown role %q", e.Name)
+}
+
+// PermissionSet holds the permissions a user has through their roles
+type PermissionSet map[models.Permission]bool
+
+// Has reports whether the set contains any of the permissions
+func (s PermissionSet) Has(permissions ...models.Permission) bool {
+	for _, p := range permissions {
+		if s[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the permissions sorted by name
+func (s PermissionSet) List() []models.Permission {
+	list := make([]models.Permission, 0, len(s))
+	for p := range s {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// FindRoles loads the named roles in the given order, returning an
+// *UnknownRoleError for the first name that doesn't exist
+func FindRoles(db *gorm.DB, names []models.UserRole) ([]models.Role, error) {
+	var found []models.Role
+	if err := db.Where("name IN ?", names).Find(&found).Error; err != nil {
+		return nil, fmt.Errorf("failed to load roles: %w", err)
+	}
+
+	byName := make(map[models.UserRole]models.Role, len(found))
+	for _, role := range found {
+		byName[role.Name] = role
+	}
+	roles := make([]models.Role, 0, len(names))
+	for _, name := range names {
+		role, ok := byName[name]
+		if !ok {
+			return nil, &UnknownRoleError{Name: name}
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// RoleChange replaces the roles of a user with Primary and Extra
+type RoleChange struct {
+	Primary models.UserRole
+	Extra   []models.UserRole
+}
+
+// SetUserRoles makes primary the user's primary role and replaces the
+// user's roles with primary and extra, taken from the user's tenant
+func SetUserRoles(user *models.User, primary models.UserRole, extra []models.UserRole) error {
+	err := database.ForTenant(user.TenantID).Transaction(func(tx *gorm.DB) error {
+		if err := replaceUserRoles(tx, user, RoleChange{Primary: primary, Extra: extra}); err != nil {
+			return err
+		}
+		return ensureRoleManager(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	InvalidateUser(user.ID)
+	return nil
+}
+
+// replaceUserRoles applies a role change within a transaction
+func replaceUserRoles(tx *gorm.DB, user *models.User, change RoleChange) error {
+	names := []models.UserRole{change.Primary}
+	for _, name := range change.Extra {
+		if !containsRole(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	roles, err := FindRoles(tx, names)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(user).Update("role", change.Primary).Error; err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&userRole{}).Error; err != nil {
+		return fmt.Errorf("failed to update roles: %w", err)
+	}
+	for _, role := range roles {
+		if err := tx.Create(&userRole{UserID: user.ID, RoleID: role.ID}).Error; err != nil {
+			return fmt.Errorf("failed to update roles: %w", err)
+		}
+	}
+	user.Role = change.Primary
+	user.Roles = roles
+	return nil
+}
+
+// CreateRole defines a new role of a tenant granting the permissions
+func CreateRole(tenantID uint, name models.UserRole, description string, permissions []models.Permission) (*models.Role, error) {
+	role := models.Role{Name: name, Description: description, Permissions: rolePermissions(permissions)}
+	if err := database.ForTenant(tenantID).Create(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// UpdateRole replaces the description and permissions of a tenant's role.
+// Users holding it get the new permissions with their next request.
+func UpdateRole(tenantID, id uint, description string, permissions []models.Permission) (*models.Role, error) {
+	var role models.Role
+	err := database.ForTenant(tenantID).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", id).First(&role).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRoleNotFound
+			}
+			return err
+		}
+
+		if err := tx.Model(&role).Update("description", description).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		role.Permissions = rolePermissions(permissions)
+		for i := range role.Permissions {
+			role.Permissions[i].RoleID = role.ID
+		}
+		if len(role.Permissions) > 0 {
+			if err := tx.Create(&role.Permissions).Error; err != nil {
+				return err
+			}
+		}
+		return ensureRoleManager(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	InvalidateAllUsers()
+	return &role, nil
+}
+
+// DeleteRole removes a custom role of a tenant that no user holds
+func DeleteRole(tenantID, id uint) error {
+	return database.ForTenant(tenantID).Transaction(func(tx *gorm.DB) error {
+		var role models.Role
+		if err := tx.Where("id = ?", id).First(&role).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRoleNotFound
+			}
+			return err
+		}
+		if role.IsSystem {
+			return ErrSystemRole
+		}
+
+		var count int64
+		if err := tx.Model(&userRole{}).Where("role_id = ?", role.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrRoleInUse
+		}
+		return tx.Delete(&role).Error
+	})
+}
+
+// CheckRoleConfig verifies that the roles named in TWO_FACTOR_REQUIRED_ROLES,
+// OIDC_ROLE_MAPPING and OIDC_DEFAULT_ROLE exist in every active tenant
+func CheckRoleConfig() error {
+	cfg := config.AppConfig
+	settings := map[string][]models.UserRole{}
+	for _, role := range strings.Split(cfg.TwoFactorRequiredRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			settings["TWO_FACTOR_REQUIRED_ROLES"] = append(settings["TWO_FACTOR_REQUIRED_ROLES"], models.UserRole(role))
+		}
+	}
+	if cfg.OIDCEnabled() {
+		for _, entry := range strings.Split(cfg.OIDCRoleMapping, ",") {
+			if _, role, ok := strings.Cut(entry, "="); ok {
+				settings["OIDC_ROLE_MAPPING"] = append(settings["OIDC_ROLE_MAPPING"], models.UserRole(strings.TrimSpace(role)))
+			}
+		}
+		if cfg.OIDCDefaultRole != "" {
+			settings["OIDC_DEFAULT_ROLE"] = []models.UserRole{models.UserRole(cfg.OIDCDefaultRole)}
+		}
+	}
+
+	var tenants []models.Tenant
+	if err := database.DB.Where("is_active = ?", true).Order("id").Find(&tenants).Error; err != nil {
+		return fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	var problems []string
+	for _, tenant := range tenants {
+		for setting, names := range settings {
+			if _, err := FindRoles(database.ForTenant(tenant.ID), names); err != nil {
+				if len(tenants) > 1 {
+					setting = fmt.Sprintf("%s (tenant %s)", setting, tenant.Slug)
+				}
+				problems = append(problems, fmt.Sprintf("%s: %v", setting, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// UserPermissions returns the permissions a user's roles grant, whether or
+// not the user is active
+func UserPermissions(db *gorm.DB, userID uint) (PermissionSet, error) {
+	_, permissions, err := userAccess(db, userID)
+	return permissions, err
+}
+
+// userAccess loads the role names and permissions of a user
+func userAccess(db *gorm.DB, userID uint) ([]models.UserRole, PermissionSet, error) {
+	var roles []models.UserRole
+	err := db.Model(&models.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Pluck("roles.name", &roles).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load roles: %w", err)
+	}
+
+	var permissions []models.Permission
+	err = db.Model(&models.RolePermission{}).
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Distinct().
+		Pluck("role_permissions.permission", &permissions).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load permissions: %w", err)
+	}
+
+	set := make(PermissionSet, len(permissions))
+	for _, p := range permissions {
+		set[p] = true
+	}
+	return roles, set, nil
+}
+
+// ensureRoleManager fails with ErrLastRoleManager when no active person can
+// manage roles any more, which would lock every admin out
+func ensureRoleManager(tx *gorm.DB) error {
+	var count int64
+	err := tx.Model(&models.User{}).
+		Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Joins("JOIN role_permissions ON role_permissions.role_id = user_roles.role_id").
+		Where("role_permissions.permission = ? AND users.is_active = ? AND users.is_service_account = ?", models.PermRolesManage, true, false).
+		Distinct("users.id").
+		Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check role managers: %w", err)
+	}
+	if count == 0 {
+		return ErrLastRoleManager
+	}
+	return nil
+}
+
+// userRole is a row of the user_roles join table
+type userRole struct {
+	UserID uint `gorm:"primaryKey"`
+	RoleID uint `gorm:"primaryKey"`
+}
+
+func (userRole) TableName() string {
+	return "user_roles"
+}
+
+func rolePermissions(permissions []models.Permission) []models.RolePermission {
+	seen := map[models.Permission]bool{}
+	rows := make([]models.RolePermission, 0, len(permissions))
+	for _, p := range permissions {
+		if !seen[p] {
+			seen[p] = true
+			rows = append(rows, models.RolePermission{Permission: p})
+		}
+	}
+	return rows
+}
+
+func containsRole(roles []models.UserRole, role models.UserRole) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// JoinPermissions lists permissions for messages, e.g. "a or b"
+func JoinPermissions(permissions []models.Permission) string {
+	parts := make([]string, len(permissions))
+	for i, p := range permissions {
+		parts[i] = string(p)
+	}
+	return strings.Join(parts, " or ")
+}
@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/oidc"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// ssoStateTTL is how long a user has to finish signing in at the
+	// identity provider
+	ssoStateTTL = 10 * time.Minute
+	// ssoHandoffTTL is how long the web client has to redeem the code it
+	// receives after the callback
+	ssoHandoffTTL = time.Minute
+	// maxUsernameLength matches the users.username column
+	maxUsernameLength = 50
+)
+
+var (
+	// ErrInvalidSSOState is returned for unknown, used or expired login states
+	ErrInvalidSSOState = errors.New("invalid or expired single sign-on state")
+	// ErrInvalidSSOHandoff is returned for unknown, used or expired handoff codes
+	ErrInvalidSSOHandoff = errors.New("invalid or expired single sign-on code")
+	// ErrSSONoRole is returned when no role can be derived from the claims
+	ErrSSONoRole = errors.New("no role is mapped to the identity provider groups")
+	// ErrSSOUnknownUser is returned when the identity has no Halcon user and
+	// OIDC_AUTO_PROVISION is off
+	ErrSSOUnknownUser = errors.New("no user is linked to this identity")
+	// ErrSSOUserInactive is returned when the linked user is deactivated
+	ErrSSOUserInactive = errors.New("user is inactive")
+)
+
+// usernameUnsafe matches characters not kept in provisioned usernames
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// PasswordLoginAllowed reports whether the user may sign in with a
+// password. OIDC_DISABLE_PASSWORD_LOGIN limits users linked to the identity
+// provider to single sign-on.
+func PasswordLoginAllowed(user *models.User) bool {
+	return !(user.IsSSO() && config.AppConfig.OIDCDisablePasswordLogin)
+}
+
+// BeginSSOLogin stores a new login state and returns the identity provider
+// URL to send the browser to together with the raw state, which the caller
+// binds to the browser. redirectPath is where the web client goes after
+// signing in; the login signs in a user of the given tenant.
+func BeginSSOLogin(redirectPath string, tenantID uint) (authURL, state string, err error) {
+	if oidc.Default == nil {
+		return "", "", oidc.ErrNotConfigured
+	}
+
+	state, err = utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := utils.RandomToken(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err = oidc.Default.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = database.ForTenant(tenantID).Create(&models.OIDCLoginState{
+		StateHash:    HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		RedirectPath: redirectPath,
+		ExpiresAt:    time.Now().Add(ssoStateTTL),
+	}).Error
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store single sign-on state: %w", err)
+	}
+	return authURL, state, nil
+}
+
+// CompleteSSOLogin consumes the login state, redeems the authorization
+// code and returns the signed-in user, provisioning or updating it from the
+// ID token claims, together with the redirect path given at the start. The
+// callback URL is shared by all tenants, so the tenant is the one stored
+// with the state.
+func CompleteSSOLogin(state, code string, client ClientInfo) (*models.User, string, error) {
+	if oidc.Default == nil {
+		return nil, "", oidc.ErrNotConfigured
+	}
+
+	var login models.OIDCLoginState
+	err := database.System().Where("state_hash = ? AND expires_at > ?", HashToken(state), time.Now()).First(&login).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", ErrInvalidSSOState
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load single sign-on state: %w", err)
+	}
+
+	// Deleting claims the state so a replayed callback is refused
+	result := database.System().Delete(&models.OIDCLoginState{}, login.ID)
+	if result.Error != nil {
+		return nil, "", fmt.Errorf("failed to use single sign-on state: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, "", ErrInvalidSSOState
+	}
+
+	claims, err := oidc.Default.Exchange(code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client.TenantID = login.TenantID
+	user, err := syncSSOUser(claims, client)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, login.RedirectPath, nil
+}
+
+// IssueSSOHandoff stores a one-time code the web client exchanges for the
+// session of a completed single sign-on login. Tokens are never put in the
+// callback URL.
+func IssueSSOHandoff(userID uint) (string, error) {
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = database.DB.Create(&models.SSOHandoff{
+		CodeHash:  HashToken(raw),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ssoHandoffTTL),
+	}).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to store single sign-on code: %w", err)
+	}
+	return raw, nil
+}
+
+// RedeemSSOHandoff consumes a handoff code and returns its active user,
+// who must belong to the tenant the request was addressed to
+func RedeemSSOHandoff(raw string, client ClientInfo) (*models.User, error) {
+	var handoff models.SSOHandoff
+	err := database.DB.Where("code_hash = ? AND expires_at > ?", HashToken(raw), time.Now()).First(&handoff).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSSOHandoff
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load single sign-on code: %w", err)
+	}
+
+	result := database.DB.Delete(&models.SSOHandoff{}, handoff.ID)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to use single sign-on code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidSSOHandoff
+	}
+
+	var user models.User
+	err = database.ForTenant(client.TenantID).Where("id = ? AND is_active = ?", handoff.UserID, true).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSSOHandoff
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return &user, nil
+}
+
+// SSORoles maps the groups claim to roles using OIDC_ROLE_MAPPING, in
+// mapping order, falling back to OIDC_DEFAULT_ROLE when no group matches.
+// The first role becomes the primary one.
+func SSORoles(claims *oidc.Claims) []models.UserRole {
+	cfg := config.AppConfig
+	groups := map[string]bool{}
+	for _, group := range claims.StringsClaim(cfg.OIDCGroupsClaim) {
+		groups[group] = true
+	}
+
+	var roles []models.UserRole
+	for _, entry := range strings.Split(cfg.OIDCRoleMapping, ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && groups[strings.TrimSpace(group)] {
+			if name := models.UserRole(strings.TrimSpace(role)); !containsRole(roles, name) {
+				roles = append(roles, name)
+			}
+		}
+	}
+	if len(roles) == 0 && cfg.OIDCDefaultRole != "" {
+		roles = append(roles, models.UserRole(cfg.OIDCDefaultRole))
+	}
+	return roles
+}
+
+// syncSSOUser finds the user linked to the identity, links an existing
+// user with the same verified email or provisions a new one, then updates
+// roles, department and profile from the claims. The identity provider is
+// the source of truth for SSO users, so changes there apply at the next
+// login. Users are looked up and created in the tenant of the client.
+func syncSSOUser(claims *oidc.Claims, client ClientInfo) (*models.User, error) {
+	roles := SSORoles(claims)
+	if len(roles) == 0 {
+		return nil, ErrSSONoRole
+	}
+
+	db := database.ForTenant(client.TenantID)
+	var user models.User
+	err := db.Where("oidc_issuer = ? AND oidc_subject = ?", claims.Issuer, claims.Subject).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		found, err := linkSSOUser(db, claims)
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			return provisionSSOUser(db, claims, roles, client)
+		}
+		user = *found
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	if !user.IsActive {
+		return nil, ErrSSOUserInactive
+	}
+
+	updates := map[string]interface{}{
+		"oidc_issuer":  claims.Issuer,
+		"oidc_subject": claims.Subject,
+	}
+	if department := claims.StringClaim(config.AppConfig.OIDCDepartmentClaim); department != "" {
+		updates["department"] = department
+	}
+	if claims.Name != "" {
+		updates["full_name"] = claims.Name
+	}
+	if claims.Email != "" && claims.EmailVerified {
+		updates["email"] = claims.Email
+	}
+	if err := db.Model(&user).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	if err := SetUserRoles(&user, roles[0], roles[1:]); err != nil {
+		return nil, fmt.Errorf("failed to update roles: %w", err)
+	}
+	return &user, nil
+}
+
+// linkSSOUser returns the active, unlinked user whose email matches the
+// verified email of the identity, or nil. Unverified emails are never used
+// so an identity provider account can't take over a Halcon user.
+func linkSSOUser(db *gorm.DB, claims *oidc.Claims) (*models.User, error) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, nil
+	}
+
+	var users []models.User
+	err := db.Where("LOWER(email) = LOWER(?) AND oidc_subject = '' AND is_service_account = ?", claims.Email, false).
+		Limit(2).Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	// An address shared by several users doesn't say which one to link
+	if len(users) != 1 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
+// provisionSSOUser creates the user of a new identity when
+// OIDC_AUTO_PROVISION is on. It gets no usable password.
+func provisionSSOUser(db *gorm.DB, claims *oidc.Claims, roles []models.UserRole, client ClientInfo) (*models.User, error) {
+	if !config.AppConfig.OIDCAutoProvision {
+		return nil, ErrSSOUnknownUser
+	}
+
+	username, err := uniqueUsername(db, ssoUsername(claims))
+	if err != nil {
+		return nil, err
+	}
+
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+	user := models.User{
+		Username:     username,
+		PasswordHash: UnusablePasswordHash,
+		Role:         roles[0],
+		Department:   claims.StringClaim(config.AppConfig.OIDCDepartmentClaim),
+		FullName:     claims.Name,
+		Email:        email,
+		IsActive:     true,
+		OIDCIssuer:   claims.Issuer,
+		OIDCSubject:  claims.Subject,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	if len(roles) > 1 {
+		if err := SetUserRoles(&user, roles[0], roles[1:]); err != nil {
+			return nil, fmt.Errorf("failed to assign roles: %w", err)
+		}
+	}
+
+	RecordEvent(models.AuthEvent{Type: models.EventSSOUserCreated, UserID: &user.ID, Username: user.Username, Detail: "roles " + joinRoleNames(roles)}, client)
+	return &user, nil
+}
+
+// ssoUsername picks the preferred username, else the local part of the
+// email, else the subject
+func ssoUsername(claims *oidc.Claims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+	candidate = strings.Trim(usernameUnsafe.ReplaceAllString(candidate, "-"), "-.")
+	if candidate == "" {
+		candidate = "sso-" + usernameUnsafe.ReplaceAllString(claims.Subject, "")
+	}
+	if len(candidate) > maxUsernameLength {
+		candidate = candidate[:maxUsernameLength]
+	}
+	return candidate
+}
+
+// uniqueUsername appends a number to base until no user of the tenant has
+// the name
+func uniqueUsername(db *gorm.DB, base string) (string, error) {
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			suffix := "-" + strconv.Itoa(i)
+			if len(candidate)+len(suffix) > maxUsernameLength {
+				candidate = candidate[:maxUsernameLength-len(suffix)]
+			}
+			candidate += suffix
+		}
+
+		var count int64
+		if err := db.Unscoped().Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", fmt.Errorf("failed to check username: %w", err)
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
+
+// joinRoleNames lists role names for event details
+func joinRoleNames(roles []models.UserRole) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ", ")
+}
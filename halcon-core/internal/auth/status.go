@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrUserInactive is returned for users that were deactivated or deleted
+var ErrUserInactive = errors.New("user is inactive")
+
+// UserStatus is the authorization-relevant state of a user as stored in
+// the database, which takes precedence over the claims of a token
+type UserStatus struct {
+	TenantID           uint
+	Username           string
+	Role               models.UserRole   // primary role
+	Roles              []models.UserRole // every role, the primary one included
+	Permissions        PermissionSet
+	Department         string
+	Team               string
+	BranchID           *uint
+	MustChangePassword bool
+	TwoFactorEnabled   bool
+}
+
+type statusEntry struct {
+	status    *UserStatus // nil for inactive or deleted users
+	expiresAt time.Time
+}
+
+var (
+	statusMu    sync.Mutex
+	statusCache = map[uint]statusEntry{}
+)
+
+// CurrentStatus returns the current status of an active user, served from a
+// short-lived in-memory cache. It returns ErrUserInactive when the user was
+// deactivated or deleted.
+func CurrentStatus(userID uint) (*UserStatus, error) {
+	now := time.Now()
+
+	statusMu.Lock()
+	entry, ok := statusCache[userID]
+	statusMu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		if entry.status == nil {
+			return nil, ErrUserInactive
+		}
+		return entry.status, nil
+	}
+
+	var user models.User
+	// User IDs are unique across tenants; callers check the tenant
+	err := database.System().Select("id", "tenant_id", "username", "role", "department", "team", "branch_id", "is_active", "must_change_password", "totp_enabled").First(&user, userID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load user status: %w", err)
+	}
+
+	entry = statusEntry{expiresAt: now.Add(time.Duration(config.AppConfig.UserStatusCacheSeconds) * time.Second)}
+	if err == nil && user.IsActive {
+		roles, permissions, err := userAccess(database.System(), user.ID)
+		if err != nil {
+			return nil, err
+		}
+		entry.status = &UserStatus{
+			TenantID:           user.TenantID,
+			Username:           user.Username,
+			Role:               user.Role,
+			Roles:              roles,
+			Permissions:        permissions,
+			Department:         user.Department,
+			Team:               user.Team,
+			BranchID:           user.BranchID,
+			MustChangePassword: user.MustChangePassword,
+			TwoFactorEnabled:   user.TOTPEnabled,
+		}
+	}
+
+	statusMu.Lock()
+	statusCache[userID] = entry
+	statusMu.Unlock()
+
+	if entry.status == nil {
+		return nil, ErrUserInactive
+	}
+	return entry.status, nil
+}
+
+// InvalidateUser drops the cached status of a user so the next request sees
+// changes to their roles or active flag immediately
+func InvalidateUser(userID uint) {
+	statusMu.Lock()
+	delete(statusCache, userID)
+	statusMu.Unlock()
+}
+
+// InvalidateAllUsers empties the status cache, used when the permissions of
+// a role change
+func InvalidateAllUsers() {
+	statusMu.Lock()
+	statusCache = map[uint]statusEntry{}
+	statusMu.Unlock()
+}
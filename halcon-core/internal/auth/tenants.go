@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrTenantNotFound is returned for unknown or inactive tenants
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// tenantCacheTTL bounds how long a change to a tenant's settings can take
+// to reach other server processes
+const tenantCacheTTL = 30 * time.Second
+
+// tenantSlugPattern is the accepted form of a tenant slug, which doubles as
+// a subdomain
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,48}[a-z0-9])?$`)
+
+type tenantEntry struct {
+	tenant    *models.Tenant // nil for unknown or inactive tenants
+	expiresAt time.Time
+}
+
+var (
+	tenantMu     sync.Mutex
+	tenantBySlug = map[string]tenantEntry{}
+	tenantByID   = map[uint]tenantEntry{}
+)
+
+// ValidTenantSlug reports whether slug has the form of a tenant slug
+func ValidTenantSlug(slug string) bool {
+	return tenantSlugPattern.MatchString(slug)
+}
+
+// TenantBySlug returns an active tenant by slug, served from a short-lived
+// in-memory cache. Slugs come from request headers, so malformed ones are
+// refused without a lookup and misses aren't cached.
+func TenantBySlug(slug string) (*models.Tenant, error) {
+	if !ValidTenantSlug(slug) {
+		return nil, ErrTenantNotFound
+	}
+
+	tenantMu.Lock()
+	entry, ok := tenantBySlug[slug]
+	tenantMu.Unlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		var err error
+		if entry, err = loadTenant("slug = ?", slug); err != nil {
+			return nil, err
+		}
+		tenantMu.Lock()
+		if entry.tenant != nil {
+			tenantBySlug[slug] = entry
+		} else {
+			delete(tenantBySlug, slug)
+		}
+		tenantMu.Unlock()
+	}
+
+	if entry.tenant == nil {
+		return nil, ErrTenantNotFound
+	}
+	return entry.tenant, nil
+}
+
+// TenantByID returns an active tenant by ID, served from the same cache
+func TenantByID(id uint) (*models.Tenant, error) {
+	tenantMu.Lock()
+	entry, ok := tenantByID[id]
+	tenantMu.Unlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		var err error
+		if entry, err = loadTenant("id = ?", id); err != nil {
+			return nil, err
+		}
+		tenantMu.Lock()
+		tenantByID[id] = entry
+		tenantMu.Unlock()
+	}
+
+	if entry.tenant == nil {
+		return nil, ErrTenantNotFound
+	}
+	return entry.tenant, nil
+}
+
+// InvalidateTenants empties the tenant cache so the next request sees
+// changed settings immediately
+func InvalidateTenants() {
+	tenantMu.Lock()
+	tenantBySlug = map[string]tenantEntry{}
+	tenantByID = map[uint]tenantEntry{}
+	tenantMu.Unlock()
+}
+
+func loadTenant(query string, arg interface{}) (tenantEntry, error) {
+	entry := tenantEntry{expiresAt: time.Now().Add(tenantCacheTTL)}
+
+	var tenant models.Tenant
+	err := database.DB.Where(query, arg).First(&tenant).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return entry, fmt.Errorf("failed to load tenant: %w", err)
+	}
+	if err == nil && tenant.IsActive {
+		entry.tenant = &tenant
+	}
+	return entry, nil
+}
+
+// userDB returns a handle limited to the tenant of a loaded user, used for
+// changes to the user's own row
+func userDB(user *models.User) *gorm.DB {
+	return database.ForTenant(user.TenantID)
+}
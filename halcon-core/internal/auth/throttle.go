@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// throttleFreeAttempts failures are allowed before backoff starts
+	throttleFreeAttempts = 3
+	// throttleMaxDelay caps the exponential backoff
+	throttleMaxDelay = 5 * time.Minute
+	// throttleWindow is how long a key's failures are remembered
+	throttleWindow = 15 * time.Minute
+	// throttleSweepInterval is how often forgotten keys are dropped
+	throttleSweepInterval = time.Minute
+)
+
+// Throttle tracks failed attempts per key (an IP address or a username) and
+// enforces an exponential delay between attempts once a key has failed
+// more than throttleFreeAttempts times
+type Throttle struct {
+	mu        sync.Mutex
+	entries   map[string]*throttleEntry
+	lastSweep time.Time
+}
+
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+}
+
+// LoginThrottle limits password guessing on the login endpoint
+var LoginThrottle = NewThrottle()
+
+// NewThrottle creates an empty Throttle
+func NewThrottle() *Throttle {
+	return &Throttle{entries: map[string]*throttleEntry{}}
+}
+
+// Wait returns how long the caller must wait before the next attempt for
+// any of the keys is allowed, or zero when it may proceed
+func (t *Throttle) Wait(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	var wait time.Duration
+	for _, key := range keys {
+		entry, ok := t.entries[key]
+		if !ok {
+			continue
+		}
+		if remaining := entry.lastFailure.Add(backoff(entry.failures)).Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt for every key
+func (t *Throttle) Fail(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		entry, ok := t.entries[key]
+		if !ok || now.Sub(entry.lastFailure) > throttleWindow {
+			entry = &throttleEntry{}
+			t.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+	}
+}
+
+// Reset forgets the failures of every key
+func (t *Throttle) Reset(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		delete(t.entries, key)
+	}
+}
+
+// sweep drops keys whose last failure is outside the window
+func (t *Throttle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < throttleSweepInterval {
+		return
+	}
+	for key, entry := range t.entries {
+		if now.Sub(entry.lastFailure) > throttleWindow {
+			delete(t.entries, key)
+		}
+	}
+	t.lastSweep = now
+}
+
+// backoff returns the delay required after the given number of failures:
+// none for the free attempts, then 1s, 2s, 4s... up to throttleMaxDelay
+func backoff(failures int) time.Duration {
+	excess := failures - throttleFreeAttempts
+	if excess <= 0 {
+		return 0
+	}
+	delay := time.Duration(math.Pow(2, float64(excess-1))) * time.Second
+	if delay > throttleMaxDelay || delay <= 0 {
+		return throttleMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown or expired refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when a rotated refresh token is
+	// presented again. The whole token family is revoked when this happens.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is an access token together with its refresh token
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int // access token lifetime in seconds
+}
+
+// ClientInfo describes the client a refresh token was issued to. TenantID
+// is the tenant the request was addressed to, 0 outside HTTP requests.
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+	TenantID  uint
+}
+
+// IssueTokenPair creates an access token and a refresh token starting a new
+// token family, used at login
+func IssueTokenPair(user *models.User, client ClientInfo) (*TokenPair, error) {
+	familyID, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	var pair *TokenPair
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		pair, _, err = issue(tx, user, familyID, client)
+		return err
+	})
+	return pair, err
+}
+
+// Refresh rotates a refresh token: the presented token is revoked and a new
+// pair in the same family is returned. Presenting an already rotated token
+// revokes the whole family and returns ErrRefreshTokenReused.
+func Refresh(rawToken string, client ClientInfo) (*TokenPair, *models.User, error) {
+	var (
+		pair         *TokenPair
+		user         models.User
+		reusedFamily string
+		reusedUserID uint
+		// failure says why a token was refused, for the event log
+		failure      string
+		failedUserID *uint
+	)
+
+	// Refresh tokens aren't tenant-owned; the user's tenant is checked below
+	err := database.System().Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", HashToken(rawToken)).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			failure = "unknown token"
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load refresh token: %w", err)
+		}
+		failedUserID = &current.UserID
+
+		if current.RevokedAt != nil {
+			// A rotated token presented again means it was copied
+			if current.ReplacedByID != nil {
+				reusedFamily = current.FamilyID
+				reusedUserID = current.UserID
+				return ErrRefreshTokenReused
+			}
+			failure = "revoked token"
+			return ErrInvalidRefreshToken
+		}
+		if current.ExpiresAt.Before(time.Now()) {
+			failure = "expired token"
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Where("id = ? AND is_active = ?", current.UserID, true).First(&user).Error; err != nil {
+			failure = "inactive or deleted user"
+			return ErrInvalidRefreshToken
+		}
+		if client.TenantID != 0 && user.TenantID != client.TenantID {
+			// The user isn't the request tenant's to name
+			failure, failedUserID = "token of another tenant", nil
+			return ErrInvalidRefreshToken
+		}
+
+		var next *models.RefreshToken
+		pair, next, err = issue(tx, &user, current.FamilyID, client)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&current).Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"replaced_by_id": next.ID,
+		}).Error
+	})
+
+	// The rotation transaction rolled back, so revoke the family separately
+	if reusedFamily != "" {
+		if revokeErr := revokeFamily(database.DB, reusedFamily); revokeErr != nil {
+			return nil, nil, revokeErr
+		}
+		RecordEvent(models.AuthEvent{Type: models.EventRefreshTokenReuse, UserID: &reusedUserID, Detail: "token family revoked"}, client)
+	}
+	if failure != "" {
+		RecordEvent(models.AuthEvent{Type: models.EventTokenRefreshFailed, UserID: failedUserID, Detail: failure}, client)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	RecordEvent(models.AuthEvent{Type: models.EventTokenRefreshed, TenantID: user.TenantID, UserID: &user.ID, Username: user.Username}, client)
+	return pair, &user, nil
+}
+
+// Logout revokes the access token identified by jti and, when given, the
+// family of the refresh token
+func Logout(userID uint, jti string, accessExpiresAt time.Time, rawRefreshToken string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := revokeAccessToken(tx, userID, jti, accessExpiresAt); err != nil {
+			return err
+		}
+
+		if rawRefreshToken == "" {
+			return nil
+		}
+
+		var token models.RefreshToken
+		err := tx.Where("token_hash = ? AND user_id = ?", HashToken(rawRefreshToken), userID).First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load refresh token: %w", err)
+		}
+		return revokeFamily(tx, token.FamilyID)
+	})
+}
+
+// RevokeUserSessions revokes every refresh token of a user, e.g. after a
+// password change or deactivation. Outstanding access tokens expire on
+// their own shortly after.
+func RevokeUserSessions(userID uint) error {
+	err := database.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// RevokeAccessToken adds a token to the revocation list until it expires
+func RevokeAccessToken(userID uint, jti string, expiresAt time.Time) error {
+	return revokeAccessToken(database.DB, userID, jti, expiresAt)
+}
+
+// IsRevoked reports whether the access token with the given jti was revoked
+func IsRevoked(jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return count > 0, nil
+}
+
+// PurgeExpiredTokens deletes refresh tokens, revocation entries, password
+// reset tokens, single sign-on login states and idempotency records that
+// expired before the cutoff. It returns the number of rows removed.
+func PurgeExpiredTokens(cutoff time.Time) (int64, error) {
+	var total int64
+	err := database.System().Transaction(func(tx *gorm.DB) error {
+		// Break rotation links first so expired rows can be deleted in any order
+		if err := tx.Model(&models.RefreshToken{}).
+			Where("replaced_by_id IN (?)", tx.Model(&models.RefreshToken{}).Select("id").Where("expires_at < ?", cutoff)).
+			Update("replaced_by_id", nil).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("expires_at < ?", cutoff).Delete(&models.RefreshToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		total += result.RowsAffected
+
+		result = tx.Where("expires_at < ?", cutoff).Delete(&models.RevokedToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		total += result.RowsAffected
+
+		for _, model := range []interface{}{&models.PasswordResetToken{}, &models.OIDCLoginState{}, &models.SSOHandoff{}, &models.IdempotencyRecord{}} {
+			result = tx.Where("expires_at < ?", cutoff).Delete(model)
+			if result.Error != nil {
+				return result.Error
+			}
+			total += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired tokens: %w", err)
+	}
+	return total, nil
+}
+
+// HashToken returns the hex SHA-256 of a token, the form stored in the DB
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issue creates an access token and a refresh token in the given family
+func issue(tx *gorm.DB, user *models.User, familyID string, client ClientInfo) (*TokenPair, *models.RefreshToken, error) {
+	accessToken, err := utils.GenerateToken(user)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	rawRefresh, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: HashToken(rawRefresh),
+		ExpiresAt: time.Now().Add(time.Duration(config.AppConfig.RefreshTokenTTLHours) * time.Hour),
+		IPAddress: client.IPAddress,
+		UserAgent: truncate(client.UserAgent, 500),
+	}
+	if err := tx.Create(&refresh).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: rawRefresh,
+		ExpiresIn:    config.AppConfig.AccessTokenTTLMinutes * 60,
+	}, &refresh, nil
+}
+
+// revokeFamily revokes every live refresh token in a family
+func revokeFamily(tx *gorm.DB, familyID string) error {
+	err := tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+	return nil
+}
+
+// revokeAccessToken adds an access token to the revocation list
+func revokeAccessToken(tx *gorm.DB, userID uint, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	entry := models.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt, RevokedAt: time.Now()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
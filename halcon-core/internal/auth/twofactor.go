@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount is how many recovery codes are issued at once
+const recoveryCodeCount = 10
+
+var (
+	// ErrTwoFactorEnabled is returned when enrolling a user who already has 2FA
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotPending is returned when enabling without starting enrollment
+	ErrTwoFactorNotPending = errors.New("two-factor enrollment has not been started")
+	// ErrTwoFactorNotEnabled is returned for operations that need 2FA enabled
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrTwoFactorRequired is returned when disabling 2FA the role requires
+	ErrTwoFactorRequired = errors.New("two-factor authentication is required for this role")
+	// ErrInvalidTwoFactorCode is returned for wrong, reused or expired codes
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorRequired reports whether a user with any of the roles must use
+// 2FA, as configured by TWO_FACTOR_REQUIRED_ROLES
+func TwoFactorRequired(roles ...models.UserRole) bool {
+	for _, r := range strings.Split(config.AppConfig.TwoFactorRequiredRoles, ",") {
+		if containsRole(roles, models.UserRole(strings.TrimSpace(r))) {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginTwoFactorEnrollment stores a new pending TOTP secret for the user and
+// returns it with its provisioning URI. 2FA stays off until EnableTwoFactor
+// confirms a code generated from the secret.
+func BeginTwoFactorEnrollment(user *models.User) (string, string, error) {
+	if user.TOTPEnabled {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := userDB(user).Model(user).UpdateColumns(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return "", "", fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+
+	return secret, ProvisioningURI(config.AppConfig.TwoFactorIssuer, user.Username, secret), nil
+}
+
+// EnableTwoFactor turns on 2FA once the user proves their authenticator
+// works, and returns a fresh set of recovery codes to show once
+func EnableTwoFactor(user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotPending
+	}
+
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err := userDB(user).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	InvalidateUser(user.ID)
+	return codes, nil
+}
+
+// DisableTwoFactor removes the TOTP secret and recovery codes. Users whose
+// role requires 2FA must enroll again on their next request.
+func DisableTwoFactor(user *models.User) error {
+	err := userDB(user).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	InvalidateUser(user.ID)
+	return nil
+}
+
+// VerifySecondFactor checks a TOTP code, or a recovery code when code is
+// empty. Each TOTP code and recovery code is accepted only once. It reports
+// whether a recovery code was used.
+func VerifySecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if !user.TOTPEnabled {
+		return false, ErrTwoFactorNotEnabled
+	}
+
+	if code == "" {
+		return true, useRecoveryCode(user.ID, recoveryCode)
+	}
+
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return false, ErrInvalidTwoFactorCode
+	}
+
+	// Only one request may claim a step, so a code can't be used twice
+	result := userDB(user).Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		UpdateColumn("totp_last_step", step)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to record TOTP code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, ErrInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+	return false, nil
+}
+
+// RegenerateRecoveryCodes invalidates the user's recovery codes and returns
+// a new set
+func RegenerateRecoveryCodes(user *models.User) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// RemainingRecoveryCodes counts the user's unused recovery codes
+func RemainingRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// useRecoveryCode marks an unused recovery code as used
+func useRecoveryCode(userID uint, code string) error {
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidTwoFactorCode
+	}
+
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashToken(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to use recovery code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores the
+// hashes of a new set, returning the plain codes formatted as xxxxx-xxxxx
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: HashToken(raw)}
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts codes with any case, dashes or spaces
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+// SaveUser stores changes to the fields of a user and, unless roles is
+// nil, replaces their roles in the same transaction. Deactivating or
+// demoting the last active user who can manage roles fails with
+// ErrLastRoleManager and changes nothing.
+func SaveUser(user *models.User, roles *RoleChange) error {
+	err := userDB(user).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Roles").Save(user).Error; err != nil {
+			return err
+		}
+		if roles != nil {
+			if err := replaceUserRoles(tx, user, *roles); err != nil {
+				return err
+			}
+		}
+		if !user.IsActive || roles != nil {
+			return ensureRoleManager(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	InvalidateUser(user.ID)
+	return nil
+}
+
+// DeleteUser soft-deletes a user and ends their sessions. With reassignTo,
+// the user's undelivered orders first move to that user, who takes over
+// their ownership; the count of moved orders is returned. Deleting the last
+// active user who can manage roles fails with ErrLastRoleManager.
+func DeleteUser(user *models.User, reassignTo *uint) (int64, error) {
+	var reassigned int64
+	err := userDB(user).Transaction(func(tx *gorm.DB) error {
+		if reassignTo != nil {
+			result := tx.Model(&models.Order{}).
+				Where("created_by = ? AND status <> ?", user.ID, models.StatusDelivered).
+				Update("created_by", *reassignTo)
+			if result.Error != nil {
+				return fmt.Errorf("failed to reassign orders: %w", result.Error)
+			}
+			reassigned = result.RowsAffected
+		}
+		if err := tx.Delete(user).Error; err != nil {
+			return err
+		}
+		return ensureRoleManager(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	InvalidateUser(user.ID)
+	return reassigned, RevokeUserSessions(user.ID)
+}
+
+// RestoreUser undoes the deletion of a user, who keeps their roles and
+// active flag. Sessions ended by the deletion stay ended.
+func RestoreUser(user *models.User) error {
+	if err := userDB(user).Unscoped().Model(user).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	InvalidateUser(user.ID)
+	return nil
+}
@@ -1,73 +1,343 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// Config holds the application settings. Values are resolved in order from
+// the `default` tag, an optional YAML or TOML file, then the environment
+// variable named by the `env` tag. Fields tagged `secret:"true"` can also be
+// read from the file named by <ENV>_FILE and are redacted when printed.
 type Config struct {
 	// Server
-	Port string
-	Env  string
+	Port string `yaml:"port" toml:"port" json:"port" env:"PORT" default:"8080"`
+	Env  string `yaml:"env" toml:"env" json:"env" env:"ENV" default:"development"`
 
 	// Database
-	DBHost     string
-	DBPort     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	DBSSLMode  string
+	DBHost     string `yaml:"db_host" toml:"db_host" json:"db_host" env:"DB_HOST" default:"localhost"`
+	DBPort     string `yaml:"db_port" toml:"db_port" json:"db_port" env:"DB_PORT" default:"5432"`
+	DBUser     string `yaml:"db_user" toml:"db_user" json:"db_user" env:"DB_USER" default:"halcon_user"`
+	DBPassword string `yaml:"db_password" toml:"db_password" json:"db_password" env:"DB_PASSWORD" default:"halcon_password" secret:"true"`
+	DBName     string `yaml:"db_name" toml:"db_name" json:"db_name" env:"DB_NAME" default:"halcon_db"`
+	DBSSLMode  string `yaml:"db_sslmode" toml:"db_sslmode" json:"db_sslmode" env:"DB_SSLMODE" default:"disable"`
+
+	// AutoMigrate applies pending migrations at startup (development only)
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate" json:"auto_migrate" env:"AUTO_MIGRATE" default:"false"`
 
 	// JWT
-	JWTSecret          string
-	JWTExpirationHours int
+	JWTKeysDir            string `yaml:"jwt_keys_dir" toml:"jwt_keys_dir" json:"jwt_keys_dir" env:"JWT_KEYS_DIR" default:"./keys"`
+	JWTAlgorithm          string `yaml:"jwt_algorithm" toml:"jwt_algorithm" json:"jwt_algorithm" env:"JWT_ALGORITHM" default:"EdDSA"`
+	AccessTokenTTLMinutes int    `yaml:"access_token_ttl_minutes" toml:"access_token_ttl_minutes" json:"access_token_ttl_minutes" env:"ACCESS_TOKEN_TTL_MINUTES" default:"15"`
+	RefreshTokenTTLHours  int    `yaml:"refresh_token_ttl_hours" toml:"refresh_token_ttl_hours" json:"refresh_token_ttl_hours" env:"REFRESH_TOKEN_TTL_HOURS" default:"720"`
+
+	// ImpersonationTTLMinutes is the lifetime of the tokens admins get to act
+	// as another user; they can't be refreshed
+	ImpersonationTTLMinutes int `yaml:"impersonation_ttl_minutes" toml:"impersonation_ttl_minutes" json:"impersonation_ttl_minutes" env:"IMPERSONATION_TTL_MINUTES" default:"15"`
+
+	// UserStatusCacheSeconds bounds how long a role change or deactivation
+	// can take to reach other server processes
+	UserStatusCacheSeconds int `yaml:"user_status_cache_seconds" toml:"user_status_cache_seconds" json:"user_status_cache_seconds" env:"USER_STATUS_CACHE_SECONDS" default:"30"`
+
+	// Login protection
+	LoginMaxFailures    int `yaml:"login_max_failures" toml:"login_max_failures" json:"login_max_failures" env:"LOGIN_MAX_FAILURES" default:"5"`
+	LoginLockoutMinutes int `yaml:"login_lockout_minutes" toml:"login_lockout_minutes" json:"login_lockout_minutes" env:"LOGIN_LOCKOUT_MINUTES" default:"15"`
+
+	// Auth event log: the server purges events older than
+	// AUTH_EVENT_RETENTION_DAYS daily (0 keeps them). The anomaly report flags
+	// client IPs with AUTH_ANOMALY_FAILURE_THRESHOLD failures and logins
+	// outside AUTH_USUAL_LOGIN_HOURS ("start-end" on a 24-hour clock) in
+	// AUTH_USUAL_LOGIN_TIMEZONE.
+	AuthEventRetentionDays      int    `yaml:"auth_event_retention_days" toml:"auth_event_retention_days" json:"auth_event_retention_days" env:"AUTH_EVENT_RETENTION_DAYS" default:"90"`
+	AuthAnomalyFailureThreshold int    `yaml:"auth_anomaly_failure_threshold" toml:"auth_anomaly_failure_threshold" json:"auth_anomaly_failure_threshold" env:"AUTH_ANOMALY_FAILURE_THRESHOLD" default:"10"`
+	AuthUsualLoginHours         string `yaml:"auth_usual_login_hours" toml:"auth_usual_login_hours" json:"auth_usual_login_hours" env:"AUTH_USUAL_LOGIN_HOURS" default:"06-22"`
+	AuthUsualLoginTimezone      string `yaml:"auth_usual_login_timezone" toml:"auth_usual_login_timezone" json:"auth_usual_login_timezone" env:"AUTH_USUAL_LOGIN_TIMEZONE" default:"UTC"`
+
+	// Two-factor authentication: comma-separated roles that must enroll
+	TwoFactorRequiredRoles string `yaml:"two_factor_required_roles" toml:"two_factor_required_roles" json:"two_factor_required_roles" env:"TWO_FACTOR_REQUIRED_ROLES"`
+	TwoFactorIssuer        string `yaml:"two_factor_issuer" toml:"two_factor_issuer" json:"two_factor_issuer" env:"TWO_FACTOR_ISSUER" default:"Halcon"`
+
+	// OpenID Connect single sign-on, enabled when OIDC_ISSUER_URL is set.
+	// OIDC_ROLE_MAPPING maps IdP groups to roles as "group=Role,..."; the
+	// first matching entry wins, then OIDC_DEFAULT_ROLE, and users without a
+	// role are refused.
+	OIDCIssuerURL            string `yaml:"oidc_issuer_url" toml:"oidc_issuer_url" json:"oidc_issuer_url" env:"OIDC_ISSUER_URL"`
+	OIDCClientID             string `yaml:"oidc_client_id" toml:"oidc_client_id" json:"oidc_client_id" env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret         string `yaml:"oidc_client_secret" toml:"oidc_client_secret" json:"oidc_client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	OIDCRedirectURL          string `yaml:"oidc_redirect_url" toml:"oidc_redirect_url" json:"oidc_redirect_url" env:"OIDC_REDIRECT_URL" default:"http://localhost:8080/api/auth/oidc/callback"`
+	OIDCScopes               string `yaml:"oidc_scopes" toml:"oidc_scopes" json:"oidc_scopes" env:"OIDC_SCOPES" default:"openid profile email"`
+	OIDCGroupsClaim          string `yaml:"oidc_groups_claim" toml:"oidc_groups_claim" json:"oidc_groups_claim" env:"OIDC_GROUPS_CLAIM" default:"groups"`
+	OIDCDepartmentClaim      string `yaml:"oidc_department_claim" toml:"oidc_department_claim" json:"oidc_department_claim" env:"OIDC_DEPARTMENT_CLAIM" default:"department"`
+	OIDCRoleMapping          string `yaml:"oidc_role_mapping" toml:"oidc_role_mapping" json:"oidc_role_mapping" env:"OIDC_ROLE_MAPPING"`
+	OIDCDefaultRole          string `yaml:"oidc_default_role" toml:"oidc_default_role" json:"oidc_default_role" env:"OIDC_DEFAULT_ROLE"`
+	OIDCAutoProvision        bool   `yaml:"oidc_auto_provision" toml:"oidc_auto_provision" json:"oidc_auto_provision" env:"OIDC_AUTO_PROVISION" default:"true"`
+	OIDCDisablePasswordLogin bool   `yaml:"oidc_disable_password_login" toml:"oidc_disable_password_login" json:"oidc_disable_password_login" env:"OIDC_DISABLE_PASSWORD_LOGIN" default:"false"`
+
+	// Password policy: PASSWORD_BREACHED_LIST_FILE names a file of known
+	// breached passwords, one per line, either plain or as SHA-1 hashes
+	PasswordMinLength        int    `yaml:"password_min_length" toml:"password_min_length" json:"password_min_length" env:"PASSWORD_MIN_LENGTH" default:"10"`
+	PasswordBreachedListFile string `yaml:"password_breached_list_file" toml:"password_breached_list_file" json:"password_breached_list_file" env:"PASSWORD_BREACHED_LIST_FILE"`
+	PasswordResetTTLMinutes  int    `yaml:"password_reset_ttl_minutes" toml:"password_reset_ttl_minutes" json:"password_reset_ttl_minutes" env:"PASSWORD_RESET_TTL_MINUTES" default:"60"`
+
+	// InvitationTTLHours is how long an invitation link can be used
+	InvitationTTLHours int `yaml:"invitation_ttl_hours" toml:"invitation_ttl_hours" json:"invitation_ttl_hours" env:"INVITATION_TTL_HOURS" default:"72"`
+
+	// PublicURL is the base URL of the web client, used for links in emails
+	PublicURL string `yaml:"public_url" toml:"public_url" json:"public_url" env:"PUBLIC_URL" default:"http://localhost:5173"`
+
+	// Mail: MAIL_DRIVER is smtp, file (one .eml per message in MAIL_DIR) or
+	// log (message written to the server log, development only)
+	MailDriver   string `yaml:"mail_driver" toml:"mail_driver" json:"mail_driver" env:"MAIL_DRIVER" default:"log"`
+	MailFrom     string `yaml:"mail_from" toml:"mail_from" json:"mail_from" env:"MAIL_FROM" default:"Halcon <no-reply@localhost>"`
+	MailDir      string `yaml:"mail_dir" toml:"mail_dir" json:"mail_dir" env:"MAIL_DIR" default:"./mail"`
+	SMTPHost     string `yaml:"smtp_host" toml:"smtp_host" json:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" toml:"smtp_port" json:"smtp_port" env:"SMTP_PORT" default:"587"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username" json:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password" json:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+
+	// Multi-tenancy: with MULTI_TENANT set, a request's tenant is taken from
+	// the TENANT_HEADER header or the tenant query parameter, else from its
+	// subdomain of TENANT_BASE_DOMAIN, else it is the default tenant. Without
+	// it every request belongs to the default tenant.
+	MultiTenant      bool   `yaml:"multi_tenant" toml:"multi_tenant" json:"multi_tenant" env:"MULTI_TENANT" default:"false"`
+	TenantHeader     string `yaml:"tenant_header" toml:"tenant_header" json:"tenant_header" env:"TENANT_HEADER" default:"X-Tenant"`
+	TenantBaseDomain string `yaml:"tenant_base_domain" toml:"tenant_base_domain" json:"tenant_base_domain" env:"TENANT_BASE_DOMAIN"`
 
 	// CORS
-	CORSAllowedOrigins string
+	CORSAllowedOrigins string `yaml:"cors_allowed_origins" toml:"cors_allowed_origins" json:"cors_allowed_origins" env:"CORS_ALLOWED_ORIGINS" default:"http://localhost:5173"`
 
 	// Upload
-	UploadDir     string
-	MaxUploadSize int64
+	UploadDir     string `yaml:"upload_dir" toml:"upload_dir" json:"upload_dir" env:"UPLOAD_DIR" default:"./uploads"`
+	MaxUploadSize int64  `yaml:"max_upload_size" toml:"max_upload_size" json:"max_upload_size" env:"MAX_UPLOAD_SIZE" default:"10485760"`
+
+	// Idempotency
+	IdempotencyTTLHours int `yaml:"idempotency_ttl_hours" toml:"idempotency_ttl_hours" json:"idempotency_ttl_hours" env:"IDEMPOTENCY_TTL_HOURS" default:"24"`
+
+	// First-run bootstrap
+	AdminUsername string `yaml:"admin_username" toml:"admin_username" json:"admin_username" env:"ADMIN_USERNAME" default:"admin"`
+	AdminEmail    string `yaml:"admin_email" toml:"admin_email" json:"admin_email" env:"ADMIN_EMAIL"`
+	AdminPassword string `yaml:"admin_password" toml:"admin_password" json:"admin_password" env:"ADMIN_PASSWORD" secret:"true"`
+	SetupToken    string `yaml:"setup_token" toml:"setup_token" json:"setup_token" env:"SETUP_TOKEN" secret:"true"`
 }
 
 var AppConfig *Config
 
-func LoadConfig() {
+// ConfigFileEnv names the environment variable holding the config file path
+const ConfigFileEnv = "CONFIG_FILE"
+
+// LoadConfig loads and validates the configuration into AppConfig. The
+// config file is taken from CONFIG_FILE when set.
+func LoadConfig() error {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	jwtExpHours, _ := strconv.Atoi(getEnv("JWT_EXPIRATION_HOURS", "24"))
-	maxUploadSize, _ := strconv.ParseInt(getEnv("MAX_UPLOAD_SIZE", "10485760"), 10, 64)
+	cfg, err := Load(os.Getenv(ConfigFileEnv))
+	if err != nil {
+		return err
+	}
+
+	AppConfig = cfg
+	return nil
+}
+
+// Load resolves the configuration from defaults, the optional file at path
+// and the environment, then validates it
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	var problems []string
+
+	if err := forEachField(cfg, func(f field) error {
+		if def := f.tag.Get("default"); def != "" {
+			return setField(f.value, def)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("invalid config defaults: %w", err)
+	}
+
+	if path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	forEachField(cfg, func(f field) error {
+		key := f.tag.Get("env")
+		if key == "" {
+			return nil
+		}
+
+		value, ok, err := lookupEnv(key, f.tag.Get("secret") == "true")
+		if err != nil {
+			problems = append(problems, err.Error())
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+
+		if err := setField(f.value, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+		return nil
+	})
+
+	// Report parse and validation problems together
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err.(*ValidationError).Problems...)
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// OIDCEnabled reports whether single sign-on is configured
+func (c *Config) OIDCEnabled() bool {
+	return c.OIDCIssuerURL != ""
+}
+
+// UsualLoginHours parses AUTH_USUAL_LOGIN_HOURS into the hour usual logins
+// start at and the hour they end before. A start after the end spans
+// midnight.
+func (c *Config) UsualLoginHours() (start, end int, err error) {
+	from, to, ok := strings.Cut(c.AuthUsualLoginHours, "-")
+	if ok {
+		start, err = strconv.Atoi(strings.TrimSpace(from))
+		if err == nil {
+			end, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+	}
+	if !ok || err != nil || start < 0 || start > 23 || end < 0 || end > 24 || start == end {
+		return 0, 0, fmt.Errorf("must be two different hours as start-end, e.g. 06-22, got %q", c.AuthUsualLoginHours)
+	}
+	return start, end, nil
+}
+
+// IsProduction reports whether the server runs in production mode
+func (c *Config) IsProduction() bool {
+	return c.Env == "production"
+}
+
+// Redacted returns a copy of the configuration with every secret masked
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	forEachField(&redacted, func(f field) error {
+		if f.tag.Get("secret") == "true" && f.value.String() != "" {
+			f.value.SetString("********")
+		}
+		return nil
+	})
+	return &redacted
+}
+
+// RedactedYAML renders the configuration as YAML with secrets masked
+func (c *Config) RedactedYAML() (string, error) {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return "", fmt.Errorf("failed to render config: %w", err)
+	}
+	return string(out), nil
+}
+
+// loadFile decodes a YAML or TOML file, chosen by extension, into cfg
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
 
-	AppConfig = &Config{
-		Port: getEnv("PORT", "8080"),
-		Env:  getEnv("ENV", "development"),
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(content)))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(content), cfg)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("invalid config file %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("unsupported config file %s: use .yaml, .yml or .toml", path)
+	}
 
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBUser:     getEnv("DB_USER", "halcon_user"),
-		DBPassword: getEnv("DB_PASSWORD", "halcon_password"),
-		DBName:     getEnv("DB_NAME", "halcon_db"),
-		DBSSLMode:  getEnv("DB_SSLMODE", "disable"),
+	return nil
+}
+
+// lookupEnv reads KEY from the environment. Secrets may instead be read
+// from the file named by KEY_FILE, which takes precedence.
+func lookupEnv(key string, secret bool) (string, bool, error) {
+	if secret {
+		if path := os.Getenv(key + "_FILE"); path != "" {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return "", false, fmt.Errorf("%s_FILE: %v", key, err)
+			}
+			return strings.TrimSpace(string(content)), true, nil
+		}
+	}
 
-		JWTSecret:          getEnv("JWT_SECRET", "your-secret-key"),
-		JWTExpirationHours: jwtExpHours,
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return "", false, nil
+	}
+	return value, true, nil
+}
 
-		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:5173"),
+// field is a settable Config field with its struct tag
+type field struct {
+	name  string
+	tag   reflect.StructTag
+	value reflect.Value
+}
 
-		UploadDir:     getEnv("UPLOAD_DIR", "./uploads"),
-		MaxUploadSize: maxUploadSize,
+// forEachField calls fn for every field of cfg, stopping at the first error
+func forEachField(cfg *Config, fn func(field) error) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if err := fn(field{name: t.Field(i).Name, tag: t.Field(i).Tag, value: v.Field(i)}); err != nil {
+			return fmt.Errorf("%s: %w", t.Field(i).Name, err)
+		}
 	}
+	return nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// setField parses raw into the field according to its kind
+func setField(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Kind())
 	}
-	return defaultValue
+	return nil
 }
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// validEnvs lists the accepted values of ENV
+var validEnvs = []string{"development", "staging", "production"}
+
+// validSSLModes lists the accepted values of DB_SSLMODE
+var validSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// validJWTAlgorithms lists the accepted values of JWT_ALGORITHM
+var validJWTAlgorithms = []string{"EdDSA", "RS256"}
+
+// validMailDrivers lists the accepted values of MAIL_DRIVER
+var validMailDrivers = []string{"smtp", "file", "log"}
+
+// knownWeakSecrets are placeholder values shipped in examples and compose
+// files, refused in production for every secret setting
+var knownWeakSecrets = []string{
+	defaultDBPassword,
+	"secret",
+	"changeme",
+	"password",
+	"admin",
+	"admin123",
+}
+
+// defaultDBPassword is the development database password
+const defaultDBPassword = "halcon_password"
+
+// ValidationError lists every problem found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// all problems. Production additionally refuses weak or default secrets.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		add("PORT: must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if !contains(validEnvs, c.Env) {
+		add("ENV: must be one of %s, got %q", strings.Join(validEnvs, ", "), c.Env)
+	}
+
+	if c.DBHost == "" {
+		add("DB_HOST: is required")
+	}
+	if c.DBName == "" {
+		add("DB_NAME: is required")
+	}
+	if c.DBUser == "" {
+		add("DB_USER: is required")
+	}
+	if !contains(validSSLModes, c.DBSSLMode) {
+		add("DB_SSLMODE: must be one of %s, got %q", strings.Join(validSSLModes, ", "), c.DBSSLMode)
+	}
+
+	if c.JWTKeysDir == "" {
+		add("JWT_KEYS_DIR: is required")
+	}
+	if !contains(validJWTAlgorithms, c.JWTAlgorithm) {
+		add("JWT_ALGORITHM: must be one of %s, got %q", strings.Join(validJWTAlgorithms, ", "), c.JWTAlgorithm)
+	}
+	if c.AccessTokenTTLMinutes <= 0 {
+		add("ACCESS_TOKEN_TTL_MINUTES: must be greater than 0")
+	}
+	if c.RefreshTokenTTLHours <= 0 {
+		add("REFRESH_TOKEN_TTL_HOURS: must be greater than 0")
+	}
+	if c.ImpersonationTTLMinutes < 1 || c.ImpersonationTTLMinutes > 60 {
+		add("IMPERSONATION_TTL_MINUTES: must be between 1 and 60")
+	}
+	if c.UserStatusCacheSeconds < 0 {
+		add("USER_STATUS_CACHE_SECONDS: must not be negative")
+	}
+	if c.LoginMaxFailures <= 0 {
+		add("LOGIN_MAX_FAILURES: must be greater than 0")
+	}
+	if c.LoginLockoutMinutes <= 0 {
+		add("LOGIN_LOCKOUT_MINUTES: must be greater than 0")
+	}
+	if c.AuthEventRetentionDays < 0 {
+		add("AUTH_EVENT_RETENTION_DAYS: must not be negative")
+	}
+	if c.AuthAnomalyFailureThreshold < 1 {
+		add("AUTH_ANOMALY_FAILURE_THRESHOLD: must be greater than 0")
+	}
+	if _, _, err := c.UsualLoginHours(); err != nil {
+		add("AUTH_USUAL_LOGIN_HOURS: %v", err)
+	}
+	if _, err := time.LoadLocation(c.AuthUsualLoginTimezone); err != nil {
+		add("AUTH_USUAL_LOGIN_TIMEZONE: unknown time zone %q", c.AuthUsualLoginTimezone)
+	}
+	for _, role := range strings.Split(c.TwoFactorRequiredRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" && !models.UserRole(role).IsValid() {
+			add("TWO_FACTOR_REQUIRED_ROLES: invalid role name %q", role)
+		}
+	}
+	if c.TwoFactorIssuer == "" {
+		add("TWO_FACTOR_ISSUER: is required")
+	}
+	if c.OIDCEnabled() {
+		c.validateOIDC(add)
+	}
+	if c.PasswordMinLength < 8 || c.PasswordMinLength > 72 {
+		add("PASSWORD_MIN_LENGTH: must be between 8 and 72")
+	}
+	if c.PasswordResetTTLMinutes <= 0 {
+		add("PASSWORD_RESET_TTL_MINUTES: must be greater than 0")
+	}
+	if c.InvitationTTLHours < 1 || c.InvitationTTLHours > 720 {
+		add("INVITATION_TTL_HOURS: must be between 1 and 720")
+	}
+	if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("PUBLIC_URL: must be an absolute http or https URL, got %q", c.PublicURL)
+	}
+	if !contains(validMailDrivers, c.MailDriver) {
+		add("MAIL_DRIVER: must be one of %s, got %q", strings.Join(validMailDrivers, ", "), c.MailDriver)
+	}
+	if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+		add("MAIL_FROM: must be a valid address, got %q", c.MailFrom)
+	}
+	switch c.MailDriver {
+	case "smtp":
+		if c.SMTPHost == "" {
+			add("SMTP_HOST: is required when MAIL_DRIVER is smtp")
+		}
+		if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			add("SMTP_PORT: must be a number between 1 and 65535")
+		}
+	case "file":
+		if c.MailDir == "" {
+			add("MAIL_DIR: is required when MAIL_DRIVER is file")
+		}
+	}
+	if c.MultiTenant {
+		if c.TenantHeader == "" && c.TenantBaseDomain == "" {
+			add("TENANT_HEADER: is required when MULTI_TENANT is set and TENANT_BASE_DOMAIN is empty")
+		}
+		if strings.Contains(c.TenantBaseDomain, "://") || strings.HasPrefix(c.TenantBaseDomain, ".") {
+			add("TENANT_BASE_DOMAIN: must be a bare domain such as halcon.example.com, got %q", c.TenantBaseDomain)
+		}
+	}
+	if c.MaxUploadSize <= 0 {
+		add("MAX_UPLOAD_SIZE: must be greater than 0")
+	}
+	if c.UploadDir == "" {
+		add("UPLOAD_DIR: is required")
+	}
+	if c.IdempotencyTTLHours <= 0 {
+		add("IDEMPOTENCY_TTL_HOURS: must be greater than 0")
+	}
+
+	if c.IsProduction() {
+		if c.DBPassword == "" {
+			add("DB_PASSWORD: is required in production")
+		}
+		forEachField(c, func(f field) error {
+			if f.tag.Get("secret") == "true" && containsFold(knownWeakSecrets, f.value.String()) {
+				add("%s: a default or placeholder value is not allowed in production", f.tag.Get("env"))
+			}
+			return nil
+		})
+		if c.MailDriver == "log" {
+			add("MAIL_DRIVER: log writes password reset links to the server log and is not allowed in production")
+		}
+		if c.AutoMigrate {
+			add("AUTO_MIGRATE: is not allowed in production, run \"migrate up\" instead")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateOIDC checks the single sign-on settings
+func (c *Config) validateOIDC(add func(format string, args ...interface{})) {
+	if u, err := url.Parse(c.OIDCIssuerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("OIDC_ISSUER_URL: must be an absolute http or https URL, got %q", c.OIDCIssuerURL)
+	} else if c.IsProduction() && u.Scheme != "https" {
+		add("OIDC_ISSUER_URL: must use https in production")
+	}
+	if c.OIDCClientID == "" {
+		add("OIDC_CLIENT_ID: is required when OIDC_ISSUER_URL is set")
+	}
+	if u, err := url.Parse(c.OIDCRedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("OIDC_REDIRECT_URL: must be an absolute http or https URL, got %q", c.OIDCRedirectURL)
+	}
+	if !contains(strings.Fields(c.OIDCScopes), "openid") {
+		add("OIDC_SCOPES: must include openid")
+	}
+	for _, entry := range strings.Split(c.OIDCRoleMapping, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(group) == "" {
+			add("OIDC_ROLE_MAPPING: entries must look like group=Role, got %q", entry)
+		} else if !models.UserRole(strings.TrimSpace(role)).IsValid() {
+			add("OIDC_ROLE_MAPPING: invalid role name %q", strings.TrimSpace(role))
+		}
+	}
+	if c.OIDCDefaultRole != "" && !models.UserRole(c.OIDCDefaultRole).IsValid() {
+		add("OIDC_DEFAULT_ROLE: invalid role name %q", c.OIDCDefaultRole)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,19 +1,24 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"github.com/nietzshn/halcon-core/internal/config"
-	"github.com/nietzshn/halcon-core/internal/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/nietzshn/halcon-core/internal/tenant"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// DB is the connection pool. Statements on tenant-owned tables fail unless
+// they run through ForTenant, System or WithContext with a tenant context.
 var DB *gorm.DB
 
+// LogLevel controls GORM SQL logging. Set it before calling Connect.
+var LogLevel = logger.Info
+
 // Connect establishes a connection to the PostgreSQL database
 func Connect() error {
 	cfg := config.AppConfig
@@ -25,63 +30,28 @@ func Connect() error {
 
 	var err error
 	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: logger.Default.LogMode(LogLevel),
 	})
 
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	if err := tenant.Register(DB); err != nil {
+		return fmt.Errorf("failed to register tenant callbacks: %w", err)
+	}
 
 	log.Println("Database connection established")
 	return nil
 }
 
-// Migrate runs database migrations
-func Migrate() error {
-	err := DB.AutoMigrate(
-		&models.User{},
-		&models.Order{},
-	)
-
-	if err != nil {
-		return fmt.Errorf("failed to migrate database: %w", err)
-	}
-
-	log.Println("Database migration completed")
-	return nil
+// ForTenant returns a handle whose statements only see the tenant's rows
+func ForTenant(id uint) *gorm.DB {
+	return DB.WithContext(tenant.NewContext(context.Background(), id))
 }
 
-// Seed creates initial data (default admin user)
-func Seed() error {
-	// Check if admin user already exists
-	var count int64
-	DB.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count)
-
-	if count > 0 {
-		log.Println("Admin user already exists, skipping seed")
-		return nil
-	}
-
-	// Create default admin user
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("admin123"), bcrypt.DefaultCost)
-	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
-	}
-
-	admin := models.User{
-		Username:     "admin",
-		PasswordHash: string(hashedPassword),
-		Role:         models.RoleAdmin,
-		Department:   "Administration",
-		FullName:     "System Administrator",
-		Email:        "admin@halcon.com",
-		IsActive:     true,
-	}
-
-	if err := DB.Create(&admin).Error; err != nil {
-		return fmt.Errorf("failed to create admin user: %w", err)
-	}
-
-	log.Println("Default admin user created (username: admin, password: admin123)")
-	return nil
+// System returns a handle whose statements see the rows of every tenant.
+// Use it for lookups by a globally unique key, such as a user ID taken from
+// a verified token, and for maintenance across tenants.
+func System() *gorm.DB {
+	return DB.WithContext(tenant.WithoutTenant(context.Background()))
 }
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock key that serialises
+// concurrent migration runs
+const migrationLockID = 727_001
+
+// migrationFilename matches files such as 0001_initial_schema.up.sql
+var migrationFilename = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change with its up and down SQL
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// SchemaMigration is a row of the schema_migrations table
+type SchemaMigration struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"type:varchar(255);not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName specifies the table name for SchemaMigration
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// LoadMigrations reads the embedded migration files sorted by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		m := migrationFilename.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration filename %q", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration version %d is used by %q and %q", version, migration.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// LatestVersion returns the highest version known to this binary
+func LatestVersion() (int64, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// CurrentVersion returns the highest applied migration version
+func CurrentVersion() (int64, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version int64
+	if err := DB.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Status lists every known migration and whether it has been applied
+func Status() ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration
+func MigrateUp() error {
+	latest, err := LatestVersion()
+	if err != nil {
+		return err
+	}
+	return MigrateTo(latest)
+}
+
+// MigrateDown rolls back the given number of applied migrations
+func MigrateDown(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be at least 1")
+	}
+
+	statuses, err := Status()
+	if err != nil {
+		return err
+	}
+
+	var applied []int64
+	for _, status := range statuses {
+		if status.Applied {
+			applied = append(applied, status.Version)
+		}
+	}
+	if len(applied) == 0 {
+		log.Println("No migrations to roll back")
+		return nil
+	}
+
+	target := int64(0)
+	if steps < len(applied) {
+		target = applied[len(applied)-steps-1]
+	}
+	return MigrateTo(target)
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at the
+// given version. Version 0 rolls back everything.
+func MigrateTo(target int64) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if target != 0 && !hasVersion(migrations, target) {
+		return fmt.Errorf("unknown migration version %d", target)
+	}
+
+	if err := ensureMigrationsTable(); err != nil {
+		return err
+	}
+
+	// Apply pending migrations up to the target, oldest first
+	for _, migration := range migrations {
+		if migration.Version > target {
+			break
+		}
+		if err := applyMigration(migration, true); err != nil {
+			return err
+		}
+	}
+
+	// Roll back applied migrations above the target, newest first
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if migrations[i].Version <= target {
+			break
+		}
+		if err := applyMigration(migrations[i], false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckSchema returns an error when migrations are pending. The server calls
+// it at startup instead of migrating implicitly.
+func CheckSchema() error {
+	latest, err := LatestVersion()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := Status()
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("database schema is behind: %d pending migration(s), latest version is %d; run \"migrate up\"", pending, latest)
+	}
+
+	current, err := CurrentVersion()
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		log.Printf("Warning: database schema version %d is newer than this binary (%d)", current, latest)
+	}
+
+	return nil
+}
+
+// applyMigration runs one migration in its own transaction. The advisory
+// lock and the re-check inside the transaction make concurrent runs safe.
+func applyMigration(migration Migration, up bool) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+
+		var count int64
+		if err := tx.Model(&SchemaMigration{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to read schema version: %w", err)
+		}
+
+		applied := count > 0
+		if applied == up {
+			return nil
+		}
+
+		if up {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return fmt.Errorf("migration %d_%s up failed: %w", migration.Version, migration.Name, err)
+			}
+			row := SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+			if err := tx.Create(&row).Error; err != nil {
+				return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+			}
+			log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+			return nil
+		}
+
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return fmt.Errorf("migration %d_%s down failed: %w", migration.Version, migration.Name, err)
+		}
+		if err := tx.Where("version = ?", migration.Version).Delete(&SchemaMigration{}).Error; err != nil {
+			return fmt.Errorf("failed to record rollback of migration %d: %w", migration.Version, err)
+		}
+		log.Printf("Rolled back migration %d_%s", migration.Version, migration.Name)
+		return nil
+	})
+}
+
+// ensureMigrationsTable creates the schema_migrations table if needed
+func ensureMigrationsTable() error {
+	err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations returns the applied migrations keyed by version
+func appliedMigrations() (map[int64]SchemaMigration, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	var rows []SchemaMigration
+	if err := DB.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	applied := make(map[int64]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func hasVersion(migrations []Migration, version int64) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
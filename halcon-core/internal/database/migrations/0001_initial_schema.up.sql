@@ -0,0 +1,43 @@
+-- Initial schema. Uses IF NOT EXISTS so databases previously created by
+-- GORM AutoMigrate can be adopted without changes.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            BIGSERIAL PRIMARY KEY,
+    username      TEXT NOT NULL,
+    password_hash TEXT NOT NULL,
+    role          VARCHAR(20) NOT NULL,
+    department    VARCHAR(100),
+    full_name     VARCHAR(200),
+    email         VARCHAR(200),
+    is_active     BOOLEAN DEFAULT TRUE,
+    created_at    TIMESTAMPTZ,
+    updated_at    TIMESTAMPTZ,
+    deleted_at    TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id                 BIGSERIAL PRIMARY KEY,
+    invoice_number     TEXT NOT NULL,
+    customer_name      VARCHAR(200) NOT NULL,
+    customer_number    VARCHAR(100) NOT NULL,
+    status             VARCHAR(20) NOT NULL DEFAULT 'Ordered',
+    delivery_address   TEXT,
+    notes              TEXT,
+    evidence_photo_url VARCHAR(500),
+    is_deleted         BOOLEAN DEFAULT FALSE,
+    created_by         BIGINT NOT NULL,
+    last_modified_by   BIGINT,
+    created_at         TIMESTAMPTZ,
+    updated_at         TIMESTAMPTZ,
+    deleted_at         TIMESTAMPTZ,
+    CONSTRAINT fk_orders_created_by_user FOREIGN KEY (created_by) REFERENCES users (id),
+    CONSTRAINT fk_orders_last_modified_user FOREIGN KEY (last_modified_by) REFERENCES users (id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_invoice_number ON orders (invoice_number);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_number ON orders (customer_number);
+CREATE INDEX IF NOT EXISTS idx_orders_is_deleted ON orders (is_deleted);
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS idempotency_records (
+    id              BIGSERIAL PRIMARY KEY,
+    idempotency_key VARCHAR(255) NOT NULL,
+    user_id         BIGINT NOT NULL,
+    method          VARCHAR(10) NOT NULL,
+    path            VARCHAR(500) NOT NULL,
+    request_hash    VARCHAR(64) NOT NULL,
+    completed       BOOLEAN DEFAULT FALSE,
+    status_code     BIGINT,
+    content_type    VARCHAR(100),
+    body            BYTEA,
+    expires_at      TIMESTAMPTZ NOT NULL,
+    created_at      TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_user_key ON idempotency_records (idempotency_key, user_id);
+CREATE INDEX IF NOT EXISTS idx_idempotency_records_expires_at ON idempotency_records (expires_at);
@@ -0,0 +1,25 @@
+CREATE TABLE refresh_tokens (
+    id             BIGSERIAL PRIMARY KEY,
+    user_id        BIGINT NOT NULL REFERENCES users (id),
+    family_id      VARCHAR(64) NOT NULL,
+    token_hash     VARCHAR(64) NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL,
+    revoked_at     TIMESTAMPTZ,
+    replaced_by_id BIGINT REFERENCES refresh_tokens (id),
+    ip_address     VARCHAR(64),
+    user_agent     VARCHAR(500),
+    created_at     TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+
+CREATE TABLE revoked_tokens (
+    jti        VARCHAR(64) PRIMARY KEY,
+    user_id    BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
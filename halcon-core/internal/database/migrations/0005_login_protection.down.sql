@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS auth_events;
+
+ALTER TABLE users DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS failed_login_count;
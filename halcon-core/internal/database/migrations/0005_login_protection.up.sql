@@ -0,0 +1,20 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+
+CREATE TABLE auth_events (
+    id         BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    user_id    BIGINT,
+    username   VARCHAR(50),
+    actor_id   BIGINT,
+    ip_address VARCHAR(64),
+    user_agent VARCHAR(500),
+    detail     VARCHAR(255),
+    created_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_auth_events_event_type ON auth_events (event_type);
+CREATE INDEX idx_auth_events_user_id ON auth_events (user_id);
+CREATE INDEX idx_auth_events_username ON auth_events (username);
+CREATE INDEX idx_auth_events_ip_address ON auth_events (ip_address);
+CREATE INDEX idx_auth_events_created_at ON auth_events (created_at);
@@ -0,0 +1,21 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_service_account BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE api_keys (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users (id),
+    name         VARCHAR(100) NOT NULL,
+    prefix       VARCHAR(16) NOT NULL,
+    key_hash     VARCHAR(64) NOT NULL,
+    scopes       VARCHAR(255) NOT NULL,
+    allowed_ips  VARCHAR(1000) NOT NULL DEFAULT '',
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip VARCHAR(64),
+    usage_count  BIGINT NOT NULL DEFAULT 0,
+    revoked_at   TIMESTAMPTZ,
+    created_by   BIGINT REFERENCES users (id),
+    created_at   TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_api_keys_key_hash ON api_keys (key_hash);
+CREATE INDEX idx_api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,26 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_issuer VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX idx_users_oidc_identity ON users (oidc_issuer, oidc_subject) WHERE oidc_subject <> '';
+
+CREATE TABLE oidc_login_states (
+    id            BIGSERIAL PRIMARY KEY,
+    state_hash    VARCHAR(64) NOT NULL,
+    nonce         VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    redirect_path VARCHAR(255) NOT NULL DEFAULT '',
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_oidc_login_states_state_hash ON oidc_login_states (state_hash);
+
+CREATE TABLE sso_handoffs (
+    id         BIGSERIAL PRIMARY KEY,
+    code_hash  VARCHAR(64) NOT NULL,
+    user_id    BIGINT NOT NULL REFERENCES users (id),
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_sso_handoffs_code_hash ON sso_handoffs (code_hash);
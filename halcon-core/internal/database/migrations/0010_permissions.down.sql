@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+
+ALTER TABLE users ALTER COLUMN role TYPE VARCHAR(20);
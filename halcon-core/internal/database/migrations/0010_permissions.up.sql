@@ -0,0 +1,68 @@
+-- Roles become rows bundling named permissions, and users can hold several.
+-- users.role stays as the primary role. The five built-in roles are seeded
+-- with the permissions they had when authorization was hardcoded.
+
+ALTER TABLE users ALTER COLUMN role TYPE VARCHAR(50);
+
+CREATE TABLE roles (
+    id          BIGSERIAL PRIMARY KEY,
+    name        VARCHAR(50) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    is_system   BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_roles_name ON roles (name);
+
+CREATE TABLE role_permissions (
+    role_id    BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE user_roles (
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES roles (id),
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX idx_user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO roles (name, description, is_system, created_at, updated_at) VALUES
+    ('Admin', 'Manages users, roles, service accounts and the recycle bin', TRUE, NOW(), NOW()),
+    ('Sales', 'Creates and edits orders', TRUE, NOW(), NOW()),
+    ('Purchasing', 'Follows orders that are in process', TRUE, NOW(), NOW()),
+    ('Warehouse', 'Prepares orders and hands them to delivery', TRUE, NOW(), NOW()),
+    ('Route', 'Delivers orders and uploads evidence', TRUE, NOW(), NOW());
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission
+FROM roles r
+JOIN (VALUES
+    ('Admin', 'orders.read'),
+    ('Admin', 'orders.delete'),
+    ('Admin', 'orders.restore'),
+    ('Admin', 'users.read'),
+    ('Admin', 'users.manage'),
+    ('Admin', 'roles.manage'),
+    ('Admin', 'service_accounts.manage'),
+    ('Admin', 'auth_events.read'),
+    ('Sales', 'orders.read'),
+    ('Sales', 'orders.create'),
+    ('Sales', 'orders.update'),
+    ('Sales', 'orders.delete'),
+    ('Sales', 'orders.restore'),
+    ('Purchasing', 'orders.read.in_process'),
+    ('Warehouse', 'orders.read'),
+    ('Warehouse', 'orders.update'),
+    ('Warehouse', 'orders.transition.in_process'),
+    ('Warehouse', 'orders.transition.in_route'),
+    ('Route', 'orders.read'),
+    ('Route', 'orders.update'),
+    ('Route', 'orders.transition.delivered'),
+    ('Route', 'evidence.upload')
+) AS p (role, permission) ON p.role = r.name;
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u JOIN roles r ON r.name = u.role;
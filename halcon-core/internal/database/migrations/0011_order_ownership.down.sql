@@ -0,0 +1,8 @@
+DELETE FROM role_permissions WHERE permission IN ('orders.scope.all', 'orders.scope.department');
+DELETE FROM roles r
+WHERE r.name = 'Sales Manager' AND NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.role_id = r.id);
+
+DROP INDEX IF EXISTS idx_orders_created_by;
+DROP INDEX IF EXISTS idx_users_team;
+
+ALTER TABLE users DROP COLUMN IF EXISTS team;
@@ -0,0 +1,31 @@
+-- Orders belong to the user who created them and to that user's team.
+-- Users whose roles lack orders.scope.all only see and change those orders,
+-- or the orders of their whole department with orders.scope.department.
+
+ALTER TABLE users ADD COLUMN team VARCHAR(100) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_users_team ON users (team) WHERE team <> '';
+CREATE INDEX IF NOT EXISTS idx_orders_created_by ON orders (created_by);
+
+-- Every built-in role except Sales keeps acting on all orders
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'orders.scope.all' FROM roles WHERE name IN ('Admin', 'Purchasing', 'Warehouse', 'Route')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles (name, description, is_system, created_at, updated_at)
+VALUES ('Sales Manager', 'Manages the orders of their department', FALSE, NOW(), NOW())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission
+FROM roles r
+CROSS JOIN (VALUES
+    ('orders.read'),
+    ('orders.create'),
+    ('orders.update'),
+    ('orders.delete'),
+    ('orders.restore'),
+    ('orders.scope.department')
+) AS p (permission)
+WHERE r.name = 'Sales Manager'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+DELETE FROM role_permissions WHERE permission IN ('branches.all', 'branches.manage', 'orders.transfer');
+
+DROP TABLE IF EXISTS order_transfers;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS branch_id;
+ALTER TABLE users DROP COLUMN IF EXISTS branch_id;
+
+DROP TABLE IF EXISTS branches;
@@ -0,0 +1,53 @@
+-- Branches, each with its own warehouse and drivers. Existing users and
+-- orders move to a default branch; orders can later be transferred between
+-- branches, which order_transfers records.
+
+CREATE TABLE branches (
+    id         BIGSERIAL PRIMARY KEY,
+    code       VARCHAR(20) NOT NULL,
+    name       VARCHAR(100) NOT NULL,
+    address    TEXT NOT NULL DEFAULT '',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    is_active  BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_branches_code ON branches (code);
+-- At most one default branch
+CREATE UNIQUE INDEX idx_branches_default ON branches (is_default) WHERE is_default;
+
+INSERT INTO branches (code, name, is_default, created_at, updated_at)
+VALUES ('MAIN', 'Main branch', TRUE, NOW(), NOW());
+
+ALTER TABLE users ADD COLUMN branch_id BIGINT REFERENCES branches (id);
+UPDATE users SET branch_id = (SELECT id FROM branches WHERE code = 'MAIN');
+CREATE INDEX idx_users_branch_id ON users (branch_id);
+
+ALTER TABLE orders ADD COLUMN branch_id BIGINT REFERENCES branches (id);
+UPDATE orders SET branch_id = (SELECT id FROM branches WHERE code = 'MAIN');
+ALTER TABLE orders ALTER COLUMN branch_id SET NOT NULL;
+CREATE INDEX idx_orders_branch_id ON orders (branch_id);
+
+CREATE TABLE order_transfers (
+    id             BIGSERIAL PRIMARY KEY,
+    order_id       BIGINT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    from_branch_id BIGINT NOT NULL REFERENCES branches (id),
+    to_branch_id   BIGINT NOT NULL REFERENCES branches (id),
+    transferred_by BIGINT NOT NULL REFERENCES users (id),
+    note           TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ
+);
+
+CREATE INDEX idx_order_transfers_order_id ON order_transfers (order_id);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission
+FROM roles r
+JOIN (VALUES
+    ('Admin', 'branches.all'),
+    ('Admin', 'branches.manage'),
+    ('Admin', 'orders.transfer'),
+    ('Warehouse', 'orders.transfer')
+) AS p (role, permission) ON p.role = r.name
+ON CONFLICT DO NOTHING;
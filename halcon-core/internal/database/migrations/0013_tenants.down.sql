@@ -0,0 +1,36 @@
+-- Only the default tenant's data survives the return to one tenant
+DELETE FROM role_permissions WHERE permission = 'tenant.manage';
+
+DELETE FROM oidc_login_states WHERE tenant_id <> 1;
+DELETE FROM auth_events WHERE tenant_id <> 1;
+DELETE FROM order_transfers WHERE tenant_id <> 1;
+DELETE FROM orders WHERE tenant_id <> 1;
+DELETE FROM users WHERE tenant_id <> 1;
+DELETE FROM roles WHERE tenant_id <> 1;
+DELETE FROM branches WHERE tenant_id <> 1;
+
+DROP INDEX IF EXISTS idx_orders_due_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS due_at;
+
+DROP INDEX IF EXISTS idx_branches_default;
+CREATE UNIQUE INDEX idx_branches_default ON branches (is_default) WHERE is_default;
+DROP INDEX IF EXISTS idx_branches_tenant_code;
+CREATE UNIQUE INDEX idx_branches_code ON branches (code);
+DROP INDEX IF EXISTS idx_roles_tenant_name;
+CREATE UNIQUE INDEX idx_roles_name ON roles (name);
+DROP INDEX IF EXISTS idx_orders_tenant_invoice_number;
+CREATE UNIQUE INDEX idx_orders_invoice_number ON orders (invoice_number);
+DROP INDEX IF EXISTS idx_users_oidc_identity;
+CREATE UNIQUE INDEX idx_users_oidc_identity ON users (oidc_issuer, oidc_subject) WHERE oidc_subject <> '';
+DROP INDEX IF EXISTS idx_users_tenant_username;
+CREATE UNIQUE INDEX idx_users_username ON users (username);
+
+ALTER TABLE oidc_login_states DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE auth_events DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE order_transfers DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE branches DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE roles DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
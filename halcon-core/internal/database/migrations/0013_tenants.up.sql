@@ -0,0 +1,65 @@
+-- Tenants are the companies hosted on one deployment. Users, orders, roles,
+-- branches, transfers and auth events belong to a tenant, and names that
+-- were unique become unique per tenant. Existing data moves to the default
+-- tenant, which has id 1.
+
+CREATE TABLE tenants (
+    id                        BIGSERIAL PRIMARY KEY,
+    slug                      VARCHAR(50) NOT NULL,
+    name                      VARCHAR(100) NOT NULL,
+    logo_url                  VARCHAR(500) NOT NULL DEFAULT '',
+    is_active                 BOOLEAN NOT NULL DEFAULT TRUE,
+    allow_direct_dispatch     BOOLEAN NOT NULL DEFAULT FALSE,
+    require_delivery_evidence BOOLEAN NOT NULL DEFAULT FALSE,
+    delivery_sla_hours        INTEGER NOT NULL DEFAULT 0,
+    created_at                TIMESTAMPTZ,
+    updated_at                TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_tenants_slug ON tenants (slug);
+
+INSERT INTO tenants (id, slug, name, created_at, updated_at)
+VALUES (1, 'default', 'Halcon', NOW(), NOW());
+SELECT setval(pg_get_serial_sequence('tenants', 'id'), 1);
+
+ALTER TABLE users ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE orders ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE roles ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE branches ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE order_transfers ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE auth_events ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+-- Single sign-on callbacks share one URL, so the login state names the tenant
+ALTER TABLE oidc_login_states ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1 REFERENCES tenants (id) ON DELETE CASCADE;
+
+-- New rows must name their tenant
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE orders ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE roles ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE branches ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE order_transfers ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE auth_events ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE oidc_login_states ALTER COLUMN tenant_id DROP DEFAULT;
+
+DROP INDEX IF EXISTS idx_users_username;
+CREATE UNIQUE INDEX idx_users_tenant_username ON users (tenant_id, username);
+DROP INDEX IF EXISTS idx_users_oidc_identity;
+CREATE UNIQUE INDEX idx_users_oidc_identity ON users (tenant_id, oidc_issuer, oidc_subject) WHERE oidc_subject <> '';
+DROP INDEX IF EXISTS idx_orders_invoice_number;
+CREATE UNIQUE INDEX idx_orders_tenant_invoice_number ON orders (tenant_id, invoice_number);
+DROP INDEX IF EXISTS idx_roles_name;
+CREATE UNIQUE INDEX idx_roles_tenant_name ON roles (tenant_id, name);
+DROP INDEX IF EXISTS idx_branches_code;
+CREATE UNIQUE INDEX idx_branches_tenant_code ON branches (tenant_id, code);
+DROP INDEX IF EXISTS idx_branches_default;
+CREATE UNIQUE INDEX idx_branches_default ON branches (tenant_id) WHERE is_default;
+
+CREATE INDEX idx_order_transfers_tenant_id ON order_transfers (tenant_id);
+CREATE INDEX idx_auth_events_tenant_id ON auth_events (tenant_id);
+
+-- Orders of tenants with a delivery SLA are due this long after creation
+ALTER TABLE orders ADD COLUMN due_at TIMESTAMPTZ;
+CREATE INDEX idx_orders_due_at ON orders (due_at) WHERE due_at IS NOT NULL;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'tenant.manage' FROM roles WHERE name = 'Admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_auth_events_actor_id;
+
+DELETE FROM role_permissions WHERE permission = 'users.impersonate';
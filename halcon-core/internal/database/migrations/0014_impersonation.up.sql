@@ -0,0 +1,6 @@
+-- Admins may act as other users; auth events record the impersonator in actor_id
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'users.impersonate' FROM roles WHERE name = 'Admin'
+ON CONFLICT DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS idx_auth_events_actor_id ON auth_events (actor_id);
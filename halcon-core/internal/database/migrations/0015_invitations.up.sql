@@ -0,0 +1,26 @@
+-- Invitations let admins onboard users by email; the invitee sets the password
+CREATE TABLE invitations (
+    id           BIGSERIAL PRIMARY KEY,
+    tenant_id    BIGINT NOT NULL REFERENCES tenants (id),
+    email        VARCHAR(200) NOT NULL,
+    full_name    VARCHAR(200) NOT NULL DEFAULT '',
+    role         VARCHAR(50) NOT NULL,
+    department   VARCHAR(100) NOT NULL DEFAULT '',
+    branch_id    BIGINT REFERENCES branches (id),
+    token_hash   VARCHAR(64) NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    sent_count   INTEGER NOT NULL DEFAULT 0,
+    last_sent_at TIMESTAMPTZ,
+    invited_by   BIGINT NOT NULL REFERENCES users (id),
+    accepted_at  TIMESTAMPTZ,
+    user_id      BIGINT REFERENCES users (id),
+    revoked_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_invitations_token_hash ON invitations (token_hash);
+CREATE INDEX idx_invitations_tenant_id ON invitations (tenant_id);
+-- At most one open invitation per address and tenant
+CREATE UNIQUE INDEX idx_invitations_open_email ON invitations (tenant_id, LOWER(email))
+    WHERE accepted_at IS NULL AND revoked_at IS NULL;
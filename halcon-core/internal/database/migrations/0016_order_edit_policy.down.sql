@@ -0,0 +1,3 @@
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'orders.update' FROM roles WHERE is_system AND name IN ('Warehouse', 'Route')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- Warehouse and Route only move orders along; editing the delivery address
+-- and notes stays with the roles that take orders
+DELETE FROM role_permissions rp
+USING roles r
+WHERE rp.role_id = r.id
+  AND r.is_system
+  AND r.name IN ('Warehouse', 'Route')
+  AND rp.permission = 'orders.update';
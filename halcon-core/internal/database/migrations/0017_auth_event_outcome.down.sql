@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_auth_events_outcome;
+
+DELETE FROM auth_events WHERE event_type IN ('token_refreshed', 'token_refresh_failed', 'permission_denied');
+
+ALTER TABLE auth_events DROP COLUMN IF EXISTS outcome;
@@ -0,0 +1,15 @@
+-- Every auth event records whether the attempt succeeded, so failures can
+-- be counted regardless of their type
+ALTER TABLE auth_events ADD COLUMN outcome VARCHAR(10) NOT NULL DEFAULT 'success';
+
+UPDATE auth_events SET outcome = 'failure'
+WHERE event_type IN (
+    'login_failed',
+    'login_throttled',
+    'account_locked',
+    'refresh_token_reused',
+    'two_factor_failed',
+    'api_key_rejected'
+);
+
+CREATE INDEX idx_auth_events_outcome ON auth_events (outcome);
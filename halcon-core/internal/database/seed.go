@@ -0,0 +1,96 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nietzshn/halcon-core/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// DemoSeedResult reports what SeedDemo created
+type DemoSeedResult struct {
+	Users  []string `json:"users"`
+	Orders []string `json:"orders"`
+}
+
+// demoUsers are created by SeedDemo, one per non-admin role
+var demoUsers = []models.User{
+	{Username: "sales", Role: models.RoleSales, Department: "Sales", Team: "North", FullName: "Demo Sales", Email: "sales@halcon.com"},
+	{Username: "purchasing", Role: models.RolePurchasing, Department: "Purchasing", FullName: "Demo Purchasing", Email: "purchasing@halcon.com"},
+	{Username: "warehouse", Role: models.RoleWarehouse, Department: "Warehouse", FullName: "Demo Warehouse", Email: "warehouse@halcon.com"},
+	{Username: "route", Role: models.RoleRoute, Department: "Route", FullName: "Demo Route", Email: "route@halcon.com"},
+}
+
+// demoOrders are created by SeedDemo on behalf of the demo sales user
+var demoOrders = []models.Order{
+	{InvoiceNumber: "DEMO-0001", CustomerName: "Ferretería El Halcón", CustomerNumber: "C-1001", Status: models.StatusOrdered, DeliveryAddress: "Av. Juárez 120, Centro"},
+	{InvoiceNumber: "DEMO-0002", CustomerName: "Constructora Norte", CustomerNumber: "C-1002", Status: models.StatusInProcess, DeliveryAddress: "Blvd. Industrial 45, Parque Norte"},
+	{InvoiceNumber: "DEMO-0003", CustomerName: "Materiales Delta", CustomerNumber: "C-1003", Status: models.StatusInRoute, DeliveryAddress: "Calle 5 de Mayo 310, Col. Delta"},
+	{InvoiceNumber: "DEMO-0004", CustomerName: "Ferretería El Halcón", CustomerNumber: "C-1001", Status: models.StatusDelivered, DeliveryAddress: "Av. Juárez 120, Centro"},
+}
+
+// SeedDemo creates demo users (all sharing the given password) and sample
+// orders in a tenant. Existing users and orders are left untouched, so it
+// can be run more than once.
+func SeedDemo(tenantID uint, password string) (*DemoSeedResult, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	result := &DemoSeedResult{Users: []string{}, Orders: []string{}}
+	err = ForTenant(tenantID).Transaction(func(tx *gorm.DB) error {
+		var sales models.User
+		for _, demo := range demoUsers {
+			user := demo
+			user.PasswordHash = string(hashedPassword)
+			user.IsActive = true
+
+			created, err := firstOrCreate(tx, &user, "username = ?", user.Username)
+			if err != nil {
+				return fmt.Errorf("failed to create user %s: %w", user.Username, err)
+			}
+			if created {
+				result.Users = append(result.Users, user.Username)
+			}
+			if user.Role == models.RoleSales {
+				sales = user
+			}
+		}
+
+		for _, demo := range demoOrders {
+			order := demo
+			order.CreatedBy = sales.ID
+			order.LastModifiedBy = sales.ID
+
+			created, err := firstOrCreate(tx, &order, "invoice_number = ?", order.InvoiceNumber)
+			if err != nil {
+				return fmt.Errorf("failed to create order %s: %w", order.InvoiceNumber, err)
+			}
+			if created {
+				result.Orders = append(result.Orders, order.InvoiceNumber)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// firstOrCreate loads the record matching the condition into value, or
+// creates value when none exists. It reports whether a record was created.
+func firstOrCreate(tx *gorm.DB, value interface{}, query string, args ...interface{}) (bool, error) {
+	err := tx.Unscoped().Where(query, args...).First(value).Error
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	return true, tx.Create(value).Error
+}
@@ -1,86 +1,325 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
 	"github.com/nietzshn/halcon-core/internal/models"
 	"github.com/nietzshn/halcon-core/internal/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type LoginRequest struct {
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
+	Username string `json:"username" validate:"required,max=50"`
+	Password string `json:"password" validate:"required,max=72"`
 }
 
 type LoginResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresIn    int          `json:"expires_in"`
+	User         UserResponse `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=128"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"max=128"`
 }
 
 type UserResponse struct {
-	ID         uint            `json:"id"`
-	Username   string          `json:"username"`
-	Role       models.UserRole `json:"role"`
-	Department string          `json:"department"`
-	FullName   string          `json:"full_name"`
-	Email      string          `json:"email"`
+	ID          uint                `json:"id"`
+	Username    string              `json:"username"`
+	Role        models.UserRole     `json:"role"`
+	Roles       []models.UserRole   `json:"roles"`
+	Permissions []models.Permission `json:"permissions"`
+	Department  string              `json:"department"`
+
+	Team               string `json:"team"`
+	BranchID           *uint  `json:"branch_id"`
+	FullName           string `json:"full_name"`
+	Email              string `json:"email"`
+	MustChangePassword bool   `json:"must_change_password"`
+	TwoFactorEnabled   bool   `json:"two_factor_enabled"`
+	SSO                bool   `json:"sso"`
+
+	// Impersonator is the admin acting as the user, set in /auth/me for
+	// impersonation tokens
+	Impersonator *ImpersonatorResponse `json:"impersonator,omitempty"`
+}
+
+type ImpersonatorResponse struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required,max=72"`
+	NewPassword     string `json:"new_password" validate:"required,max=72,nefield=CurrentPassword"`
+}
+
+// newUserResponse builds the public view of a user, including the roles
+// and permissions the web client uses to adapt its navigation
+func newUserResponse(user *models.User) UserResponse {
+	roles, permissions := []models.UserRole{}, []models.Permission{}
+	if status, err := auth.CurrentStatus(user.ID); err == nil {
+		roles, permissions = append(roles, status.Roles...), status.Permissions.List()
+	}
+
+	return UserResponse{
+		ID:                 user.ID,
+		Username:           user.Username,
+		Role:               user.Role,
+		Roles:              roles,
+		Permissions:        permissions,
+		Department:         user.Department,
+		Team:               user.Team,
+		BranchID:           user.BranchID,
+		FullName:           user.FullName,
+		Email:              user.Email,
+		MustChangePassword: user.MustChangePassword,
+		TwoFactorEnabled:   user.TOTPEnabled,
+		SSO:                user.IsSSO(),
+	}
+}
+
+// clientInfo describes the caller for the refresh token record and the
+// auth event log
+func clientInfo(c echo.Context) auth.ClientInfo {
+	return auth.ClientInfo{IPAddress: c.RealIP(), UserAgent: c.Request().UserAgent(), TenantID: requestTenant(c)}
+}
+
+// respondWithSession issues a new token pair for the user and writes it
+// together with the user profile
+func respondWithSession(c echo.Context, status int, user *models.User) error {
+	pair, err := auth.IssueTokenPair(user, clientInfo(c))
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to generate token").Wrap(err)
+	}
+
+	return c.JSON(status, newLoginResponse(pair, user))
 }
 
-// Login authenticates a user and returns a JWT token
+func newLoginResponse(pair *auth.TokenPair, user *models.User) LoginResponse {
+	return LoginResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    pair.ExpiresIn,
+		User:         newUserResponse(user),
+	}
+}
+
+// completeLogin records a successful login. The IP keeps its throttled
+// failures so one valid account can't reset guessing on others.
+func completeLogin(user *models.User, client auth.ClientInfo, detail string) {
+	auth.LoginThrottle.Reset(auth.UserThrottleKey(user.TenantID, user.Username))
+	auth.RecordEvent(models.AuthEvent{Type: models.EventLoginSucceeded, UserID: &user.ID, Username: user.Username, Detail: detail}, client)
+}
+
+// twoFactorChallenge answers a login of a user with 2FA with an
+// intermediate token for the second step
+func twoFactorChallenge(c echo.Context, user *models.User) error {
+	token, expiresIn, err := utils.GenerateTwoFactorToken(user)
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to generate token").Wrap(err)
+	}
+	return c.JSON(http.StatusOK, TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		TwoFactorToken:    token,
+		ExpiresIn:         expiresIn,
+	})
+}
+
+// retryAfter sets the Retry-After header, rounded up to whole seconds, and
+// returns err
+func retryAfter(c echo.Context, wait time.Duration, err error) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	return err
+}
+
+// Login authenticates a user and returns an access and refresh token
 func Login(c echo.Context) error {
 	var req LoginRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	client := clientInfo(c)
+	throttleKeys := []string{auth.IPThrottleKey(client.IPAddress), auth.UserThrottleKey(client.TenantID, req.Username)}
+
+	// Back off exponentially per IP and per username after repeated failures
+	if wait := auth.LoginThrottle.Wait(throttleKeys...); wait > 0 {
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginThrottled, Username: req.Username}, client)
+		return retryAfter(c, wait, apierror.New(http.StatusTooManyRequests, apierror.CodeTooManyRequests, "too many failed login attempts, try again later"))
 	}
 
-	// Find user by username
+	// Find user by username. Unknown users still cost a password check so
+	// the response time doesn't reveal which accounts exist.
 	var user models.User
-	if err := database.DB.Where("username = ? AND is_active = ?", req.Username, true).First(&user).Error; err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
+	if err := tenantDB(c).Where("username = ? AND is_active = ? AND is_service_account = ?", req.Username, true, false).First(&user).Error; err != nil {
+		auth.VerifyPassword(nil, req.Password)
+		auth.LoginThrottle.Fail(throttleKeys...)
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, Username: req.Username, Detail: "unknown, inactive or service account"}, client)
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "invalid credentials")
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
+	// Verify password. A wrong password gets the same answer whether or not
+	// the account is locked, and doesn't extend a lockout.
+	if !auth.VerifyPassword(&user, req.Password) {
+		auth.LoginThrottle.Fail(throttleKeys...)
+		if auth.IsLocked(&user) {
+			auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, UserID: &user.ID, Username: user.Username, Detail: "wrong password, account locked"}, client)
+			return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "invalid credentials")
+		}
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, UserID: &user.ID, Username: user.Username, Detail: "wrong password"}, client)
+
+		locked, lockErr := auth.RegisterLoginFailure(&user)
+		if lockErr != nil {
+			return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to record login attempt").Wrap(lockErr)
+		}
+		if locked {
+			auth.RecordEvent(models.AuthEvent{Type: models.EventAccountLocked, UserID: &user.ID, Username: user.Username}, client)
+		}
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "invalid credentials")
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateToken(&user)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate token")
-	}
-
-	return c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User: UserResponse{
-			ID:         user.ID,
-			Username:   user.Username,
-			Role:       user.Role,
-			Department: user.Department,
-			FullName:   user.FullName,
-			Email:      user.Email,
-		},
-	})
+	// Only someone who knows the password learns that the account is locked
+	if auth.IsLocked(&user) {
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, UserID: &user.ID, Username: user.Username, Detail: "account locked"}, client)
+		return retryAfter(c, time.Until(*user.LockedUntil), apierror.New(http.StatusLocked, apierror.CodeAccountLocked, "account is temporarily locked after too many failed login attempts"))
+	}
+
+	// Checked after the password so the answer doesn't reveal SSO accounts
+	if !auth.PasswordLoginAllowed(&user) {
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, UserID: &user.ID, Username: user.Username, Detail: "password login disabled for SSO user"}, client)
+		return apierror.New(http.StatusForbidden, apierror.CodePasswordLoginOff, "password login is disabled, sign in with single sign-on")
+	}
+
+	if err := auth.ResetLoginFailures(&user); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to record login attempt").Wrap(err)
+	}
+
+	if user.TOTPEnabled {
+		return twoFactorChallenge(c, &user)
+	}
+
+	completeLogin(&user, client, "")
+	return respondWithSession(c, http.StatusOK, &user)
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. The presented
+// refresh token is single-use; replaying it revokes the whole session.
+func RefreshToken(c echo.Context) error {
+	var req RefreshRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	pair, user, err := auth.Refresh(req.RefreshToken, clientInfo(c))
+	switch {
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		return apierror.New(http.StatusUnauthorized, apierror.CodeRefreshReused, "refresh token was already used, please log in again")
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidRefresh, "invalid or expired refresh token")
+	case err != nil:
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to refresh token").Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, newLoginResponse(pair, user))
+}
+
+// Logout revokes the current access token and, when given, the session of
+// the refresh token
+func Logout(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+
+	var req LogoutRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	tokenID, _ := c.Get("token_id").(string)
+	expiresAt, _ := c.Get("token_expires_at").(time.Time)
+	if err := auth.Logout(userID, tokenID, expiresAt, req.RefreshToken); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to log out").Wrap(err)
+	}
+
+	event := models.AuthEvent{Type: models.EventLogout, UserID: &userID}
+	event.Username, _ = c.Get("username").(string)
+	if actorID, ok := c.Get("impersonator_id").(uint); ok {
+		event.Type, event.ActorID = models.EventImpersonationEnded, &actorID
+	}
+	auth.RecordEvent(event, clientInfo(c))
+
+	return c.NoContent(http.StatusNoContent)
 }
 
-// GetCurrentUser returns the currently authenticated user
+// GetCurrentUser returns the currently authenticated user, and the admin
+// acting as them when impersonating
 func GetCurrentUser(c echo.Context) error {
 	userID := c.Get("user_id").(uint)
 
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	if err := tenantDB(c).First(&user, userID).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
 	}
 
-	return c.JSON(http.StatusOK, UserResponse{
-		ID:         user.ID,
-		Username:   user.Username,
-		Role:       user.Role,
-		Department: user.Department,
-		FullName:   user.FullName,
-		Email:      user.Email,
-	})
+	response := newUserResponse(&user)
+	if actorID, ok := c.Get("impersonator_id").(uint); ok {
+		username, _ := c.Get("impersonator_username").(string)
+		response.Impersonator = &ImpersonatorResponse{ID: actorID, Username: username}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// ChangePassword lets the current user replace their password and returns
+// a fresh token pair, which also lifts a pending must-change-password block.
+// Every other session of the user is revoked.
+func ChangePassword(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+
+	var req ChangePasswordRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := tenantDB(c).First(&user, userID).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "current password is incorrect")
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword, user.Username)
+	if err != nil {
+		return passwordError("new_password", err)
+	}
+
+	user.PasswordHash = hashedPassword
+	user.MustChangePassword = false
+	if err := tenantDB(c).Save(&user).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to update password")
+	}
+	auth.InvalidateUser(user.ID)
+
+	if err := auth.RevokeUserSessions(user.ID); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to revoke sessions").Wrap(err)
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventPasswordChanged, UserID: &user.ID, Username: user.Username}, clientInfo(c))
+
+	return respondWithSession(c, http.StatusOK, &user)
 }
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+type AuthEventsQuery struct {
+	Username string    `json:"username" query:"username" validate:"max=50"`
+	UserID   uint      `json:"user_id" query:"user_id"`
+	ActorID  uint      `json:"actor_id" query:"actor_id"`
+	Type     string    `json:"type" query:"type" validate:"max=50"`
+	Outcome  string    `json:"outcome" query:"outcome" validate:"omitempty,oneof=success failure"`
+	IP       string    `json:"ip" query:"ip" validate:"max=64"`
+	Since    time.Time `json:"since" query:"since"`
+	Limit    int       `json:"limit" query:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+// GetAuthEvents lists authentication events, newest first (auth_events.read).
+// Supports filtering by username, user_id, actor_id, type, outcome, ip and
+// since (RFC 3339); actor_id lists what an admin did while impersonating.
+func GetAuthEvents(c echo.Context) error {
+	var req AuthEventsQuery
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = 100
+	}
+
+	query := tenantDB(c).Order("created_at DESC").Limit(req.Limit)
+	if req.Username != "" {
+		query = query.Where("username = ?", req.Username)
+	}
+	if req.UserID != 0 {
+		query = query.Where("user_id = ?", req.UserID)
+	}
+	if req.ActorID != 0 {
+		query = query.Where("actor_id = ?", req.ActorID)
+	}
+	if req.Type != "" {
+		query = query.Where("event_type = ?", req.Type)
+	}
+	if req.Outcome != "" {
+		query = query.Where("outcome = ?", req.Outcome)
+	}
+	if req.IP != "" {
+		query = query.Where("ip_address = ?", req.IP)
+	}
+	if !req.Since.IsZero() {
+		query = query.Where("created_at >= ?", req.Since)
+	}
+
+	var events []models.AuthEvent
+	if err := query.Find(&events).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch auth events")
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+type AuthAnomaliesQuery struct {
+	// Since defaults to 24 hours ago
+	Since time.Time `query:"since"`
+	// MinFailures defaults to AUTH_ANOMALY_FAILURE_THRESHOLD
+	MinFailures int `query:"min_failures" validate:"omitempty,min=1"`
+}
+
+// FailingIP is a client address with many refused attempts
+type FailingIP struct {
+	IPAddress string `json:"ip_address"`
+	Failures  int64  `json:"failures"`
+	// Usernames counts the distinct usernames the failures named
+	Usernames int64     `json:"usernames"`
+	FirstAt   time.Time `json:"first_at"`
+	LastAt    time.Time `json:"last_at"`
+}
+
+type AuthAnomalyReport struct {
+	Since           time.Time          `json:"since"`
+	MinFailures     int                `json:"min_failures"`
+	UsualLoginHours string             `json:"usual_login_hours"`
+	Timezone        string             `json:"timezone"`
+	FailingIPs      []FailingIP        `json:"failing_ips"`
+	OffHoursLogins  []models.AuthEvent `json:"off_hours_logins"`
+}
+
+// offHoursLoginLimit caps the logins listed by the anomaly report
+const offHoursLoginLimit = 500
+
+// GetAuthAnomalies reports client IPs with at least min_failures refused
+// attempts and successful logins outside the usual login hours since the
+// given time (auth_events.read)
+func GetAuthAnomalies(c echo.Context) error {
+	var req AuthAnomaliesQuery
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+	if req.Since.IsZero() {
+		req.Since = time.Now().Add(-24 * time.Hour)
+	}
+	if req.MinFailures == 0 {
+		req.MinFailures = config.AppConfig.AuthAnomalyFailureThreshold
+	}
+
+	report := AuthAnomalyReport{
+		Since:           req.Since,
+		MinFailures:     req.MinFailures,
+		UsualLoginHours: config.AppConfig.AuthUsualLoginHours,
+		Timezone:        config.AppConfig.AuthUsualLoginTimezone,
+	}
+
+	err := tenantDB(c).Model(&models.AuthEvent{}).
+		Select("ip_address, COUNT(*) AS failures, COUNT(DISTINCT username) AS usernames, MIN(created_at) AS first_at, MAX(created_at) AS last_at").
+		Where("outcome = ? AND created_at >= ? AND ip_address <> ''", models.OutcomeFailure, req.Since).
+		Group("ip_address").
+		Having("COUNT(*) >= ?", req.MinFailures).
+		Order("failures DESC").
+		Scan(&report.FailingIPs).Error
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to count failures")
+	}
+
+	// Validated at startup
+	start, end, _ := config.AppConfig.UsualLoginHours()
+	hour := "EXTRACT(HOUR FROM created_at AT TIME ZONE ?)"
+	outside := "(" + hour + " < ? OR " + hour + " >= ?)"
+	if start > end {
+		// Usual hours span midnight
+		outside = "(" + hour + " < ? AND " + hour + " >= ?)"
+	}
+	tz := report.Timezone
+	err = tenantDB(c).
+		Where("event_type = ? AND created_at >= ?", models.EventLoginSucceeded, req.Since).
+		Where(outside, tz, start, tz, end).
+		Order("created_at DESC").
+		Limit(offHoursLoginLimit).
+		Find(&report.OffHoursLogins).Error
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch logins")
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
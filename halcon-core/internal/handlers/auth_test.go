@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	clerkID       = 30
+	clerkPassword = "correct horse battery"
+	clientIP      = "203.0.113.7"
+)
+
+// clerkActor is the clerk signed in, without any permission
+var clerkActor = testActor{id: clerkID, username: "clerk", roles: []models.UserRole{"Sales"}}
+
+// seedClerk stores an active user of tenant A who signs in with
+// clerkPassword
+func seedClerk(t *testing.T, fake *fakeDB) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(clerkPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	fake.insert("users", fakeRow{"id": int64(clerkID), "tenant_id": int64(tenantA), "username": "clerk", "email": "clerk@example.com", "password_hash": string(hash), "role": "Sales", "is_active": true, "is_service_account": false, "failed_login_count": int64(0)})
+}
+
+// signIn logs the clerk in and returns the session
+func signIn(t *testing.T) LoginResponse {
+	t.Helper()
+	status, body := call(t, Login, nil, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"username":"clerk","password":%q}`, clerkPassword),
+		ip:     clientIP,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("login: got status %d (%s)", status, body)
+	}
+	var session LoginResponse
+	if err := json.Unmarshal([]byte(body), &session); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	return session
+}
+
+// refresh exchanges a refresh token and returns the response status and
+// the new session
+func refresh(t *testing.T, token string) (int, LoginResponse) {
+	t.Helper()
+	status, body := call(t, RefreshToken, nil, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"refresh_token":%q}`, token),
+		ip:     clientIP,
+	})
+	var session LoginResponse
+	if status == http.StatusOK {
+		if err := json.Unmarshal([]byte(body), &session); err != nil {
+			t.Fatalf("decode refresh response: %v", err)
+		}
+	}
+	return status, session
+}
+
+// login posts the credentials without the login throttle getting in the
+// way and returns the response status
+func login(t *testing.T, username, password string) int {
+	t.Helper()
+	auth.LoginThrottle.Reset(auth.IPThrottleKey(clientIP), auth.UserThrottleKey(tenantA, username))
+	status, _ := call(t, Login, nil, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"username":%q,"password":%q}`, username, password),
+		ip:     clientIP,
+	})
+	return status
+}
+
+func TestRefreshRotatesTheRefreshToken(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedClerk(t, fake)
+
+	first := signIn(t)
+	status, second := refresh(t, first.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("refresh: got status %d", status)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh returned refresh token %q, want a new one", second.RefreshToken)
+	}
+	if second.Token == "" || second.User.ID != clerkID {
+		t.Fatalf("refresh returned token %q for user %d", second.Token, second.User.ID)
+	}
+}
+
+func TestReusedRefreshTokenRevokesTheSession(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedClerk(t, fake)
+
+	first := signIn(t)
+	_, second := refresh(t, first.RefreshToken)
+
+	if status, _ := refresh(t, first.RefreshToken); status != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: got status %d, want 401", status)
+	}
+	if status, _ := refresh(t, second.RefreshToken); status != http.StatusUnauthorized {
+		t.Fatalf("refresh token of a revoked session: got status %d, want 401", status)
+	}
+}
+
+func TestLogoutRevokesTheRefreshToken(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedClerk(t, fake)
+
+	session := signIn(t)
+	status, body := call(t, Logout, &clerkActor, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"refresh_token":%q}`, session.RefreshToken),
+	})
+	if status != http.StatusNoContent {
+		t.Fatalf("logout: got status %d (%s)", status, body)
+	}
+	if status, _ := refresh(t, session.RefreshToken); status != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: got status %d, want 401", status)
+	}
+}
+
+func TestLoginLocksAccountAfterRepeatedFailures(t *testing.T) {
+	fake := testDB(t)
+	seedClerk(t, fake)
+
+	for i := 1; i <= 5; i++ {
+		if status := login(t, "clerk", "wrong password"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got status %d, want 401", i, status)
+		}
+		locked := fake.rows("users", fakeRow{"id": int64(clerkID)})[0]["locked_until"]
+		if i < 5 && locked != nil {
+			t.Fatalf("locked after %d failures", i)
+		}
+		if i == 5 {
+			if until, ok := locked.(time.Time); !ok || !until.After(time.Now()) {
+				t.Fatalf("locked_until = %v after 5 failures, want a time in the future", locked)
+			}
+		}
+	}
+
+	// A wrong password is refused like an unknown account's and doesn't
+	// count towards another lockout
+	if status := login(t, "clerk", "wrong password"); status != http.StatusUnauthorized {
+		t.Fatalf("wrong password while locked: got status %d, want 401", status)
+	}
+	if count := fake.rows("users", fakeRow{"id": int64(clerkID)})[0]["failed_login_count"]; count != int64(0) {
+		t.Fatalf("failed_login_count = %v while locked, want 0", count)
+	}
+	if status := login(t, "nobody", "wrong password"); status != http.StatusUnauthorized {
+		t.Fatalf("unknown user: got status %d, want 401", status)
+	}
+
+	if status := login(t, "clerk", clerkPassword); status != http.StatusLocked {
+		t.Fatalf("correct password while locked: got status %d, want 423", status)
+	}
+}
+
+func TestLoginThrottlesRepeatedFailures(t *testing.T) {
+	fake := testDB(t)
+	seedClerk(t, fake)
+	t.Cleanup(func() {
+		auth.LoginThrottle.Reset(auth.IPThrottleKey(clientIP), auth.UserThrottleKey(tenantA, "clerk"))
+	})
+
+	var status int
+	for i := 0; i < 5; i++ {
+		status, _ = call(t, Login, nil, testRequest{
+			method: http.MethodPost,
+			body:   `{"username":"clerk","password":"wrong password"}`,
+			ip:     clientIP,
+		})
+	}
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("attempt after four failures in a row: got status %d, want 429", status)
+	}
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+type CreateBranchRequest struct {
+	Code      string `json:"code" validate:"required,min=2,max=20,alphanum"`
+	Name      string `json:"name" validate:"required,max=100"`
+	Address   string `json:"address" validate:"max=500"`
+	IsDefault bool   `json:"is_default"`
+}
+
+type UpdateBranchRequest struct {
+	Name      string `json:"name" validate:"required,max=100"`
+	Address   string `json:"address" validate:"max=500"`
+	IsActive  *bool  `json:"is_active"`
+	IsDefault *bool  `json:"is_default"`
+}
+
+type TransferOrderRequest struct {
+	BranchID uint   `json:"branch_id" validate:"required"`
+	Note     string `json:"note" validate:"max=500"`
+}
+
+// GetBranches lists all branches
+func GetBranches(c echo.Context) error {
+	var branches []models.Branch
+	if err := tenantDB(c).Order("name").Find(&branches).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch branches")
+	}
+	return c.JSON(http.StatusOK, branches)
+}
+
+// CreateBranch adds a branch (branches.manage)
+func CreateBranch(c echo.Context) error {
+	var req CreateBranchRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	branch := models.Branch{
+		Code:     strings.ToUpper(req.Code),
+		Name:     req.Name,
+		Address:  req.Address,
+		IsActive: true,
+	}
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&branch).Error; err != nil {
+			return err
+		}
+		if req.IsDefault {
+			return makeDefaultBranch(tx, &branch)
+		}
+		return nil
+	})
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to create branch")
+	}
+	return c.JSON(http.StatusCreated, branch)
+}
+
+// UpdateBranch changes the name, address, active flag or default branch
+// (branches.manage). The default branch can't be deactivated; making
+// another branch the default moves the flag.
+func UpdateBranch(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeNotFound, "branch not found")
+	}
+
+	var req UpdateBranchRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	var branch models.Branch
+	if err := tenantDB(c).Where("id = ?", id).First(&branch).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeNotFound, "branch not found")
+	}
+
+	if req.IsDefault != nil && !*req.IsDefault && branch.IsDefault {
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "make another branch the default instead")
+	}
+	makeDefault := req.IsDefault != nil && *req.IsDefault && !branch.IsDefault
+	if req.IsActive != nil {
+		branch.IsActive = *req.IsActive
+	}
+	if !branch.IsActive && (branch.IsDefault || makeDefault) {
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "the default branch can't be deactivated")
+	}
+	branch.Name = req.Name
+	branch.Address = req.Address
+
+	err = tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&branch).Error; err != nil {
+			return err
+		}
+		if makeDefault {
+			return makeDefaultBranch(tx, &branch)
+		}
+		return nil
+	})
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to update branch")
+	}
+	return c.JSON(http.StatusOK, branch)
+}
+
+// TransferOrder moves an order to another branch and records the transfer
+// (orders.transfer). Only orders of the user's own branch can be sent
+// unless their roles grant branches.all.
+func TransferOrder(c echo.Context) error {
+	id := c.Param("id")
+	userID := c.Get("user_id").(uint)
+
+	var req TransferOrderRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	query, err := ownedOrders(c, tenantDB(c))
+	if err != nil {
+		return err
+	}
+	var order models.Order
+	if err := query.First(&order, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeOrderNotFound, "order not found")
+	}
+	if err := authorizeOrderEdit(c, &order, "branch_id"); err != nil {
+		return err
+	}
+
+	var target models.Branch
+	if err := tenantDB(c).Where("id = ? AND is_active = ?", req.BranchID, true).First(&target).Error; err != nil {
+		return branchFieldError("branch_id", "unknown or inactive branch")
+	}
+	if target.ID == order.BranchID {
+		return branchFieldError("branch_id", "the order is already in this branch")
+	}
+
+	transfer := models.OrderTransfer{
+		OrderID:       order.ID,
+		FromBranchID:  order.BranchID,
+		ToBranchID:    target.ID,
+		TransferredBy: userID,
+		Note:          req.Note,
+	}
+	err = tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&transfer).Error; err != nil {
+			return err
+		}
+		return tx.Model(&order).Updates(map[string]interface{}{"branch_id": target.ID, "last_modified_by": userID}).Error
+	})
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to transfer order")
+	}
+
+	tenantDB(c).Preload("CreatedByUser").Preload("LastModifiedUser").Preload("Branch").First(&order, order.ID)
+	return c.JSON(http.StatusOK, order)
+}
+
+// GetOrderTransfers lists the branch transfers of an order, oldest first
+func GetOrderTransfers(c echo.Context) error {
+	id := c.Param("id")
+
+	query, err := ownedOrders(c, visibleOrders(c, tenantDB(c)))
+	if err != nil {
+		return err
+	}
+	var order models.Order
+	if err := query.First(&order, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeOrderNotFound, "order not found")
+	}
+
+	var transfers []models.OrderTransfer
+	err = tenantDB(c).Preload("FromBranch").Preload("ToBranch").
+		Where("order_id = ?", order.ID).Order("created_at, id").Find(&transfers).Error
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch transfers")
+	}
+	return c.JSON(http.StatusOK, transfers)
+}
+
+// branchForUser loads an active branch the user may assign, which is only
+// their own branch unless their roles grant branches.all
+func branchForUser(c echo.Context, id uint, field string) (*models.Branch, error) {
+	var branch models.Branch
+	if err := tenantDB(c).Where("id = ? AND is_active = ?", id, true).First(&branch).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, branchFieldError(field, "unknown or inactive branch")
+		}
+		return nil, apierror.FromDB(err, apierror.CodeInternal, "failed to load branch")
+	}
+
+	if !can(c, models.PermBranchesAll) {
+		status, err := auth.CurrentStatus(c.Get("user_id").(uint))
+		if err != nil {
+			return nil, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load user").Wrap(err)
+		}
+		if status.BranchID == nil || *status.BranchID != branch.ID {
+			return nil, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "your roles only allow your own branch")
+		}
+	}
+	return &branch, nil
+}
+
+// makeDefaultBranch moves the default flag to the branch
+func makeDefaultBranch(tx *gorm.DB, branch *models.Branch) error {
+	if err := tx.Model(&models.Branch{}).Where("is_default = ?", true).Update("is_default", false).Error; err != nil {
+		return err
+	}
+	branch.IsDefault = true
+	return tx.Model(branch).Update("is_default", true).Error
+}
+
+func branchFieldError(field, message string) error {
+	return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+		WithFields(apierror.FieldError{Field: field, Code: "branch", Message: message})
+}
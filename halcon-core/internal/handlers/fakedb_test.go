@@ -0,0 +1,770 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeDB is an in-memory stand-in for PostgreSQL that understands just
+// enough SQL for the handler tests. Rows are matched by the comparisons,
+// IN lists and NULL checks of a WHERE clause, all of which must hold, and
+// by parenthesized alternatives joined with OR; other conditions are
+// ignored, so a statement without a tenant filter matches
+// the rows of every tenant. Conditions on columns a row doesn't have, such
+// as those on tables that aren't joined, never match. Inserts, updates and
+// deletes are applied, and a rolled back transaction restores the tables.
+type fakeDB struct {
+	mu       sync.Mutex
+	tables   map[string][]fakeRow
+	keys     map[string][]fakeKey
+	lastID   int64
+	mutated  []fakeMutation
+	snapshot map[string][]fakeRow
+}
+
+// fakeRow maps column names to values
+type fakeRow map[string]driver.Value
+
+// fakeKey is a unique constraint over some columns of a table
+type fakeKey struct {
+	name    string
+	columns []string
+}
+
+// fakeMutation is a row matched by an UPDATE or DELETE, as it was before
+// the statement
+type fakeMutation struct {
+	SQL   string
+	Table string
+	Row   fakeRow
+}
+
+// fakeCondition is a single condition of a WHERE clause, or with op "or"
+// a group that holds when all conditions of any alternative do
+type fakeCondition struct {
+	column       string
+	op           string
+	values       []driver.Value
+	alternatives [][]fakeCondition
+}
+
+var (
+	fakeTable     = regexp.MustCompile(`(?is)^\s*(?:SELECT\s.*?\sFROM|UPDATE|DELETE\s+FROM|INSERT\s+INTO)\s+"?(\w+)"?`)
+	fakeJoin      = regexp.MustCompile(`(?i)\sJOIN\s+"?(\w+)"?\s+ON\s+"?(\w+)"?\."?(\w+)"?\s*=\s*"?(\w+)"?\."?(\w+)"?`)
+	fakeWhere     = regexp.MustCompile(`(?is)\sWHERE\s(.*?)(?:\sORDER BY\s|\sGROUP BY\s|\sLIMIT\s|\sOFFSET\s|\sRETURNING\s|\sFOR UPDATE|$)`)
+	fakeCompare   = regexp.MustCompile(`(?i)((?:"?\w+"?\.)?"?\w+"?)\s*(=|<>|!=|<=|>=|<|>)\s*(\$\d+|true|false|\d+|'[^']*')`)
+	fakeNull      = regexp.MustCompile(`(?i)((?:"?\w+"?\.)?"?\w+"?)\s+IS\s+(NOT\s+)?NULL`)
+	fakeIn        = regexp.MustCompile(`(?i)((?:"?\w+"?\.)?"?\w+"?)\s+IN\s*\(([^()]*)\)`)
+	fakeOrder     = regexp.MustCompile(`(?i)\sORDER BY\s+(?:"?\w+"?\.)?"?(\w+)"?(\s+DESC)?`)
+	fakeLimit     = regexp.MustCompile(`(?i)\sLIMIT\s+(\$\d+|\d+)`)
+	fakeOffset    = regexp.MustCompile(`(?i)\sOFFSET\s+(\$\d+|\d+)`)
+	fakeCount     = regexp.MustCompile(`(?i)^\s*SELECT\s+count\(`)
+	fakeColumns   = regexp.MustCompile(`(?is)^\s*SELECT\s+(?:DISTINCT\s+)?(.*?)\sFROM\s`)
+	fakeInsert    = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+"?(\w+)"?\s*\(([^)]*)\)\s*VALUES\s*(.*?)(\sON CONFLICT\s.*?)?(?:\sRETURNING\s+(.*))?$`)
+	fakeInsertSel = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+"?(\w+)"?\s*\(([^)]*)\)\s*(SELECT\s.*?)(\sON CONFLICT\s.*?)?$`)
+	fakeTuple     = regexp.MustCompile(`\(([^()]*)\)`)
+	fakeSet       = regexp.MustCompile(`(?is)\sSET\s(.*?)(?:\sWHERE\s|\sRETURNING\s|$)`)
+	fakeIncrement = regexp.MustCompile(`^"?(\w+)"?\s*\+\s*(\d+)$`)
+	fakeOr        = regexp.MustCompile(`(?i)\([^()]*\sOR\s[^()]*\)`)
+	fakeOrSplit   = regexp.MustCompile(`(?i)\sOR\s`)
+)
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{tables: map[string][]fakeRow{}, keys: map[string][]fakeKey{}}
+}
+
+// insert adds a row to a table without checking its constraints
+func (f *fakeDB) insert(table string, row fakeRow) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := row["id"].(int64); ok && id > f.lastID {
+		f.lastID = id
+	}
+	f.tables[table] = append(f.tables[table], row)
+}
+
+// set changes columns of the row of a table with the given id
+func (f *fakeDB) set(table string, id int64, values fakeRow) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, row := range f.tables[table] {
+		if row["id"] == id {
+			for column, value := range values {
+				row[column] = value
+			}
+		}
+	}
+}
+
+// unique declares a unique constraint that inserts are checked against
+func (f *fakeDB) unique(table, name string, columns ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[table] = append(f.keys[table], fakeKey{name: name, columns: columns})
+}
+
+// rows returns copies of the rows of a table that have the given values
+func (f *fakeDB) rows(table string, where fakeRow) []fakeRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rows []fakeRow
+	for _, row := range f.tables[table] {
+		ok := true
+		for column, value := range where {
+			if compareValues(row[column], value) != 0 {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			rows = append(rows, copyRow(row))
+		}
+	}
+	return rows
+}
+
+// open returns a GORM handle on the fake database
+func (f *fakeDB) open() (*gorm.DB, error) {
+	return gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fakeConnector{f})}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+}
+
+// mutations returns the rows matched by updates and deletes so far
+func (f *fakeDB) mutations() []fakeMutation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeMutation(nil), f.mutated...)
+}
+
+// match returns the table of a statement and its rows that satisfy the
+// WHERE conditions, joined to the rows of any joined tables. Joined rows
+// hold every column both as "table.column" and, for the statement's own
+// table, as "column".
+func (f *fakeDB) match(query string, args []driver.NamedValue) (string, []fakeRow, []fakeRow) {
+	m := fakeTable.FindStringSubmatch(query)
+	if m == nil {
+		return "", nil, nil
+	}
+	table := m[1]
+
+	var where string
+	if w := fakeWhere.FindStringSubmatch(query); w != nil {
+		where = w[1]
+	}
+	conditions := parseConditions(where, args)
+
+	var rows, sources []fakeRow
+	for _, row := range f.tables[table] {
+		joined := []fakeRow{qualify(table, row, fakeRow{})}
+		for _, join := range fakeJoin.FindAllStringSubmatch(query, -1) {
+			joined = f.join(joined, join[1:])
+		}
+		for _, candidate := range joined {
+			if matchesAll(candidate, conditions) {
+				rows = append(rows, candidate)
+				sources = append(sources, row)
+			}
+		}
+	}
+	return table, rows, sources
+}
+
+// join combines rows with the rows of a joined table that satisfy the ON
+// equality
+func (f *fakeDB) join(rows []fakeRow, on []string) []fakeRow {
+	table := on[0]
+	left, right := on[1]+"."+on[2], on[3]+"."+on[4]
+	var joined []fakeRow
+	for _, row := range rows {
+		for _, other := range f.tables[table] {
+			candidate := qualify(table, other, copyRow(row))
+			if compareValues(candidate[left], candidate[right]) == 0 && candidate[left] != nil {
+				joined = append(joined, candidate)
+			}
+		}
+	}
+	return joined
+}
+
+// qualify adds the columns of a table's row to dst as "table.column", and
+// as "column" when dst doesn't have it yet. The "table.*" entry marks the
+// tables a row was joined from.
+func qualify(table string, row, dst fakeRow) fakeRow {
+	dst[table+".*"] = true
+	for column, value := range row {
+		dst[table+"."+column] = value
+		if _, ok := dst[column]; !ok {
+			dst[column] = value
+		}
+	}
+	return dst
+}
+
+// parseConditions extracts the conditions the fake understands from a
+// WHERE clause
+func parseConditions(where string, args []driver.NamedValue) []fakeCondition {
+	var conditions []fakeCondition
+	for _, group := range fakeOr.FindAllString(where, -1) {
+		cond := fakeCondition{op: "or"}
+		for _, alternative := range fakeOrSplit.Split(group[1:len(group)-1], -1) {
+			cond.alternatives = append(cond.alternatives, parseConditions(alternative, args))
+		}
+		conditions = append(conditions, cond)
+	}
+	where = fakeOr.ReplaceAllString(where, "")
+
+	for _, m := range fakeCompare.FindAllStringSubmatch(where, -1) {
+		conditions = append(conditions, fakeCondition{column: columnName(m[1]), op: m[2], values: []driver.Value{literal(m[3], args)}})
+	}
+	for _, m := range fakeNull.FindAllStringSubmatch(where, -1) {
+		op := "null"
+		if m[2] != "" {
+			op = "not null"
+		}
+		conditions = append(conditions, fakeCondition{column: columnName(m[1]), op: op})
+	}
+	for _, m := range fakeIn.FindAllStringSubmatch(where, -1) {
+		cond := fakeCondition{column: columnName(m[1]), op: "in"}
+		for _, item := range strings.Split(m[2], ",") {
+			cond.values = append(cond.values, literal(strings.TrimSpace(item), args))
+		}
+		conditions = append(conditions, cond)
+	}
+	return conditions
+}
+
+// matchesAll reports whether a row satisfies every condition
+func matchesAll(row fakeRow, conditions []fakeCondition) bool {
+	for _, cond := range conditions {
+		value, known := row[cond.column]
+		switch cond.op {
+		case "or":
+			found := false
+			for _, alternative := range cond.alternatives {
+				if matchesAll(row, alternative) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		case "null":
+			// Columns left out when a row was stored are NULL, columns of
+			// tables that aren't joined are unknown
+			if table, _, qualified := strings.Cut(cond.column, "."); value != nil || (!known && qualified && row[table+".*"] == nil) {
+				return false
+			}
+		case "not null":
+			if value == nil {
+				return false
+			}
+		case "in":
+			found := false
+			for _, v := range cond.values {
+				if known && value != nil && compareValues(value, v) == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			if !known || value == nil || cond.values[0] == nil || !compareOp(compareValues(value, cond.values[0]), cond.op) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compareOp(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "<>", "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// compareValues orders two column values, comparing numbers and times by
+// value and everything else by its text
+func compareValues(a, b driver.Value) int {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(text(a), text(b))
+}
+
+func number(v driver.Value) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func text(v driver.Value) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// columnName strips the quotes of a possibly qualified column name
+func columnName(name string) string {
+	return strings.ReplaceAll(name, `"`, "")
+}
+
+// literal resolves a placeholder or SQL literal to a value
+func literal(token string, args []driver.NamedValue) driver.Value {
+	switch {
+	case strings.HasPrefix(token, "$"):
+		n, _ := strconv.Atoi(token[1:])
+		if n < 1 || n > len(args) {
+			return nil
+		}
+		return args[n-1].Value
+	case strings.EqualFold(token, "true"), strings.EqualFold(token, "false"):
+		return strings.EqualFold(token, "true")
+	case strings.HasPrefix(token, "'"):
+		return strings.Trim(token, "'")
+	case strings.EqualFold(token, "NULL"), strings.EqualFold(token, "DEFAULT"):
+		return nil
+	}
+	if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return n
+	}
+	return token
+}
+
+func copyRow(row fakeRow) fakeRow {
+	c := make(fakeRow, len(row))
+	for k, v := range row {
+		c[k] = v
+	}
+	return c
+}
+
+func (f *fakeDB) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(strings.Fields(query)[0]) {
+	case "INSERT":
+		rows, _, err := f.insertRows(query, args)
+		return rows, err
+	case "UPDATE", "DELETE":
+		_, err := f.mutate(query, args)
+		return &fakeRows{}, err
+	}
+
+	_, rows, _ := f.match(query, args)
+	if fakeCount.MatchString(query) {
+		return &fakeRows{columns: []string{"count"}, values: [][]driver.Value{{int64(len(rows))}}}, nil
+	}
+
+	if m := fakeOrder.FindStringSubmatch(query); m != nil {
+		desc := m[2] != ""
+		sort.SliceStable(rows, func(i, j int) bool {
+			cmp := compareValues(rows[i][m[1]], rows[j][m[1]])
+			if desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+	if m := fakeOffset.FindStringSubmatch(query); m != nil {
+		if n, ok := literal(m[1], args).(int64); ok {
+			rows = rows[min(int(n), len(rows)):]
+		}
+	}
+	if m := fakeLimit.FindStringSubmatch(query); m != nil {
+		if n, ok := literal(m[1], args).(int64); ok && int(n) < len(rows) {
+			rows = rows[:n]
+		}
+	}
+
+	result := &fakeRows{}
+	selected := selectedColumns(query)
+	if selected == nil {
+		seen := map[string]bool{}
+		for _, row := range rows {
+			for column := range row {
+				if !strings.Contains(column, ".") && !seen[column] {
+					seen[column] = true
+					selected = append(selected, column)
+				}
+			}
+		}
+		sort.Strings(selected)
+	}
+	for _, column := range selected {
+		result.columns = append(result.columns, column[strings.LastIndexByte(column, '.')+1:])
+	}
+
+	distinct := regexp.MustCompile(`(?i)^\s*SELECT\s+DISTINCT\s`).MatchString(query)
+	seen := map[string]bool{}
+	for _, row := range rows {
+		values := make([]driver.Value, len(selected))
+		for i, column := range selected {
+			value, ok := row[column]
+			if !ok {
+				value = row[column[strings.LastIndexByte(column, '.')+1:]]
+			}
+			values[i] = value
+		}
+		if distinct {
+			key := fmt.Sprint(values)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		result.values = append(result.values, values)
+	}
+	return result, nil
+}
+
+// selectedColumns returns the possibly qualified column names of a SELECT
+// list, or nil when it selects every column
+func selectedColumns(query string) []string {
+	m := fakeColumns.FindStringSubmatch(query)
+	if m == nil || strings.Contains(m[1], "*") {
+		return nil
+	}
+	var columns []string
+	for _, expr := range strings.Split(m[1], ",") {
+		fields := strings.Fields(expr)
+		columns = append(columns, columnName(fields[len(fields)-1]))
+	}
+	return columns
+}
+
+// insertRows applies an INSERT with VALUES or a SELECT of placeholders and
+// columns, and returns its RETURNING columns and the number of rows
+// inserted
+func (f *fakeDB) insertRows(query string, args []driver.NamedValue) (*fakeRows, int, error) {
+	var table, conflict, returning string
+	var columns []string
+	var rows []fakeRow
+	if m := fakeInsertSel.FindStringSubmatch(query); m != nil {
+		table, columns, conflict = m[1], insertColumns(m[2]), m[4]
+		selected := selectedColumns(m[3])
+		_, sources, _ := f.match(m[3], args)
+		for _, source := range sources {
+			row := fakeRow{}
+			for i, expr := range selected {
+				if i < len(columns) {
+					if value, ok := source[expr]; ok {
+						row[columns[i]] = value
+					} else {
+						row[columns[i]] = literal(expr, args)
+					}
+				}
+			}
+			rows = append(rows, row)
+		}
+	} else if m := fakeInsert.FindStringSubmatch(query); m != nil {
+		table, columns, conflict, returning = m[1], insertColumns(m[2]), m[4], m[5]
+		for _, tuple := range fakeTuple.FindAllStringSubmatch(m[3], -1) {
+			row := fakeRow{}
+			for i, token := range strings.Split(tuple[1], ",") {
+				if i < len(columns) {
+					row[columns[i]] = literal(strings.TrimSpace(token), args)
+				}
+			}
+			rows = append(rows, row)
+		}
+	} else {
+		return nil, 0, fmt.Errorf("fakedb: unsupported insert: %s", query)
+	}
+	ignoreConflicts := strings.Contains(strings.ToUpper(conflict), "DO NOTHING")
+
+	result := &fakeRows{}
+	if returning != "" {
+		for _, column := range strings.Split(returning, ",") {
+			result.columns = append(result.columns, columnName(strings.TrimSpace(column)))
+		}
+	}
+
+	inserted := 0
+	for _, row := range rows {
+		if row["id"] == nil {
+			f.lastID++
+			row["id"] = f.lastID
+		} else if id, ok := row["id"].(int64); ok && id > f.lastID {
+			f.lastID = id
+		}
+
+		if err := f.checkKeys(table, row, nil); err != nil {
+			if ignoreConflicts {
+				continue
+			}
+			return nil, 0, err
+		}
+		f.tables[table] = append(f.tables[table], row)
+		inserted++
+
+		if result.columns != nil {
+			values := make([]driver.Value, len(result.columns))
+			for i, column := range result.columns {
+				values[i] = row[column]
+			}
+			result.values = append(result.values, values)
+		}
+	}
+	return result, inserted, nil
+}
+
+// insertColumns returns the column names of an INSERT
+func insertColumns(list string) []string {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		columns = append(columns, columnName(strings.TrimSpace(column)))
+	}
+	return columns
+}
+
+// checkKeys fails with a unique violation when row has the same key as a
+// stored row other than self
+func (f *fakeDB) checkKeys(table string, row, self fakeRow) error {
+	keys := append([]fakeKey{{name: table + "_pkey", columns: []string{"id"}}}, f.keys[table]...)
+	for _, key := range keys {
+		for _, other := range f.tables[table] {
+			if fmt.Sprintf("%p", other) == fmt.Sprintf("%p", self) {
+				continue
+			}
+			same := true
+			for _, column := range key.columns {
+				if row[column] == nil || compareValues(row[column], other[column]) != 0 {
+					same = false
+					break
+				}
+			}
+			if same {
+				return &pgconn.PgError{
+					Code:           "23505",
+					Message:        fmt.Sprintf("duplicate key value violates unique constraint %q", key.name),
+					TableName:      table,
+					ConstraintName: key.name,
+					Detail:         fmt.Sprintf("Key (%s)=(...) already exists.", strings.Join(key.columns, ", ")),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mutate applies an UPDATE or DELETE and returns the number of rows it
+// matched
+func (f *fakeDB) mutate(query string, args []driver.NamedValue) (int, error) {
+	table, _, sources := f.match(query, args)
+	var matched []fakeRow
+	seen := map[string]bool{}
+	for _, row := range sources {
+		if key := fmt.Sprintf("%p", row); !seen[key] {
+			seen[key] = true
+			matched = append(matched, row)
+		}
+	}
+	for _, row := range matched {
+		f.mutated = append(f.mutated, fakeMutation{SQL: query, Table: table, Row: copyRow(row)})
+	}
+
+	if strings.EqualFold(strings.Fields(query)[0], "DELETE") {
+		remaining := f.tables[table][:0:0]
+		for _, row := range f.tables[table] {
+			if !seen[fmt.Sprintf("%p", row)] {
+				remaining = append(remaining, row)
+			}
+		}
+		f.tables[table] = remaining
+		return len(matched), nil
+	}
+
+	set := fakeSet.FindStringSubmatch(query)
+	if set == nil {
+		return 0, fmt.Errorf("fakedb: unsupported update: %s", query)
+	}
+	for _, row := range matched {
+		updated := copyRow(row)
+		for _, assignment := range strings.Split(set[1], ",") {
+			column, expr, ok := strings.Cut(assignment, "=")
+			if !ok {
+				return 0, fmt.Errorf("fakedb: unsupported assignment %q", assignment)
+			}
+			column, expr = columnName(strings.TrimSpace(column)), strings.TrimSpace(expr)
+			if inc := fakeIncrement.FindStringSubmatch(expr); inc != nil {
+				current, _ := number(row[inc[1]])
+				step, _ := strconv.ParseInt(inc[2], 10, 64)
+				updated[column] = int64(current) + step
+				continue
+			}
+			updated[column] = literal(expr, args)
+		}
+		if err := f.checkKeys(table, updated, row); err != nil {
+			return 0, err
+		}
+		for k, v := range updated {
+			row[k] = v
+		}
+	}
+	return len(matched), nil
+}
+
+func (f *fakeDB) exec(query string, args []driver.NamedValue) (driver.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(strings.Fields(query)[0]) {
+	case "INSERT":
+		_, n, err := f.insertRows(query, args)
+		return driver.RowsAffected(n), err
+	case "UPDATE", "DELETE":
+		n, err := f.mutate(query, args)
+		return driver.RowsAffected(n), err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+// begin remembers the tables so that a rollback can restore them
+func (f *fakeDB) begin() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshot = map[string][]fakeRow{}
+	for table, rows := range f.tables {
+		for _, row := range rows {
+			f.snapshot[table] = append(f.snapshot[table], copyRow(row))
+		}
+	}
+}
+
+func (f *fakeDB) end(rollback bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rollback && f.snapshot != nil {
+		f.tables = f.snapshot
+	}
+	f.snapshot = nil
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{c.db}, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakedb: use fakeConnector")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.db.begin()
+	return fakeTx{c.db}, nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.db.query(query, args)
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.db.exec(query, args)
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.db.exec(s.query, namedValues(args))
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.db.query(s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error   { tx.db.end(false); return nil }
+func (tx fakeTx) Rollback() error { tx.db.end(true); return nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
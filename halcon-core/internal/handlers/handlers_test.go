@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/keys"
+	"github.com/nietzshn/halcon-core/internal/middleware"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/patch"
+	"github.com/nietzshn/halcon-core/internal/tenant"
+	"github.com/nietzshn/halcon-core/internal/validation"
+)
+
+// testActor is the authenticated user a test request is made as
+type testActor struct {
+	id          uint
+	username    string
+	roles       []models.UserRole
+	permissions []models.Permission
+}
+
+// adminActor holds every permission
+var adminActor = testActor{id: adminA, username: "admin", roles: []models.UserRole{"Admin"}, permissions: allPermissions()}
+
+func allPermissions() []models.Permission {
+	var permissions []models.Permission
+	for _, p := range models.PermissionDescriptions {
+		permissions = append(permissions, p.Permission)
+	}
+	return permissions
+}
+
+// testDB loads the default configuration and points database.DB at a new
+// fake database with the tenant callbacks
+func testDB(t *testing.T) *fakeDB {
+	t.Helper()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	config.AppConfig = cfg
+
+	fake := newFakeDB()
+	db, err := fake.open()
+	if err != nil {
+		t.Fatalf("open fake database: %v", err)
+	}
+	if err := tenant.Register(db); err != nil {
+		t.Fatalf("register tenant callbacks: %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		auth.InvalidateAllUsers()
+	})
+	return fake
+}
+
+// testKeys gives the test a fresh signing key for access tokens
+func testKeys(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if _, err := keys.Generate(dir, "EdDSA"); err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+	set, err := keys.Load(dir)
+	if err != nil {
+		t.Fatalf("load signing key: %v", err)
+	}
+	previous := keys.Default
+	keys.Default = set
+	t.Cleanup(func() { keys.Default = previous })
+}
+
+// testRequest describes a request to a handler in tenant A
+type testRequest struct {
+	method string
+	params map[string]string
+	body   string
+	header map[string]string
+	ip     string
+}
+
+// call runs a handler as the actor, or anonymously when actor is nil, and
+// returns the response status and body. An error returned by the handler
+// is reported as its status and message.
+func call(t *testing.T, handler echo.HandlerFunc, actor *testActor, r testRequest) (int, string) {
+	t.Helper()
+
+	e := echo.New()
+	e.Validator = validation.New()
+
+	req := httptest.NewRequest(r.method, "/", strings.NewReader(r.body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if r.method == http.MethodPatch {
+		req.Header.Set(echo.HeaderContentType, patch.MIMEMergePatch)
+	}
+	for name, value := range r.header {
+		req.Header.Set(name, value)
+	}
+	if r.ip != "" {
+		req.RemoteAddr = r.ip + ":1234"
+	}
+	req = req.WithContext(tenant.NewContext(context.Background(), tenantA))
+	rec := httptest.NewRecorder()
+
+	c := e.NewContext(req, rec)
+	var names, values []string
+	for name, value := range r.params {
+		names, values = append(names, name), append(values, value)
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	c.Set("tenant_id", tenantA)
+	if actor != nil {
+		permissions := auth.PermissionSet{}
+		for _, p := range actor.permissions {
+			permissions[p] = true
+		}
+		c.Set("user_id", actor.id)
+		c.Set("username", actor.username)
+		if len(actor.roles) > 0 {
+			c.Set("role", actor.roles[0])
+		}
+		c.Set("roles", actor.roles)
+		c.Set("permissions", permissions)
+	}
+
+	if err := handler(c); err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			return apiErr.Status, apiErr.Message
+		}
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return rec.Code, rec.Body.String()
+}
+
+// serveAPI sends a request to tenant A through AuthMiddleware to one of a
+// few protected routes, each answering 204, and returns the response status
+func serveAPI(t *testing.T, method, path string, header map[string]string, ip string) int {
+	t.Helper()
+
+	e := echo.New()
+	e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	api := e.Group("/api", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("tenant_id", tenantA)
+			c.SetRequest(c.Request().WithContext(tenant.NewContext(c.Request().Context(), tenantA)))
+			return next(c)
+		}
+	}, middleware.AuthMiddleware())
+	noContent := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	api.GET("/auth/me", noContent)
+	api.POST("/auth/logout", noContent)
+	api.GET("/orders", noContent)
+	api.POST("/orders", noContent)
+	api.PATCH("/orders/:id", noContent)
+	api.DELETE("/orders/:id", noContent)
+	api.POST("/orders/:id/transfer", noContent)
+	api.GET("/roles", noContent)
+	api.POST("/users", noContent)
+	api.POST("/users/:id/unlock", noContent)
+	api.POST("/branches", noContent)
+	api.PUT("/branches/:id", noContent)
+
+	req := httptest.NewRequest(method, path, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	if ip != "" {
+		req.RemoteAddr = ip + ":1234"
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// withID is the route parameters of a request for a single record
+func withID(id int64) map[string]string {
+	return map[string]string{"id": strconv.FormatInt(id, 10)}
+}
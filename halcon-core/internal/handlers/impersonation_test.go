@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/utils"
+)
+
+const adminRole = 2
+
+// impersonationToken stores tenant A's admin, whose role grants every
+// permission, and the clerk, and returns a token for the admin acting as
+// the clerk
+func impersonationToken(t *testing.T, fake *fakeDB) string {
+	t.Helper()
+	seedClerk(t, fake)
+	fake.insert("users", fakeRow{"id": int64(adminA), "tenant_id": int64(tenantA), "username": "admin", "role": "Admin", "is_active": true, "is_service_account": false})
+	fake.insert("roles", fakeRow{"id": int64(adminRole), "tenant_id": int64(tenantA), "name": "Admin", "is_system": true})
+	fake.insert("user_roles", fakeRow{"user_id": int64(adminA), "role_id": int64(adminRole)})
+	for _, p := range allPermissions() {
+		fake.insert("role_permissions", fakeRow{"role_id": int64(adminRole), "permission": string(p)})
+	}
+
+	clerk := models.User{ID: clerkID, TenantID: tenantA, Username: "clerk", Role: "Sales"}
+	admin := models.User{ID: adminA, TenantID: tenantA, Username: "admin", Role: "Admin"}
+	token, _, err := utils.GenerateImpersonationToken(&clerk, &admin)
+	if err != nil {
+		t.Fatalf("generate impersonation token: %v", err)
+	}
+	return token
+}
+
+func TestImpersonationOnlyReadsAndWorksOnOrders(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	token := impersonationToken(t, fake)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/orders", http.StatusNoContent},
+		{http.MethodPost, "/api/orders", http.StatusNoContent},
+		{http.MethodPatch, "/api/orders/10", http.StatusNoContent},
+		{http.MethodPost, "/api/auth/logout", http.StatusNoContent},
+		{http.MethodDelete, "/api/orders/10", http.StatusForbidden},
+		{http.MethodPost, "/api/orders/10/transfer", http.StatusForbidden},
+		{http.MethodPost, "/api/users", http.StatusForbidden},
+		{http.MethodPost, "/api/users/11/unlock", http.StatusForbidden},
+		{http.MethodPost, "/api/branches", http.StatusForbidden},
+		{http.MethodPut, "/api/branches/3", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := map[string]string{"Authorization": "Bearer " + token}
+		if status := serveAPI(t, tt.method, tt.path, header, clientIP); status != tt.want {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, status, tt.want)
+		}
+	}
+
+	recorded := fake.rows("auth_events", fakeRow{"event_type": string(models.EventImpersonatedRequest)})
+	if len(recorded) != len(tests) {
+		t.Fatalf("got %d impersonated_request events, want %d", len(recorded), len(tests))
+	}
+}
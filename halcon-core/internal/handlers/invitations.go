@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+type CreateInvitationRequest struct {
+	Email      string          `json:"email" validate:"required,email,max=200"`
+	FullName   string          `json:"full_name" validate:"max=200"`
+	Role       models.UserRole `json:"role" validate:"required,user_role"`
+	Department string          `json:"department" validate:"max=100"`
+	// BranchID defaults like the branch of a new user
+	BranchID *uint `json:"branch_id"`
+}
+
+type InvitationListQuery struct {
+	// Status defaults to pending; all lists every invitation
+	Status string `query:"status" validate:"omitempty,oneof=pending accepted revoked expired all"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" validate:"required,max=128"`
+	Username string `json:"username" validate:"required,min=3,max=50"`
+	// FullName defaults to the name the admin entered
+	FullName string `json:"full_name" validate:"max=200"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+type InvitationResponse struct {
+	models.Invitation
+	Status models.InvitationStatus `json:"status"`
+}
+
+func newInvitationResponse(inv *models.Invitation) InvitationResponse {
+	return InvitationResponse{Invitation: *inv, Status: inv.Status(time.Now())}
+}
+
+// GetInvitations lists the invitations of the caller's branch, or of all
+// branches with branches.all (users.manage). Only pending invitations are
+// listed unless status says otherwise.
+func GetInvitations(c echo.Context) error {
+	var q InvitationListQuery
+	if err := bindAndValidate(c, &q); err != nil {
+		return err
+	}
+
+	query, err := branchInvitations(c, tenantDB(c).Preload("InvitedByUser").Preload("Branch"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	switch models.InvitationStatus(q.Status) {
+	case "", models.InvitationPending:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", now)
+	case models.InvitationAccepted:
+		query = query.Where("accepted_at IS NOT NULL")
+	case models.InvitationRevoked:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+	case models.InvitationExpired:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", now)
+	}
+
+	var invitations []models.Invitation
+	if err := query.Order("invitations.created_at DESC").Find(&invitations).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch invitations")
+	}
+
+	response := make([]InvitationResponse, len(invitations))
+	for i := range invitations {
+		response[i] = newInvitationResponse(&invitations[i])
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// CreateInvitation emails a single-use link to join the tenant with the
+// given role (users.manage). The caller must hold every permission the role
+// grants. Inviting an address again replaces its open invitation.
+func CreateInvitation(c echo.Context) error {
+	var req CreateInvitationRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+	roles, err := auth.FindRoles(tenantDB(c).Preload("Permissions"), []models.UserRole{req.Role})
+	if err != nil {
+		return rolesError("role", err)
+	}
+	if err := checkGrantableRoles(c, req.Role, roles); err != nil {
+		return err
+	}
+	branchID, err := newUserBranch(c, req.BranchID)
+	if err != nil {
+		return err
+	}
+
+	var existing int64
+	if err := tenantDB(c).Model(&models.User{}).Where("LOWER(email) = LOWER(?)", req.Email).Count(&existing).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to check email address")
+	}
+	if existing > 0 {
+		return apierror.New(http.StatusConflict, apierror.CodeDuplicateValue, "a user with this email address already exists").
+			WithFields(apierror.FieldError{Field: "email", Code: "duplicate", Message: "value is already in use"})
+	}
+
+	inv := models.Invitation{
+		Email:      req.Email,
+		FullName:   req.FullName,
+		Role:       req.Role,
+		Department: req.Department,
+		BranchID:   branchID,
+	}
+	if err := auth.CreateInvitation(tenantDB(c), &inv, c.Get("user_id").(uint), clientInfo(c)); err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to create invitation")
+	}
+
+	return c.JSON(http.StatusCreated, newInvitationResponse(&inv))
+}
+
+// ResendInvitation emails a fresh link for an open or expired invitation
+// and restarts its expiry (users.manage). Like creating it, this needs
+// every permission the invitation's role grants.
+func ResendInvitation(c echo.Context) error {
+	inv, err := findInvitation(c)
+	if err != nil {
+		return err
+	}
+	roles, err := auth.FindRoles(tenantDB(c).Preload("Permissions"), []models.UserRole{inv.Role})
+	if err != nil {
+		var unknown *auth.UnknownRoleError
+		if errors.As(err, &unknown) {
+			return apierror.New(http.StatusConflict, apierror.CodeConflict, "the role of this invitation no longer exists, create a new invitation")
+		}
+		return rolesError("role", err)
+	}
+	if err := checkGrantableRoles(c, inv.Role, roles); err != nil {
+		return err
+	}
+	if err := auth.ResendInvitation(tenantDB(c), inv, c.Get("user_id").(uint), clientInfo(c)); err != nil {
+		return invitationError(err, "failed to resend invitation")
+	}
+	return c.JSON(http.StatusOK, newInvitationResponse(inv))
+}
+
+// RevokeInvitation stops the link of an open invitation from working
+// (users.manage)
+func RevokeInvitation(c echo.Context) error {
+	inv, err := findInvitation(c)
+	if err != nil {
+		return err
+	}
+	if err := auth.RevokeInvitation(tenantDB(c), inv, c.Get("user_id").(uint), clientInfo(c)); err != nil {
+		return invitationError(err, "failed to revoke invitation")
+	}
+	return c.JSON(http.StatusOK, newInvitationResponse(inv))
+}
+
+// AcceptInvitation creates the invited user with the username and password
+// the invitee chose. The invitee logs in afterwards.
+func AcceptInvitation(c echo.Context) error {
+	var req AcceptInvitationRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	user, err := auth.AcceptInvitation(req.Token, req.Username, req.FullName, req.Password, clientInfo(c))
+	if err != nil {
+		var policyErr *auth.PasswordPolicyError
+		var unknown *auth.UnknownRoleError
+		switch {
+		case errors.Is(err, auth.ErrInvalidInvitation):
+			return apierror.New(http.StatusBadRequest, apierror.CodeInvalidInvitation, "invalid or expired invitation")
+		case errors.Is(err, auth.ErrUsernameTaken):
+			return apierror.New(http.StatusConflict, apierror.CodeDuplicateValue, "username is already taken").
+				WithFields(apierror.FieldError{Field: "username", Code: "duplicate", Message: "value is already in use"})
+		case errors.As(err, &policyErr):
+			return passwordError("password", err)
+		case errors.As(err, &unknown):
+			return apierror.New(http.StatusConflict, apierror.CodeConflict, "the role of this invitation no longer exists, ask for a new invitation")
+		}
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to accept invitation")
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+// findInvitation loads the invitation named in the path from the caller's
+// branch scope
+func findInvitation(c echo.Context) (*models.Invitation, error) {
+	query, err := branchInvitations(c, tenantDB(c))
+	if err != nil {
+		return nil, err
+	}
+	var inv models.Invitation
+	if err := query.First(&inv, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "invitation not found")
+		}
+		return nil, apierror.FromDB(err, apierror.CodeInternal, "failed to load invitation")
+	}
+	return &inv, nil
+}
+
+// branchInvitations limits a query to the invitations into the caller's
+// branch, unless they have branches.all
+func branchInvitations(c echo.Context, query *gorm.DB) (*gorm.DB, error) {
+	status, err := auth.CurrentStatus(c.Get("user_id").(uint))
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load user").Wrap(err)
+	}
+	return branchScope(c, query, status, "invitations.branch_id"), nil
+}
+
+// invitationError maps the errors of changing an invitation
+func invitationError(err error, message string) error {
+	if errors.Is(err, auth.ErrInvitationClosed) {
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "invitation was already accepted or revoked")
+	}
+	return apierror.FromDB(err, apierror.CodeInternal, message)
+}
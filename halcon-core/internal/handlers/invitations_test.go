@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+const (
+	invitationID    = 60
+	invitationToken = "invitation-token"
+)
+
+// seedInvitation stores the admin, the clerk and an open invitation of
+// tenant A to join with role
+func seedInvitation(t *testing.T, fake *fakeDB, role models.UserRole) {
+	t.Helper()
+	seedUsers(t, fake)
+	fake.unique("user_roles", "user_roles_pkey", "user_id", "role_id")
+	fake.insert("invitations", fakeRow{"id": int64(invitationID), "tenant_id": int64(tenantA), "email": "new@example.com", "full_name": "New Hire", "role": string(role), "department": "Sales", "token_hash": auth.HashToken(invitationToken), "expires_at": time.Now().Add(time.Hour), "sent_count": int64(1), "invited_by": int64(adminA)})
+}
+
+func TestAcceptInvitationCreatesTheUserWithTheRole(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedInvitation(t, fake, "Sales")
+
+	status, body := call(t, AcceptInvitation, nil, testRequest{
+		method: http.MethodPost,
+		body:   `{"token":"` + invitationToken + `","username":"newhire","password":"a long enough passphrase 42"}`,
+		ip:     clientIP,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("accept invitation: got status %d (%s), want 201", status, body)
+	}
+	var user models.User
+	if err := json.Unmarshal([]byte(body), &user); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	if user.Role != "Sales" || user.Email != "new@example.com" || user.FullName != "New Hire" || len(user.Roles) != 1 {
+		t.Fatalf("accepted invitation created %+v", user)
+	}
+	if roles := fake.rows("user_roles", fakeRow{"user_id": int64(user.ID)}); len(roles) != 1 || roles[0]["role_id"] != int64(salesRole) {
+		t.Fatalf("new user has user_roles %v, want the Sales role once", roles)
+	}
+	if inv := fake.rows("invitations", fakeRow{"id": int64(invitationID)})[0]; inv["accepted_at"] == nil || inv["user_id"] != int64(user.ID) {
+		t.Fatalf("invitation after accepting: %v", inv)
+	}
+
+	if status := login(t, "newhire", "a long enough passphrase 42"); status != http.StatusOK {
+		t.Fatalf("login of the new user: got status %d, want 200", status)
+	}
+	status, _ = call(t, AcceptInvitation, nil, testRequest{
+		method: http.MethodPost,
+		body:   `{"token":"` + invitationToken + `","username":"another","password":"a long enough passphrase 42"}`,
+		ip:     clientIP,
+	})
+	if status != http.StatusBadRequest {
+		t.Fatalf("accepting the invitation again: got status %d, want 400", status)
+	}
+}
+
+func TestResendInvitationNeedsThePermissionsOfTheRole(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedInvitation(t, fake, "Admin")
+	seedManager(fake)
+
+	status, body := call(t, ResendInvitation, &managerActor, testRequest{method: http.MethodPost, params: withID(invitationID)})
+	if status != http.StatusForbidden {
+		t.Fatalf("resending an Admin invitation without every permission: got status %d (%s), want 403", status, body)
+	}
+	if inv := fake.rows("invitations", fakeRow{"id": int64(invitationID)})[0]; inv["token_hash"] != auth.HashToken(invitationToken) {
+		t.Fatalf("refused resend issued a new token")
+	}
+
+	if status, body := call(t, ResendInvitation, &adminActor, testRequest{method: http.MethodPost, params: withID(invitationID)}); status != http.StatusOK {
+		t.Fatalf("resending as admin: got status %d (%s), want 200", status, body)
+	}
+}
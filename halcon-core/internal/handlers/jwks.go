@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/keys"
+)
+
+// GetJWKS publishes the public keys that verify access tokens so other
+// services can validate them without sharing a secret
+func GetJWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, keys.Default.JWKS())
+}
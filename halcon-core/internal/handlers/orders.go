@@ -3,24 +3,35 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
 	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/patch"
+	"gorm.io/gorm"
 )
 
 type CreateOrderRequest struct {
-	InvoiceNumber   string `json:"invoice_number" validate:"required"`
-	CustomerName    string `json:"customer_name" validate:"required"`
-	CustomerNumber  string `json:"customer_number" validate:"required"`
-	DeliveryAddress string `json:"delivery_address"`
-	Notes           string `json:"notes"`
+	InvoiceNumber   string `json:"invoice_number" validate:"required,invoice_number"`
+	CustomerName    string `json:"customer_name" validate:"required,max=200"`
+	CustomerNumber  string `json:"customer_number" validate:"required,max=100"`
+	DeliveryAddress string `json:"delivery_address" validate:"omitempty,min=5,max=500"`
+	Notes           string `json:"notes" validate:"max=2000"`
+	// BranchID defaults to the creator's branch
+	BranchID *uint `json:"branch_id"`
 }
 
+// UpdateOrderRequest is a JSON Merge Patch of an order. Members left out
+// keep their value; null clears the delivery address and the notes.
 type UpdateOrderRequest struct {
-	Status          models.OrderStatus `json:"status"`
-	DeliveryAddress string             `json:"delivery_address"`
-	Notes           string             `json:"notes"`
+	Status          patch.Field[models.OrderStatus] `json:"status" validate:"omitempty,order_status"`
+	DeliveryAddress patch.Field[string]             `json:"delivery_address" validate:"omitempty,min=5,max=500"`
+	Notes           patch.Field[string]             `json:"notes" validate:"omitempty,max=2000"`
 }
 
 type OrderFilter struct {
@@ -29,21 +40,56 @@ type OrderFilter struct {
 	CustomerNumber string
 	Status         string
 	IncludeDeleted bool
+	Scope          orderScope
+	BranchID       string
+	Overdue        bool
 }
 
-// GetOrders returns all orders with optional filters
-func GetOrders(c echo.Context) error {
-	userRole := c.Get("role").(models.UserRole)
+// orderScope selects orders by who created them
+type orderScope string
+
+const (
+	scopeMine       orderScope = "mine"
+	scopeTeam       orderScope = "team"
+	scopeDepartment orderScope = "department"
+	scopeAll        orderScope = "all"
+)
 
+// orderScopes lists the scopes from the narrowest to the widest
+var orderScopes = []orderScope{scopeMine, scopeTeam, scopeDepartment, scopeAll}
+
+// GetOrders returns the orders within the requested scope with optional
+// filters. The scope defaults to the user's own orders unless their roles
+// grant orders.scope.all. overdue=true keeps undelivered orders past the
+// due date set by the tenant's delivery SLA.
+func GetOrders(c echo.Context) error {
 	filter := OrderFilter{
 		InvoiceNumber:  c.QueryParam("invoice_number"),
 		CustomerName:   c.QueryParam("customer_name"),
 		CustomerNumber: c.QueryParam("customer_number"),
 		Status:         c.QueryParam("status"),
 		IncludeDeleted: c.QueryParam("include_deleted") == "true",
+		Scope:          orderScope(c.QueryParam("scope")),
+		BranchID:       c.QueryParam("branch_id"),
+		Overdue:        c.QueryParam("overdue") == "true",
+	}
+
+	widest := widestOrderScope(c)
+	if filter.Scope == "" {
+		filter.Scope = scopeMine
+		if widest == scopeAll {
+			filter.Scope = scopeAll
+		}
+	}
+	if scopeRank(filter.Scope) < 0 {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+			WithFields(apierror.FieldError{Field: "scope", Code: "oneof", Message: "must be one of mine, team, department, all"})
+	}
+	if scopeRank(filter.Scope) > scopeRank(widest) {
+		return apierror.New(http.StatusForbidden, apierror.CodeForbidden, fmt.Sprintf("your roles only allow listing orders up to the %s scope", widest))
 	}
 
-	query := database.DB.Preload("CreatedByUser").Preload("LastModifiedUser")
+	query := tenantDB(c).Preload("CreatedByUser").Preload("LastModifiedUser").Preload("Branch")
 
 	// Apply filters
 	if filter.InvoiceNumber != "" {
@@ -58,6 +104,17 @@ func GetOrders(c echo.Context) error {
 	if filter.Status != "" {
 		query = query.Where("status = ?", filter.Status)
 	}
+	if filter.BranchID != "" {
+		branchID, err := strconv.ParseUint(filter.BranchID, 10, 32)
+		if err != nil {
+			return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+				WithFields(apierror.FieldError{Field: "branch_id", Code: "numeric", Message: "must be a branch ID"})
+		}
+		query = query.Where("orders.branch_id = ?", branchID)
+	}
+	if filter.Overdue {
+		query = query.Where("due_at < ? AND status <> ?", time.Now(), models.StatusDelivered)
+	}
 
 	// Handle soft deletes
 	if filter.IncludeDeleted {
@@ -66,15 +123,14 @@ func GetOrders(c echo.Context) error {
 		query = query.Where("is_deleted = ?", false)
 	}
 
-	// Role-based filtering
-	if userRole == models.RolePurchasing {
-		// Purchasing can only see orders in process
-		query = query.Where("status = ?", models.StatusInProcess)
+	query, err := scopeOrders(c, visibleOrders(c, query), filter.Scope)
+	if err != nil {
+		return err
 	}
 
 	var orders []models.Order
 	if err := query.Order("created_at DESC").Find(&orders).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch orders")
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch orders")
 	}
 
 	return c.JSON(http.StatusOK, orders)
@@ -85,22 +141,26 @@ func GetOrder(c echo.Context) error {
 	id := c.Param("id")
 
 	var order models.Order
-	query := database.DB.Preload("CreatedByUser").Preload("LastModifiedUser")
+	query, err := ownedOrders(c, visibleOrders(c, tenantDB(c).Preload("CreatedByUser").Preload("LastModifiedUser").Preload("Branch")))
+	if err != nil {
+		return err
+	}
 
 	if err := query.First(&order, id).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+		return apierror.New(http.StatusNotFound, apierror.CodeOrderNotFound, "order not found")
 	}
 
 	return c.JSON(http.StatusOK, order)
 }
 
-// CreateOrder creates a new order (Sales only)
+// CreateOrder creates a new order (orders.create). Tenants with a delivery
+// SLA get the order's due date set from it.
 func CreateOrder(c echo.Context) error {
 	userID := c.Get("user_id").(uint)
 
 	var req CreateOrderRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
 	}
 
 	order := models.Order{
@@ -114,73 +174,117 @@ func CreateOrder(c echo.Context) error {
 		LastModifiedBy:  userID,
 		IsDeleted:       false,
 	}
+	if req.BranchID != nil {
+		branch, err := branchForUser(c, *req.BranchID, "branch_id")
+		if err != nil {
+			return err
+		}
+		order.BranchID = branch.ID
+	}
 
-	if err := database.DB.Create(&order).Error; err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "failed to create order")
+	settings, err := tenantSettings(c)
+	if err != nil {
+		return err
+	}
+	if settings.DeliverySLAHours > 0 {
+		dueAt := time.Now().Add(time.Duration(settings.DeliverySLAHours) * time.Hour)
+		order.DueAt = &dueAt
+	}
+
+	if err := tenantDB(c).Create(&order).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to create order")
 	}
 
 	// Reload with associations
-	database.DB.Preload("CreatedByUser").Preload("LastModifiedUser").First(&order, order.ID)
+	tenantDB(c).Preload("CreatedByUser").Preload("LastModifiedUser").Preload("Branch").First(&order, order.ID)
 
 	return c.JSON(http.StatusCreated, order)
 }
 
-// UpdateOrder updates an existing order
+// UpdateOrder applies a JSON Merge Patch to an order. Status changes need
+// the orders.transition.* permission, other fields are checked against the
+// order edit policy.
 func UpdateOrder(c echo.Context) error {
 	id := c.Param("id")
 	userID := c.Get("user_id").(uint)
-	userRole := c.Get("role").(models.UserRole)
 
+	query, err := ownedOrders(c, tenantDB(c))
+	if err != nil {
+		return err
+	}
 	var order models.Order
-	if err := database.DB.First(&order, id).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+	if err := query.First(&order, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeOrderNotFound, "order not found")
 	}
 
 	var req UpdateOrderRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	if err := bindPatch(c, &req); err != nil {
+		return err
+	}
+	if err := notNull(map[string]bool{"status": req.Status.Null || req.Status.Set && req.Status.Value == ""}); err != nil {
+		return err
 	}
 
-	// Role-based status transition validation
-	if req.Status != "" && req.Status != order.Status {
-		if err := validateStatusTransition(order.Status, req.Status, userRole); err != nil {
-			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	// Each status change needs its own permission, the other fields follow
+	// the order edit policy. Members sent with the stored value don't count
+	// as changes.
+	var changed []string
+	if req.DeliveryAddress.Set && req.DeliveryAddress.Value != order.DeliveryAddress {
+		changed = append(changed, "delivery_address")
+	}
+	if req.Notes.Set && req.Notes.Value != order.Notes {
+		changed = append(changed, "notes")
+	}
+	if err := authorizeOrderEdit(c, &order, changed...); err != nil {
+		return err
+	}
+	if req.Status.Set && req.Status.Value != order.Status {
+		if err := validateStatusTransition(c, &order, req.Status.Value); err != nil {
+			return err
 		}
-		order.Status = req.Status
+		order.Status = req.Status.Value
 	}
 
-	// Update other fields
-	if req.DeliveryAddress != "" {
-		order.DeliveryAddress = req.DeliveryAddress
+	// Update the members that were sent; null ones are cleared
+	if req.DeliveryAddress.Set {
+		order.DeliveryAddress = req.DeliveryAddress.Value
 	}
-	if req.Notes != "" {
-		order.Notes = req.Notes
+	if req.Notes.Set {
+		order.Notes = req.Notes.Value
 	}
 
 	order.LastModifiedBy = userID
 
-	if err := database.DB.Save(&order).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update order")
+	if err := tenantDB(c).Save(&order).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to update order")
 	}
 
 	// Reload with associations
-	database.DB.Preload("CreatedByUser").Preload("LastModifiedUser").First(&order, order.ID)
+	tenantDB(c).Preload("CreatedByUser").Preload("LastModifiedUser").Preload("Branch").First(&order, order.ID)
 
 	return c.JSON(http.StatusOK, order)
 }
 
-// SoftDeleteOrder marks an order as deleted
+// SoftDeleteOrder marks an order as deleted. Orders on their way or
+// delivered can't be deleted.
 func SoftDeleteOrder(c echo.Context) error {
 	id := c.Param("id")
 
+	query, err := ownedOrders(c, tenantDB(c))
+	if err != nil {
+		return err
+	}
 	var order models.Order
-	if err := database.DB.First(&order, id).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+	if err := query.First(&order, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeOrderNotFound, "order not found")
+	}
+	if err := authorizeOrderAction(c, &order, "delete"); err != nil {
+		return err
 	}
 
 	order.IsDeleted = true
-	if err := database.DB.Save(&order).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete order")
+	if err := tenantDB(c).Save(&order).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to delete order")
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "order deleted successfully"})
@@ -190,43 +294,227 @@ func SoftDeleteOrder(c echo.Context) error {
 func RestoreOrder(c echo.Context) error {
 	id := c.Param("id")
 
+	query, err := ownedOrders(c, tenantDB(c).Unscoped())
+	if err != nil {
+		return err
+	}
 	var order models.Order
-	if err := database.DB.Unscoped().Where("id = ? AND is_deleted = ?", id, true).First(&order).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "deleted order not found")
+	if err := query.Where("id = ? AND is_deleted = ?", id, true).First(&order).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeOrderNotFound, "deleted order not found")
+	}
+	if err := authorizeOrderAction(c, &order, "restore"); err != nil {
+		return err
 	}
 
 	order.IsDeleted = false
-	if err := database.DB.Save(&order).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore order")
+	if err := tenantDB(c).Save(&order).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to restore order")
 	}
 
 	return c.JSON(http.StatusOK, order)
 }
 
-// validateStatusTransition checks if a status transition is allowed for a given role
-func validateStatusTransition(currentStatus, newStatus models.OrderStatus, role models.UserRole) error {
-	transitions := map[models.OrderStatus]map[models.UserRole][]models.OrderStatus{
-		models.StatusOrdered: {
-			models.RoleWarehouse: {models.StatusInProcess},
-		},
-		models.StatusInProcess: {
-			models.RoleWarehouse: {models.StatusInRoute},
-		},
-		models.StatusInRoute: {
-			models.RoleRoute: {models.StatusDelivered},
-		},
+// statusTransitions maps each allowed status change to the permission it
+// requires
+var statusTransitions = map[models.OrderStatus]map[models.OrderStatus]models.Permission{
+	models.StatusOrdered:   {models.StatusInProcess: models.PermOrdersTransitionProcess},
+	models.StatusInProcess: {models.StatusInRoute: models.PermOrdersTransitionRoute},
+	models.StatusInRoute:   {models.StatusDelivered: models.PermOrdersTransitionDeliver},
+}
+
+// orderFieldRule says who may change a field of an order and in which
+// statuses the field is locked
+type orderFieldRule struct {
+	permissions []models.Permission // any of them allows the change
+	lockedIn    []models.OrderStatus
+}
+
+// orderFieldPolicy maps the JSON name of each editable order field to its
+// rule. Every endpoint that changes an order checks the fields it changes
+// against it with authorizeOrderEdit; status changes follow
+// statusTransitions instead.
+var orderFieldPolicy = map[string]orderFieldRule{
+	"delivery_address": {
+		permissions: []models.Permission{models.PermOrdersUpdate},
+		lockedIn:    []models.OrderStatus{models.StatusInRoute, models.StatusDelivered},
+	},
+	"notes": {
+		permissions: []models.Permission{models.PermOrdersUpdate},
+		lockedIn:    []models.OrderStatus{models.StatusDelivered},
+	},
+	"evidence_photo_url": {
+		permissions: []models.Permission{models.PermEvidenceUpload},
+		lockedIn:    []models.OrderStatus{models.StatusDelivered},
+	},
+	"branch_id": {
+		permissions: []models.Permission{models.PermOrdersTransfer},
+		lockedIn:    []models.OrderStatus{models.StatusDelivered},
+	},
+}
+
+// orderActionPolicy holds the rules of deleting and restoring an order,
+// which change its is_deleted field
+var orderActionPolicy = map[string]orderFieldRule{
+	"delete": {
+		permissions: []models.Permission{models.PermOrdersDelete},
+		lockedIn:    []models.OrderStatus{models.StatusInRoute, models.StatusDelivered},
+	},
+	"restore": {
+		permissions: []models.Permission{models.PermOrdersRestore},
+	},
+}
+
+// check returns why the user may not change the field of the order, or nil
+func (rule orderFieldRule) check(c echo.Context, order *models.Order, field string) *apierror.FieldError {
+	switch {
+	case !can(c, rule.permissions...):
+		return &apierror.FieldError{Field: field, Code: "forbidden", Message: fmt.Sprintf("requires the %s permission", auth.JoinPermissions(rule.permissions))}
+	case slices.Contains(rule.lockedIn, order.Status):
+		return &apierror.FieldError{Field: field, Code: "locked", Message: fmt.Sprintf("can't be changed once the order is %s", order.Status)}
 	}
+	return nil
+}
 
-	allowedStatuses, ok := transitions[currentStatus][role]
-	if !ok {
-		return fmt.Errorf("role %s cannot change status from %s", role, currentStatus)
+// authorizeOrderEdit checks the fields a request changes against
+// orderFieldPolicy, using the status the order has before the request. The
+// error names every field the user may not change. Deleted orders can't be
+// changed until they are restored.
+func authorizeOrderEdit(c echo.Context, order *models.Order, fields ...string) error {
+	if order.IsDeleted {
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "deleted orders can't be changed, restore the order first")
 	}
 
-	for _, allowed := range allowedStatuses {
-		if newStatus == allowed {
-			return nil
+	var denied []apierror.FieldError
+	for _, field := range fields {
+		if d := orderFieldPolicy[field].check(c, order, field); d != nil {
+			denied = append(denied, *d)
 		}
 	}
+	if len(denied) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(denied))
+	for i, d := range denied {
+		names[i] = d.Field
+	}
+	return apierror.New(http.StatusForbidden, apierror.CodeFieldNotEditable, "you may not change "+strings.Join(names, ", ")+" of this order").
+		WithFields(denied...)
+}
+
+// authorizeOrderAction checks deleting or restoring an order against
+// orderActionPolicy
+func authorizeOrderAction(c echo.Context, order *models.Order, action string) error {
+	if denied := orderActionPolicy[action].check(c, order, "is_deleted"); denied != nil {
+		return apierror.New(http.StatusForbidden, apierror.CodeFieldNotEditable, fmt.Sprintf("you may not %s this order", action)).
+			WithFields(*denied)
+	}
+	return nil
+}
+
+// widestOrderScope returns the widest scope the user's roles allow. Without
+// an orders.scope.* permission that is their own and their team's orders.
+func widestOrderScope(c echo.Context) orderScope {
+	switch {
+	case can(c, models.PermOrdersScopeAll):
+		return scopeAll
+	case can(c, models.PermOrdersScopeDepartment):
+		return scopeDepartment
+	}
+	return scopeTeam
+}
 
-	return fmt.Errorf("invalid status transition from %s to %s for role %s", currentStatus, newStatus, role)
+// scopeRank orders scopes by width, returning -1 for unknown ones
+func scopeRank(scope orderScope) int {
+	for i, s := range orderScopes {
+		if s == scope {
+			return i
+		}
+	}
+	return -1
+}
+
+// ownedOrders limits a query to the orders the user may act on
+func ownedOrders(c echo.Context, query *gorm.DB) (*gorm.DB, error) {
+	return scopeOrders(c, query, widestOrderScope(c))
+}
+
+// scopeOrders limits a query to the orders of the user's branch, unless
+// their roles grant branches.all, and to the orders created within the
+// scope. The team and department scopes fall back to the user's own orders
+// when the user has no team or department.
+func scopeOrders(c echo.Context, query *gorm.DB, scope orderScope) (*gorm.DB, error) {
+	userID := c.Get("user_id").(uint)
+	status, err := auth.CurrentStatus(userID)
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load user").Wrap(err)
+	}
+
+	query = branchScope(c, query, status, "orders.branch_id")
+	if scope == scopeAll {
+		return query, nil
+	}
+
+	// Deleted colleagues' orders still belong to the team
+	creators := tenantDB(c).Unscoped().Model(&models.User{}).Select("id")
+	switch {
+	case scope == scopeDepartment && status.Department != "":
+		creators = creators.Where("department = ?", status.Department)
+		if status.Team != "" {
+			creators = creators.Or("team = ?", status.Team)
+		}
+	case scope != scopeMine && status.Team != "":
+		creators = creators.Where("team = ?", status.Team)
+	default:
+		return query.Where("created_by = ?", userID), nil
+	}
+	return query.Where("created_by = ? OR created_by IN (?)", userID, creators), nil
+}
+
+// branchScope limits a query to rows whose column is the user's branch
+// unless their roles grant branches.all. Users without a branch see none.
+func branchScope(c echo.Context, query *gorm.DB, status *auth.UserStatus, column string) *gorm.DB {
+	if can(c, models.PermBranchesAll) {
+		return query
+	}
+	if status.BranchID == nil {
+		return query.Where("1 = 0")
+	}
+	return query.Where(column+" = ?", *status.BranchID)
+}
+
+// visibleOrders limits a query to the orders the user may see. Without
+// orders.read only orders in process are visible.
+func visibleOrders(c echo.Context, query *gorm.DB) *gorm.DB {
+	if can(c, models.PermOrdersRead) {
+		return query
+	}
+	return query.Where("status = ?", models.StatusInProcess)
+}
+
+// validateStatusTransition checks that the status change is part of the
+// tenant's workflow and that the user has the permission for it. Tenants
+// can allow dispatching orders straight from Ordered to In Route, and can
+// require an evidence photo before an order is delivered.
+func validateStatusTransition(c echo.Context, order *models.Order, newStatus models.OrderStatus) error {
+	settings, err := tenantSettings(c)
+	if err != nil {
+		return err
+	}
+
+	currentStatus := order.Status
+	permission, ok := statusTransitions[currentStatus][newStatus]
+	if !ok && settings.AllowDirectDispatch && currentStatus == models.StatusOrdered && newStatus == models.StatusInRoute {
+		permission, ok = models.PermOrdersTransitionRoute, true
+	}
+	if !ok {
+		return apierror.New(http.StatusForbidden, apierror.CodeInvalidTransition, fmt.Sprintf("invalid status transition from %s to %s", currentStatus, newStatus))
+	}
+	if !can(c, permission) {
+		return apierror.New(http.StatusForbidden, apierror.CodeInvalidTransition, fmt.Sprintf("changing status from %s to %s requires the %s permission", currentStatus, newStatus, permission))
+	}
+	if newStatus == models.StatusDelivered && settings.RequireDeliveryEvidence && order.EvidencePhotoURL == "" {
+		return apierror.New(http.StatusConflict, apierror.CodeInvalidTransition, "upload a delivery evidence photo before marking the order delivered")
+	}
+	return nil
 }
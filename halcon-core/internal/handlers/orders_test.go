@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+// dispatcherActor edits orders of every creator and branch but can't
+// delete them
+var dispatcherActor = testActor{id: adminA, username: "admin", roles: []models.UserRole{"Dispatcher"}, permissions: []models.Permission{
+	models.PermOrdersRead, models.PermOrdersUpdate, models.PermOrdersScopeAll, models.PermBranchesAll, models.PermEvidenceUpload,
+}}
+
+// seedOrder stores tenant A's order with the given status
+func seedOrder(t *testing.T, status models.OrderStatus, deleted bool) *fakeDB {
+	t.Helper()
+	fake := isolationDB(t)
+	fake.set("orders", orderA, fakeRow{"status": string(status), "is_deleted": deleted, "notes": "Ring twice"})
+	return fake
+}
+
+func TestOrderEditPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  models.OrderStatus
+		deleted bool
+		body    string
+		want    int
+	}{
+		{"address while in process", models.StatusInProcess, false, `{"delivery_address":"Side St 2"}`, http.StatusOK},
+		{"address in route", models.StatusInRoute, false, `{"delivery_address":"Side St 2"}`, http.StatusForbidden},
+		{"unchanged address in route", models.StatusInRoute, false, `{"delivery_address":"Main St 1"}`, http.StatusOK},
+		{"notes in route", models.StatusInRoute, false, `{"notes":"Leave at the door"}`, http.StatusOK},
+		{"notes once delivered", models.StatusDelivered, false, `{"notes":"Leave at the door"}`, http.StatusForbidden},
+		{"notes of a deleted order", models.StatusOrdered, true, `{"notes":"Leave at the door"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := seedOrder(t, tt.status, tt.deleted)
+
+			status, body := call(t, UpdateOrder, &dispatcherActor, testRequest{method: http.MethodPatch, params: withID(orderA), body: tt.body})
+			if status != tt.want {
+				t.Fatalf("got status %d (%s), want %d", status, body, tt.want)
+			}
+			order := fake.rows("orders", fakeRow{"id": int64(orderA)})[0]
+			if changed := order["delivery_address"] != "Main St 1" || order["notes"] != "Ring twice"; changed && tt.want != http.StatusOK {
+				t.Fatalf("refused edit changed the order: %v", order)
+			}
+		})
+	}
+}
+
+func TestOrderEvidenceIsLockedOnceDelivered(t *testing.T) {
+	for _, tt := range []struct {
+		status models.OrderStatus
+		want   int
+	}{{models.StatusInRoute, http.StatusOK}, {models.StatusDelivered, http.StatusForbidden}} {
+		t.Run(string(tt.status), func(t *testing.T) {
+			fake := seedOrder(t, tt.status, false)
+			config.AppConfig.UploadDir = t.TempDir()
+
+			var form bytes.Buffer
+			w := multipart.NewWriter(&form)
+			part, err := w.CreateFormFile("photo", "proof.jpg")
+			if err != nil {
+				t.Fatalf("create form file: %v", err)
+			}
+			part.Write([]byte("\xff\xd8\xff"))
+			w.Close()
+
+			status, body := call(t, UploadEvidence, &dispatcherActor, testRequest{
+				method: http.MethodPost,
+				params: withID(orderA),
+				body:   form.String(),
+				header: map[string]string{"Content-Type": w.FormDataContentType()},
+			})
+			if status != tt.want {
+				t.Fatalf("got status %d (%s), want %d", status, body, tt.want)
+			}
+			if url := fake.rows("orders", fakeRow{"id": int64(orderA)})[0]["evidence_photo_url"]; tt.want != http.StatusOK && url != nil {
+				t.Fatalf("refused upload stored evidence %v", url)
+			}
+		})
+	}
+}
+
+func TestOrderDeletePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		actor  *testActor
+		status models.OrderStatus
+		want   int
+	}{
+		{"ordered", &adminActor, models.StatusOrdered, http.StatusOK},
+		{"in route", &adminActor, models.StatusInRoute, http.StatusForbidden},
+		{"delivered", &adminActor, models.StatusDelivered, http.StatusForbidden},
+		{"without orders.delete", &dispatcherActor, models.StatusOrdered, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := seedOrder(t, tt.status, false)
+
+			status, body := call(t, SoftDeleteOrder, tt.actor, testRequest{method: http.MethodDelete, params: withID(orderA)})
+			if status != tt.want {
+				t.Fatalf("got status %d (%s), want %d", status, body, tt.want)
+			}
+			deleted := fake.rows("orders", fakeRow{"id": int64(orderA)})[0]["is_deleted"]
+			if deleted != (tt.want == http.StatusOK) {
+				t.Fatalf("is_deleted = %v after status %d", deleted, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email,max=200"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required,max=128"`
+	NewPassword string `json:"new_password" validate:"required,max=72"`
+}
+
+type ForgotPasswordResponse struct {
+	Message string `json:"message"`
+}
+
+// passwordError turns a password policy violation into a validation error
+// on the given field and wraps any other error as an internal error
+func passwordError(field string, err error) error {
+	var policyErr *auth.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to hash password").Wrap(err)
+	}
+
+	fields := make([]apierror.FieldError, len(policyErr.Problems))
+	for i, problem := range policyErr.Problems {
+		fields[i] = apierror.FieldError{Field: field, Code: "password_policy", Message: problem}
+	}
+	return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "password does not meet the password policy").
+		WithFields(fields...)
+}
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the address belongs to an account.
+func ForgotPassword(c echo.Context) error {
+	var req ForgotPasswordRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	client := clientInfo(c)
+	throttleKeys := []string{auth.IPThrottleKey(client.IPAddress), auth.ResetThrottleKey(req.Email)}
+	if wait := auth.ResetThrottle.Wait(throttleKeys...); wait > 0 {
+		return retryAfter(c, wait, apierror.New(http.StatusTooManyRequests, apierror.CodeTooManyRequests, "too many password reset requests, try again later"))
+	}
+	// Every request counts, so reset emails can't be used to flood a mailbox
+	auth.ResetThrottle.Fail(throttleKeys...)
+
+	if err := auth.RequestPasswordReset(req.Email, client); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to request password reset").Wrap(err)
+	}
+
+	return c.JSON(http.StatusAccepted, ForgotPasswordResponse{
+		Message: "if the address belongs to an account, a password reset link has been sent to it",
+	})
+}
+
+// ResetPassword sets a new password with the token from a reset email.
+// The user logs in again afterwards, since every session is revoked.
+func ResetPassword(c echo.Context) error {
+	var req ResetPasswordRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	if _, err := auth.ResetPassword(req.Token, req.NewPassword, clientInfo(c)); err != nil {
+		if errors.Is(err, auth.ErrInvalidResetToken) {
+			return apierror.New(http.StatusBadRequest, apierror.CodeInvalidResetToken, "invalid or expired password reset token")
+		}
+		return passwordError("new_password", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+)
+
+const newPassword = "a brand new passphrase"
+
+// seedResetToken stores a password reset token of the clerk
+func seedResetToken(fake *fakeDB, id int64, raw string, expiresAt time.Time) {
+	fake.insert("password_reset_tokens", fakeRow{"id": id, "tenant_id": int64(tenantA), "user_id": int64(clerkID), "token_hash": auth.HashToken(raw), "expires_at": expiresAt})
+}
+
+// resetPassword redeems a reset token and returns the response status
+func resetPassword(t *testing.T, token string) int {
+	t.Helper()
+	status, _ := call(t, ResetPassword, nil, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"token":%q,"new_password":%q}`, token, newPassword),
+	})
+	return status
+}
+
+func TestResetPasswordUsesTokenOnce(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedClerk(t, fake)
+	fake.set("users", clerkID, fakeRow{"failed_login_count": int64(3), "locked_until": time.Now().Add(time.Hour)})
+	seedResetToken(fake, 1, "reset-token", time.Now().Add(time.Hour))
+	fake.insert("refresh_tokens", fakeRow{"id": int64(1), "user_id": int64(clerkID), "family_id": "family", "token_hash": "hash", "expires_at": time.Now().Add(time.Hour)})
+
+	if status := resetPassword(t, "reset-token"); status != http.StatusNoContent {
+		t.Fatalf("reset: got status %d, want 204", status)
+	}
+
+	clerk := fake.rows("users", fakeRow{"id": int64(clerkID)})[0]
+	if clerk["locked_until"] != nil || clerk["failed_login_count"] != int64(0) {
+		t.Fatalf("lockout not lifted: locked_until %v, failed_login_count %v", clerk["locked_until"], clerk["failed_login_count"])
+	}
+	if session := fake.rows("refresh_tokens", fakeRow{"id": int64(1)})[0]; session["revoked_at"] == nil {
+		t.Fatal("existing session was not revoked")
+	}
+	if status := login(t, "clerk", newPassword); status != http.StatusOK {
+		t.Fatalf("login with the new password: got status %d, want 200", status)
+	}
+
+	if status := resetPassword(t, "reset-token"); status != http.StatusBadRequest {
+		t.Fatalf("reused token: got status %d, want 400", status)
+	}
+}
+
+func TestResetPasswordRefusesExpiredToken(t *testing.T) {
+	fake := testDB(t)
+	seedClerk(t, fake)
+	seedResetToken(fake, 1, "reset-token", time.Now().Add(-time.Minute))
+
+	if status := resetPassword(t, "reset-token"); status != http.StatusBadRequest {
+		t.Fatalf("expired token: got status %d, want 400", status)
+	}
+	if status := resetPassword(t, "unknown-token"); status != http.StatusBadRequest {
+		t.Fatalf("unknown token: got status %d, want 400", status)
+	}
+}
+
+func TestChangePasswordChecksCurrentPassword(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedClerk(t, fake)
+
+	change := func(current string) int {
+		status, _ := call(t, ChangePassword, &clerkActor, testRequest{
+			method: http.MethodPost,
+			body:   fmt.Sprintf(`{"current_password":%q,"new_password":%q}`, current, newPassword),
+		})
+		return status
+	}
+	if status := change("wrong password"); status != http.StatusUnauthorized {
+		t.Fatalf("wrong current password: got status %d, want 401", status)
+	}
+	if status := change(clerkPassword); status != http.StatusOK {
+		t.Fatalf("correct current password: got status %d, want 200", status)
+	}
+	if status := login(t, "clerk", newPassword); status != http.StatusOK {
+		t.Fatalf("login with the new password: got status %d, want 200", status)
+	}
+}
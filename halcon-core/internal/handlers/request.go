@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/patch"
+	"gorm.io/gorm"
+)
+
+// bindAndValidate decodes the request into req and runs the validator
+// registered on the Echo instance against it
+func bindAndValidate(c echo.Context, req interface{}) error {
+	if err := c.Bind(req); err != nil {
+		return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "invalid request").Wrap(err)
+	}
+	return c.Validate(req)
+}
+
+// bindPatch decodes a JSON Merge Patch document, sent as
+// application/merge-patch+json or application/json, into req and runs the
+// validator against it. req uses patch.Field members so that members left
+// out of the document can be told from members sent as null.
+func bindPatch(c echo.Context, req interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType != patch.MIMEMergePatch && mediaType != echo.MIMEApplicationJSON {
+		return apierror.New(http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMedia, "send the changes as "+patch.MIMEMergePatch)
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(req); err != nil {
+		return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "invalid merge patch document").Wrap(err)
+	}
+	return c.Validate(req)
+}
+
+// notNull returns a validation error naming the merge patch members that
+// were sent as null although they can't be cleared
+func notNull(members map[string]bool) error {
+	var names []string
+	for name, null := range members {
+		if null {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	fields := make([]apierror.FieldError, len(names))
+	for i, name := range names {
+		fields[i] = apierror.FieldError{Field: name, Code: "not_null", Message: "can't be cleared"}
+	}
+	return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+		WithFields(fields...)
+}
+
+// fieldForbidden rejects a change to a request member that the caller's
+// roles don't allow
+func fieldForbidden(field, message string) error {
+	return apierror.New(http.StatusForbidden, apierror.CodeForbidden, message).
+		WithFields(apierror.FieldError{Field: field, Code: "forbidden", Message: message})
+}
+
+// HeaderTotalCount carries the number of matching items in paged list
+// responses
+const HeaderTotalCount = "X-Total-Count"
+
+// can reports whether the authenticated user's roles grant any of the
+// permissions
+func can(c echo.Context, permissions ...models.Permission) bool {
+	granted, _ := c.Get("permissions").(auth.PermissionSet)
+	return granted.Has(permissions...)
+}
+
+// currentRoles returns the roles of the authenticated user
+func currentRoles(c echo.Context) []models.UserRole {
+	roles, _ := c.Get("roles").([]models.UserRole)
+	return roles
+}
+
+// tenantDB returns the database limited to the tenant the request was
+// addressed to. Handlers use it for every query so they can't see the data
+// of another tenant.
+func tenantDB(c echo.Context) *gorm.DB {
+	return database.DB.WithContext(c.Request().Context())
+}
+
+// requestTenant returns the tenant the request was addressed to
+func requestTenant(c echo.Context) uint {
+	id, _ := c.Get("tenant_id").(uint)
+	return id
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+type CreateRoleRequest struct {
+	Name        models.UserRole     `json:"name" validate:"required,user_role"`
+	Description string              `json:"description" validate:"max=255"`
+	Permissions []models.Permission `json:"permissions" validate:"max=50,dive,permission"`
+}
+
+type UpdateRoleRequest struct {
+	Description string              `json:"description" validate:"max=255"`
+	Permissions []models.Permission `json:"permissions" validate:"max=50,dive,permission"`
+}
+
+type RoleResponse struct {
+	models.Role
+	Permissions []models.Permission `json:"permissions"`
+	UserCount   int64               `json:"user_count"`
+}
+
+// newRoleResponse builds the view of a role with its permissions loaded
+func newRoleResponse(role *models.Role, userCount int64) RoleResponse {
+	permissions := make([]models.Permission, len(role.Permissions))
+	for i, p := range role.Permissions {
+		permissions[i] = p.Permission
+	}
+	return RoleResponse{Role: *role, Permissions: permissions, UserCount: userCount}
+}
+
+// GetPermissions lists every permission a role can grant
+func GetPermissions(c echo.Context) error {
+	return c.JSON(http.StatusOK, models.PermissionDescriptions)
+}
+
+// GetRoles lists the roles with their permissions and how many users hold
+// each
+func GetRoles(c echo.Context) error {
+	var roles []models.Role
+	if err := tenantDB(c).Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch roles")
+	}
+
+	var counts []struct {
+		RoleID uint
+		Count  int64
+	}
+	err := tenantDB(c).Table("user_roles").
+		Select("user_roles.role_id, COUNT(*) AS count").
+		Joins("JOIN users ON users.id = user_roles.user_id AND users.deleted_at IS NULL").
+		Group("user_roles.role_id").
+		Scan(&counts).Error
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to count role members")
+	}
+	byRole := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		byRole[count.RoleID] = count.Count
+	}
+
+	response := make([]RoleResponse, len(roles))
+	for i := range roles {
+		response[i] = newRoleResponse(&roles[i], byRole[roles[i].ID])
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// CreateRole defines a custom role
+func CreateRole(c echo.Context) error {
+	var req CreateRoleRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	role, err := auth.CreateRole(requestTenant(c), req.Name, req.Description, req.Permissions)
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to create role")
+	}
+	return c.JSON(http.StatusCreated, newRoleResponse(role, 0))
+}
+
+// UpdateRole replaces the description and permissions of a role. Built-in
+// roles can be changed too, but no change may leave nobody able to manage
+// roles.
+func UpdateRole(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeNotFound, "role not found")
+	}
+
+	var req UpdateRoleRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	role, err := auth.UpdateRole(requestTenant(c), uint(id), req.Description, req.Permissions)
+	if err != nil {
+		return roleError(err, "failed to update role")
+	}
+
+	var count int64
+	tenantDB(c).Table("user_roles").Where("role_id = ?", role.ID).Count(&count)
+	return c.JSON(http.StatusOK, newRoleResponse(role, count))
+}
+
+// DeleteRole removes a custom role no user holds
+func DeleteRole(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeNotFound, "role not found")
+	}
+
+	if err := auth.DeleteRole(requestTenant(c), uint(id)); err != nil {
+		return roleError(err, "failed to delete role")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// roleError maps the errors of role changes to API errors
+func roleError(err error, message string) error {
+	switch {
+	case errors.Is(err, auth.ErrRoleNotFound):
+		return apierror.New(http.StatusNotFound, apierror.CodeNotFound, "role not found")
+	case errors.Is(err, auth.ErrSystemRole):
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "built-in roles can't be deleted")
+	case errors.Is(err, auth.ErrRoleInUse):
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "role is still assigned to users")
+	case errors.Is(err, auth.ErrLastRoleManager):
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "at least one active user must keep the roles.manage permission")
+	}
+	return apierror.FromDB(err, apierror.CodeInternal, message)
+}
+
+// rolesError turns an unknown role into a validation error on the field
+// and handles other errors like roleError
+func rolesError(field string, err error) error {
+	var unknown *auth.UnknownRoleError
+	if errors.As(err, &unknown) {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+			WithFields(apierror.FieldError{Field: field, Code: "unknown_role", Message: unknown.Error()})
+	}
+	return roleError(err, "failed to update roles")
+}
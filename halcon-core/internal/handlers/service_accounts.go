@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+type CreateServiceAccountRequest struct {
+	Username   string          `json:"username" validate:"required,min=3,max=50"`
+	Role       models.UserRole `json:"role" validate:"required,user_role"`
+	Department string          `json:"department" validate:"max=100"`
+	FullName   string          `json:"full_name" validate:"max=200"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" validate:"required,max=100"`
+	Scopes     []string   `json:"scopes" validate:"required,min=1,dive,api_key_scope"`
+	AllowedIPs []string   `json:"allowed_ips" validate:"max=20,dive,max=64"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	UserID     uint       `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip"`
+	UsageCount int64      `json:"usage_count"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy  *uint      `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	// Key is the full API key, shown only in this response
+	Key string `json:"key"`
+}
+
+// newAPIKeyResponse builds the public view of an API key
+func newAPIKeyResponse(key *models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		UserID:     key.UserID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     splitList(key.Scopes),
+		AllowedIPs: splitList(key.AllowedIPs),
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		LastUsedIP: key.LastUsedIP,
+		UsageCount: key.UsageCount,
+		RevokedAt:  key.RevokedAt,
+		CreatedBy:  key.CreatedBy,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+// splitList splits a comma-separated column, returning an empty slice
+// rather than [""] for an empty value
+func splitList(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+// GetServiceAccounts lists the service accounts (service_accounts.manage)
+func GetServiceAccounts(c echo.Context) error {
+	var accounts []models.User
+	if err := tenantDB(c).Preload("Roles").Where("is_service_account = ?", true).Order("username").Find(&accounts).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch service accounts")
+	}
+
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// CreateServiceAccount creates a user for machine-to-machine integrations.
+// Service accounts can't log in and authenticate with API keys only.
+// They are disabled or deleted like other users. The caller must hold every
+// permission the account's role grants. (service_accounts.manage)
+func CreateServiceAccount(c echo.Context) error {
+	var req CreateServiceAccountRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+	roles, err := auth.FindRoles(tenantDB(c).Preload("Permissions"), []models.UserRole{req.Role})
+	if err != nil {
+		return rolesError("role", err)
+	}
+	if err := checkGrantableRoles(c, req.Role, roles); err != nil {
+		return err
+	}
+
+	account := models.User{
+		Username:         req.Username,
+		PasswordHash:     auth.UnusablePasswordHash,
+		Role:             req.Role,
+		Department:       req.Department,
+		FullName:         req.FullName,
+		IsActive:         true,
+		IsServiceAccount: true,
+	}
+	if err := tenantDB(c).Create(&account).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to create service account")
+	}
+
+	return c.JSON(http.StatusCreated, account)
+}
+
+// GetAPIKeys lists the API keys of a service account, including revoked
+// and expired ones (service_accounts.manage)
+func GetAPIKeys(c echo.Context) error {
+	account, err := findServiceAccount(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	var keys []models.APIKey
+	if err := tenantDB(c).Where("user_id = ?", account.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch API keys")
+	}
+
+	response := make([]APIKeyResponse, len(keys))
+	for i := range keys {
+		response[i] = newAPIKeyResponse(&keys[i])
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// CreateAPIKey issues an API key for a service account. The key is returned
+// once and only its hash is stored. (service_accounts.manage)
+func CreateAPIKey(c echo.Context) error {
+	actorID := c.Get("user_id").(uint)
+
+	account, err := findServiceAccount(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	var req CreateAPIKeyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+			WithFields(apierror.FieldError{Field: "expires_at", Code: "future", Message: "must be in the future"})
+	}
+	if _, err := auth.NormalizeIPAllowlist(req.AllowedIPs); err != nil {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+			WithFields(apierror.FieldError{Field: "allowed_ips", Code: "ip_or_cidr", Message: err.Error()})
+	}
+
+	key, raw, err := auth.CreateAPIKey(account, req.Name, req.Scopes, req.AllowedIPs, req.ExpiresAt, &actorID)
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to create API key").Wrap(err)
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventAPIKeyCreated, UserID: &account.ID, Username: account.Username, ActorID: &actorID, Detail: "key " + key.Prefix}, clientInfo(c))
+
+	return c.JSON(http.StatusCreated, CreateAPIKeyResponse{APIKeyResponse: newAPIKeyResponse(key), Key: raw})
+}
+
+// RevokeAPIKey stops an API key from being accepted (service_accounts.manage)
+func RevokeAPIKey(c echo.Context) error {
+	actorID := c.Get("user_id").(uint)
+
+	account, err := findServiceAccount(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	var key models.APIKey
+	if err := tenantDB(c).Where("id = ? AND user_id = ?", c.Param("key_id"), account.ID).First(&key).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeNotFound, "API key not found")
+	}
+
+	if err := auth.RevokeAPIKey(&key); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to revoke API key").Wrap(err)
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventAPIKeyRevoked, UserID: &account.ID, Username: account.Username, ActorID: &actorID, Detail: "key " + key.Prefix}, clientInfo(c))
+
+	return c.JSON(http.StatusOK, newAPIKeyResponse(&key))
+}
+
+// findServiceAccount loads a service account of the request's tenant by ID
+func findServiceAccount(c echo.Context, id string) (*models.User, error) {
+	var account models.User
+	if err := tenantDB(c).Where("id = ? AND is_service_account = ?", id, true).First(&account).Error; err != nil {
+		return nil, apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "service account not found")
+	}
+	return &account, nil
+}
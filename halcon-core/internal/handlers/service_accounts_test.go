@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/middleware"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+const (
+	serviceAccountID = 40
+	integrationRole  = 41
+	apiKey           = "hk_0123456789abcdef0123456789abcdef"
+	allowedIP        = "198.51.100.20"
+)
+
+// seedServiceAccount stores a service account whose role grants every
+// permission, and its API key with the orders:read scope that is only
+// accepted from 198.51.100.0/24
+func seedServiceAccount(fake *fakeDB, key fakeRow) {
+	fake.insert("users", fakeRow{"id": int64(serviceAccountID), "tenant_id": int64(tenantA), "username": "erp", "role": "Integration", "is_active": true, "is_service_account": true})
+	fake.insert("roles", fakeRow{"id": int64(integrationRole), "tenant_id": int64(tenantA), "name": "Integration", "is_system": false})
+	fake.insert("user_roles", fakeRow{"user_id": int64(serviceAccountID), "role_id": int64(integrationRole)})
+	for _, p := range allPermissions() {
+		fake.insert("role_permissions", fakeRow{"role_id": int64(integrationRole), "permission": string(p)})
+	}
+
+	row := fakeRow{"id": int64(50), "user_id": int64(serviceAccountID), "name": "ERP", "prefix": apiKey[:11], "key_hash": auth.HashToken(apiKey), "scopes": "orders:read", "allowed_ips": "198.51.100.0/24", "usage_count": int64(0)}
+	for column, value := range key {
+		row[column] = value
+	}
+	fake.insert("api_keys", row)
+}
+
+// serveWithAPIKey sends a request with the API key through AuthMiddleware
+// and returns the response status
+func serveWithAPIKey(t *testing.T, method, path, ip string) int {
+	t.Helper()
+	return serveAPI(t, method, path, map[string]string{middleware.HeaderAPIKey: apiKey}, ip)
+}
+
+func TestAPIKeyIsLimitedToItsScopes(t *testing.T) {
+	fake := testDB(t)
+	seedServiceAccount(fake, nil)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/orders", http.StatusNoContent},
+		{http.MethodGet, "/api/auth/me", http.StatusNoContent},
+		{http.MethodPost, "/api/orders", http.StatusForbidden},
+		{http.MethodGet, "/api/roles", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status := serveWithAPIKey(t, tt.method, tt.path, allowedIP); status != tt.want {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, status, tt.want)
+		}
+	}
+	if key := fake.rows("api_keys", fakeRow{"id": int64(50)})[0]; key["usage_count"] != int64(len(tests)) {
+		t.Errorf("usage_count = %v, want %d", key["usage_count"], len(tests))
+	}
+}
+
+func TestAPIKeyIsLimitedToItsAllowedIPs(t *testing.T) {
+	fake := testDB(t)
+	seedServiceAccount(fake, nil)
+
+	if status := serveWithAPIKey(t, http.MethodGet, "/api/orders", "203.0.113.9"); status != http.StatusForbidden {
+		t.Fatalf("address outside the allowlist: got status %d, want 403", status)
+	}
+	rejected := fake.rows("auth_events", fakeRow{"event_type": string(models.EventAPIKeyRejected)})
+	if len(rejected) != 1 {
+		t.Fatalf("got %d api key rejection events, want 1", len(rejected))
+	}
+	if key := fake.rows("api_keys", fakeRow{"id": int64(50)})[0]; key["usage_count"] != int64(0) {
+		t.Fatalf("refused request counted as a use of the key: usage_count = %v", key["usage_count"])
+	}
+}
+
+func TestRevokedOrExpiredAPIKeyIsRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		key  fakeRow
+	}{
+		{"revoked", fakeRow{"revoked_at": time.Now().Add(-time.Minute)}},
+		{"expired", fakeRow{"expires_at": time.Now().Add(-time.Minute)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testDB(t)
+			seedServiceAccount(fake, tt.key)
+
+			if status := serveWithAPIKey(t, http.MethodGet, "/api/orders", allowedIP); status != http.StatusUnauthorized {
+				t.Fatalf("got status %d, want 401", status)
+			}
+		})
+	}
+}
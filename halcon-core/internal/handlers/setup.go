@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/setup"
+)
+
+type SetupRequest struct {
+	SetupToken string `json:"setup_token" validate:"required"`
+	Username   string `json:"username" validate:"required,min=3,max=50"`
+	Password   string `json:"password" validate:"required,max=72"`
+	FullName   string `json:"full_name" validate:"max=200"`
+	Email      string `json:"email" validate:"omitempty,email,max=200"`
+}
+
+type SetupStatusResponse struct {
+	SetupRequired bool `json:"setup_required"`
+}
+
+// GetSetupStatus reports whether first-run setup is still pending
+func GetSetupStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, SetupStatusResponse{SetupRequired: setup.Required()})
+}
+
+// CompleteSetup creates the first admin user using the one-time setup token
+// and logs them in
+func CompleteSetup(c echo.Context) error {
+	var req SetupRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	admin := models.User{
+		Username:   req.Username,
+		Department: "Administration",
+		FullName:   req.FullName,
+		Email:      req.Email,
+	}
+
+	if err := setup.Complete(req.SetupToken, &admin, req.Password); err != nil {
+		switch {
+		case errors.Is(err, setup.ErrAlreadyCompleted):
+			return apierror.New(http.StatusConflict, apierror.CodeSetupCompleted, "setup has already been completed")
+		case errors.Is(err, setup.ErrInvalidToken):
+			return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidSetupToken, "invalid setup token")
+		case errors.As(err, new(*auth.PasswordPolicyError)):
+			return passwordError("password", err)
+		}
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to complete setup")
+	}
+
+	return respondWithSession(c, http.StatusCreated, &admin)
+}
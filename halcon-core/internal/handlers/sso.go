@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/oidc"
+)
+
+// ssoStateCookie binds a single sign-on login to the browser that started it
+const ssoStateCookie = "halcon_sso_state"
+
+// ssoStateCookiePath limits the state cookie to the callback
+const ssoStateCookiePath = "/api/auth/oidc"
+
+type SSOConfigResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+type SSOExchangeRequest struct {
+	Code string `json:"code" validate:"required,max=128"`
+}
+
+// GetSSOConfig tells the login page whether single sign-on is available
+func GetSSOConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, SSOConfigResponse{Enabled: oidc.Default != nil})
+}
+
+// SSOLogin redirects the browser to the identity provider. The optional
+// redirect query parameter is the client path to open after signing in.
+func SSOLogin(c echo.Context) error {
+	if oidc.Default == nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeSSONotConfigured, "single sign-on is not configured")
+	}
+
+	authURL, state, err := auth.BeginSSOLogin(safeRedirectPath(c.QueryParam("redirect")), clientInfo(c).TenantID)
+	if err != nil {
+		return apierror.New(http.StatusBadGateway, apierror.CodeInternal, "failed to reach the identity provider").Wrap(err)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     ssoStateCookiePath,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.AppConfig.OIDCRedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// SSOCallback handles the redirect back from the identity provider. It
+// signs the user in and sends the browser to the web client with a
+// one-time code, which the client exchanges with SSOExchange. Failures
+// redirect to the login page with an sso_error parameter.
+func SSOCallback(c echo.Context) error {
+	client := clientInfo(c)
+	clearSSOStateCookie(c)
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, Detail: "sso: provider returned " + providerErr}, client)
+		return redirectToClient(c, "/login", url.Values{"sso_error": {"denied"}})
+	}
+
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(ssoStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, Detail: "sso: state mismatch"}, client)
+		return redirectToClient(c, "/login", url.Values{"sso_error": {"state"}})
+	}
+
+	user, redirectPath, err := auth.CompleteSSOLogin(state, c.QueryParam("code"), client)
+	if err != nil {
+		reason := ssoFailureReason(err)
+		if reason == "failed" {
+			log.Printf("Single sign-on failed: %v", err)
+		}
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginFailed, Detail: "sso: " + err.Error()}, client)
+		return redirectToClient(c, "/login", url.Values{"sso_error": {reason}})
+	}
+
+	code, err := auth.IssueSSOHandoff(user.ID)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		return redirectToClient(c, "/login", url.Values{"sso_error": {"failed"}})
+	}
+
+	// The code goes in the fragment so it isn't sent to servers or logged
+	fragment := url.Values{"code": {code}}
+	if redirectPath != "" {
+		fragment.Set("redirect", redirectPath)
+	}
+	return c.Redirect(http.StatusFound, clientURL("/sso/callback")+"#"+fragment.Encode())
+}
+
+// SSOExchange trades the one-time code from SSOCallback for a session.
+// Users with 2FA get the same challenge as after a password login.
+func SSOExchange(c echo.Context) error {
+	var req SSOExchangeRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	user, err := auth.RedeemSSOHandoff(req.Code, clientInfo(c))
+	if errors.Is(err, auth.ErrInvalidSSOHandoff) {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidSSOCode, "invalid or expired single sign-on code")
+	}
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to complete single sign-on").Wrap(err)
+	}
+
+	if user.TOTPEnabled {
+		return twoFactorChallenge(c, user)
+	}
+
+	completeLogin(user, clientInfo(c), "sso")
+	return respondWithSession(c, http.StatusOK, user)
+}
+
+// ssoFailureReason maps a single sign-on error to the reason shown by the
+// login page
+func ssoFailureReason(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrInvalidSSOState):
+		return "state"
+	case errors.Is(err, auth.ErrSSONoRole), errors.Is(err, auth.ErrSSOUnknownUser):
+		return "not_allowed"
+	case errors.Is(err, auth.ErrSSOUserInactive):
+		return "inactive"
+	default:
+		return "failed"
+	}
+}
+
+// safeRedirectPath keeps redirect targets inside the web client, dropping
+// absolute and protocol-relative URLs
+func safeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, "\\") || len(path) > 255 {
+		return ""
+	}
+	return path
+}
+
+// clientURL returns the absolute web client URL of a path
+func clientURL(path string) string {
+	return strings.TrimRight(config.AppConfig.PublicURL, "/") + path
+}
+
+// redirectToClient sends the browser to a web client page
+func redirectToClient(c echo.Context, path string, query url.Values) error {
+	return c.Redirect(http.StatusFound, clientURL(path)+"?"+query.Encode())
+}
+
+func clearSSOStateCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     ssoStateCookie,
+		Path:     ssoStateCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.AppConfig.OIDCRedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
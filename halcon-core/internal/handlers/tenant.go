@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+type UpdateTenantRequest struct {
+	Name                    string `json:"name" validate:"required,max=100"`
+	LogoURL                 string `json:"logo_url" validate:"omitempty,url,max=500"`
+	AllowDirectDispatch     bool   `json:"allow_direct_dispatch"`
+	RequireDeliveryEvidence bool   `json:"require_delivery_evidence"`
+	DeliverySLAHours        int    `json:"delivery_sla_hours" validate:"min=0,max=8760"`
+}
+
+// GetTenant returns the name, logo and workflow settings of the tenant the
+// request was addressed to. It is public so the login page can show the
+// company's branding.
+func GetTenant(c echo.Context) error {
+	tenant, err := tenantSettings(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tenant)
+}
+
+// UpdateTenant changes the name, logo and workflow settings of the
+// request's tenant (tenant.manage)
+func UpdateTenant(c echo.Context) error {
+	var req UpdateTenantRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	var tenant models.Tenant
+	if err := tenantDB(c).Where("id = ?", requestTenant(c)).First(&tenant).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeTenantNotFound, "tenant not found")
+	}
+
+	err := tenantDB(c).Model(&tenant).Updates(map[string]interface{}{
+		"name":                      req.Name,
+		"logo_url":                  req.LogoURL,
+		"allow_direct_dispatch":     req.AllowDirectDispatch,
+		"require_delivery_evidence": req.RequireDeliveryEvidence,
+		"delivery_sla_hours":        req.DeliverySLAHours,
+	}).Error
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to update tenant")
+	}
+
+	auth.InvalidateTenants()
+	return c.JSON(http.StatusOK, tenant)
+}
+
+// tenantSettings returns the tenant the request was addressed to
+func tenantSettings(c echo.Context) (*models.Tenant, error) {
+	tenant, err := auth.TenantByID(requestTenant(c))
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load tenant").Wrap(err)
+	}
+	return tenant, nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+const (
+	tenantA uint = 1
+	tenantB uint = 2
+
+	// The admin of tenant A makes every request; the other records exist
+	// once per tenant
+	adminA = 1
+	orderA = 10
+	orderB = 20
+	userA  = 11
+	userB  = 21
+	roleA  = 12
+	roleB  = 22
+)
+
+// isolationDB stores an admin and an order, a user and a custom role in
+// each of two tenants
+func isolationDB(t *testing.T) *fakeDB {
+	t.Helper()
+
+	fake := testDB(t)
+	fake.insert("users", fakeRow{"id": int64(adminA), "tenant_id": int64(tenantA), "username": "admin", "role": "Admin", "is_active": true})
+	for _, tc := range []struct {
+		tenant            uint
+		order, user, role int64
+	}{{tenantA, orderA, userA, roleA}, {tenantB, orderB, userB, roleB}} {
+		fake.insert("orders", fakeRow{"id": tc.order, "tenant_id": int64(tc.tenant), "invoice_number": "INV-1", "customer_name": "Customer", "status": string(models.StatusOrdered), "delivery_address": "Main St 1", "is_deleted": false, "created_by": int64(adminA), "last_modified_by": int64(adminA)})
+		fake.insert("users", fakeRow{"id": tc.user, "tenant_id": int64(tc.tenant), "username": "clerk", "role": "Sales", "is_active": true})
+		fake.insert("roles", fakeRow{"id": tc.role, "tenant_id": int64(tc.tenant), "name": "Dispatcher", "is_system": false})
+	}
+	return fake
+}
+
+// callAsAdminA runs a handler as tenant A's admin, who holds every
+// permission, and returns the response status and body
+func callAsAdminA(t *testing.T, handler echo.HandlerFunc, method string, id int64, body string) (int, string) {
+	t.Helper()
+	r := testRequest{method: method, body: body}
+	if id != 0 {
+		r.params = withID(id)
+	}
+	return call(t, handler, &adminActor, r)
+}
+
+func TestTenantCannotReachAnotherTenantsRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler echo.HandlerFunc
+		method  string
+		own     int64
+		other   int64
+		body    string
+	}{
+		{"get order", GetOrder, http.MethodGet, orderA, orderB, ""},
+		{"update order", UpdateOrder, http.MethodPatch, orderA, orderB, `{"notes":"changed"}`},
+		{"delete order", SoftDeleteOrder, http.MethodDelete, orderA, orderB, ""},
+		{"get user", GetUser, http.MethodGet, userA, userB, ""},
+		{"update user", UpdateUser, http.MethodPatch, userA, userB, `{"full_name":"Changed"}`},
+		{"delete user", DeleteUser, http.MethodDelete, userA, userB, ""},
+		{"update role", UpdateRole, http.MethodPut, roleA, roleB, `{"description":"changed","permissions":["orders.read"]}`},
+		{"delete role", DeleteRole, http.MethodDelete, roleA, roleB, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := isolationDB(t)
+
+			// Tenant A's own record is found, so a 404 below comes from the
+			// tenant filter rather than from the fake database
+			if status, body := callAsAdminA(t, tt.handler, tt.method, tt.own, tt.body); status == http.StatusNotFound {
+				t.Fatalf("own record: got 404 (%s)", body)
+			}
+
+			if status, body := callAsAdminA(t, tt.handler, tt.method, tt.other, tt.body); status != http.StatusNotFound {
+				t.Fatalf("other tenant's record: got status %d (%s), want 404", status, body)
+			}
+			for _, m := range fake.mutations() {
+				if m.Row["tenant_id"] == int64(tenantB) {
+					t.Fatalf("statement changed a row of tenant B: %s", m.SQL)
+				}
+			}
+		})
+	}
+}
+
+func TestTenantListsOnlyItsOwnRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler echo.HandlerFunc
+		own     int64
+		other   int64
+	}{
+		{"orders", GetOrders, orderA, orderB},
+		{"users", GetUsers, userA, userB},
+		{"roles", GetRoles, roleA, roleB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolationDB(t)
+
+			status, body := callAsAdminA(t, tt.handler, http.MethodGet, 0, "")
+			if status != http.StatusOK {
+				t.Fatalf("got status %d (%s)", status, body)
+			}
+			if !strings.Contains(body, fmt.Sprintf(`"id":%d`, tt.own)) {
+				t.Fatalf("tenant A's record is missing: %s", body)
+			}
+			if strings.Contains(body, fmt.Sprintf(`"id":%d`, tt.other)) {
+				t.Fatalf("tenant B's record was listed: %s", body)
+			}
+		})
+	}
+}
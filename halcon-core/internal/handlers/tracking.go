@@ -4,13 +4,12 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
-	"github.com/nietzshn/halcon-core/internal/database"
 	"github.com/nietzshn/halcon-core/internal/models"
 )
 
 type TrackingRequest struct {
-	CustomerNumber string `json:"customer_number" query:"customer_number" validate:"required"`
-	InvoiceNumber  string `json:"invoice_number" query:"invoice_number" validate:"required"`
+	CustomerNumber string `json:"customer_number" query:"customer_number" validate:"required,max=100"`
+	InvoiceNumber  string `json:"invoice_number" query:"invoice_number" validate:"required,max=50"`
 }
 
 type TrackingResponse struct {
@@ -27,16 +26,12 @@ type TrackingResponse struct {
 // TrackOrder allows public tracking of orders without authentication
 func TrackOrder(c echo.Context) error {
 	var req TrackingRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
-	}
-
-	if req.CustomerNumber == "" || req.InvoiceNumber == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "customer_number and invoice_number are required")
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
 	}
 
 	var order models.Order
-	err := database.DB.Where(
+	err := tenantDB(c).Where(
 		"customer_number = ? AND invoice_number = ? AND is_deleted = ?",
 		req.CustomerNumber,
 		req.InvoiceNumber,
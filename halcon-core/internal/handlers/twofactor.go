@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+type TwoFactorVerifyRequest struct {
+	TwoFactorToken string `json:"two_factor_token" validate:"required,max=2048"`
+	Code           string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode   string `json:"recovery_code" validate:"omitempty,max=20"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password     string `json:"password" validate:"required,max=72"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"omitempty,max=20"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled                bool  `json:"enabled"`
+	Required               bool  `json:"required"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// VerifyTwoFactorLogin completes a login with a TOTP or recovery code and
+// the intermediate token returned by Login. The token is single-use.
+func VerifyTwoFactorLogin(c echo.Context) error {
+	var req TwoFactorVerifyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	claims, err := utils.ValidateToken(req.TwoFactorToken)
+	if err != nil || claims.Purpose != utils.PurposeTwoFactor {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "invalid or expired two-factor token")
+	}
+	if revoked, err := auth.IsRevoked(claims.ID); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to verify token").Wrap(err)
+	} else if revoked {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "invalid or expired two-factor token")
+	}
+
+	client := clientInfo(c)
+	throttleKeys := []string{auth.IPThrottleKey(client.IPAddress), auth.UserThrottleKey(claims.TenantID, claims.Username)}
+	if wait := auth.LoginThrottle.Wait(throttleKeys...); wait > 0 {
+		auth.RecordEvent(models.AuthEvent{Type: models.EventLoginThrottled, UserID: &claims.UserID, Username: claims.Username, Detail: "two-factor"}, client)
+		return retryAfter(c, wait, apierror.New(http.StatusTooManyRequests, apierror.CodeTooManyRequests, "too many failed login attempts, try again later"))
+	}
+
+	var user models.User
+	if err := tenantDB(c).Where("id = ? AND is_active = ?", claims.UserID, true).First(&user).Error; err != nil {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "invalid or expired two-factor token")
+	}
+	if auth.IsLocked(&user) {
+		return apierror.New(http.StatusLocked, apierror.CodeAccountLocked, "account is temporarily locked after too many failed login attempts")
+	}
+
+	usedRecovery, err := auth.VerifySecondFactor(&user, req.Code, req.RecoveryCode)
+	if errors.Is(err, auth.ErrInvalidTwoFactorCode) || errors.Is(err, auth.ErrTwoFactorNotEnabled) {
+		auth.LoginThrottle.Fail(throttleKeys...)
+		auth.RecordEvent(models.AuthEvent{Type: models.EventTwoFactorFailed, UserID: &user.ID, Username: user.Username}, client)
+
+		locked, lockErr := auth.RegisterLoginFailure(&user)
+		if lockErr != nil {
+			return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to record login attempt").Wrap(lockErr)
+		}
+		if locked {
+			auth.RecordEvent(models.AuthEvent{Type: models.EventAccountLocked, UserID: &user.ID, Username: user.Username}, client)
+		}
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidTwoFactor, "invalid two-factor code")
+	}
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to verify two-factor code").Wrap(err)
+	}
+
+	if err := auth.RevokeAccessToken(user.ID, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to complete login").Wrap(err)
+	}
+	if err := auth.ResetLoginFailures(&user); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to record login attempt").Wrap(err)
+	}
+
+	detail := "totp"
+	if usedRecovery {
+		detail = "recovery code"
+		auth.RecordEvent(models.AuthEvent{Type: models.EventRecoveryCodeUsed, UserID: &user.ID, Username: user.Username}, client)
+	}
+	completeLogin(&user, client, detail)
+
+	return respondWithSession(c, http.StatusOK, &user)
+}
+
+// GetTwoFactorStatus reports whether the current user has 2FA enabled and
+// whether their role requires it
+func GetTwoFactorStatus(c echo.Context) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	response := TwoFactorStatusResponse{Enabled: user.TOTPEnabled, Required: auth.TwoFactorRequired(currentRoles(c)...)}
+	if user.TOTPEnabled {
+		if response.RecoveryCodesRemaining, err = auth.RemainingRecoveryCodes(user.ID); err != nil {
+			return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to count recovery codes").Wrap(err)
+		}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// SetupTwoFactor starts TOTP enrollment and returns the secret and the
+// otpauth:// URI to show as a QR code. Enrollment completes with
+// EnableTwoFactor.
+func SetupTwoFactor(c echo.Context) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	secret, uri, err := auth.BeginTwoFactorEnrollment(user)
+	if errors.Is(err, auth.ErrTwoFactorEnabled) {
+		return apierror.New(http.StatusConflict, apierror.CodeTwoFactorState, "two-factor authentication is already enabled")
+	}
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to start two-factor enrollment").Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, TwoFactorSetupResponse{Secret: secret, ProvisioningURI: uri})
+}
+
+// EnableTwoFactor confirms enrollment with a code from the authenticator
+// and returns the recovery codes, which are shown only once
+func EnableTwoFactor(c echo.Context) error {
+	var req TwoFactorCodeRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	codes, err := auth.EnableTwoFactor(user, req.Code)
+	switch {
+	case errors.Is(err, auth.ErrTwoFactorEnabled):
+		return apierror.New(http.StatusConflict, apierror.CodeTwoFactorState, "two-factor authentication is already enabled")
+	case errors.Is(err, auth.ErrTwoFactorNotPending):
+		return apierror.New(http.StatusConflict, apierror.CodeTwoFactorState, "start two-factor enrollment first")
+	case errors.Is(err, auth.ErrInvalidTwoFactorCode):
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeInvalidTwoFactor, "invalid two-factor code")
+	case err != nil:
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to enable two-factor authentication").Wrap(err)
+	}
+
+	auth.RecordEvent(models.AuthEvent{Type: models.EventTwoFactorEnabled, UserID: &user.ID, Username: user.Username}, clientInfo(c))
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns 2FA off after re-checking the password and a
+// second factor. Roles that require 2FA can't disable it.
+func DisableTwoFactor(c echo.Context) error {
+	var req TwoFactorDisableRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if auth.TwoFactorRequired(currentRoles(c)...) {
+		return apierror.New(http.StatusForbidden, apierror.CodeForbidden, "two-factor authentication is required for your role")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "password is incorrect")
+	}
+	if err := verifyCurrentSecondFactor(user, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+
+	if err := auth.DisableTwoFactor(user); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to disable two-factor authentication").Wrap(err)
+	}
+
+	auth.RecordEvent(models.AuthEvent{Type: models.EventTwoFactorDisabled, UserID: &user.ID, Username: user.Username}, clientInfo(c))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes after
+// checking a TOTP code
+func RegenerateRecoveryCodes(c echo.Context) error {
+	var req TwoFactorCodeRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if err := verifyCurrentSecondFactor(user, req.Code, ""); err != nil {
+		return err
+	}
+
+	codes, err := auth.RegenerateRecoveryCodes(user)
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to generate recovery codes").Wrap(err)
+	}
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// ResetUserTwoFactor removes a user's 2FA, e.g. after a lost device
+// (users.manage). Roles that require 2FA must enroll again. Users with
+// permissions the caller lacks can't be reset.
+func ResetUserTwoFactor(c echo.Context) error {
+	id := c.Param("id")
+	actorID := c.Get("user_id").(uint)
+
+	query, err := branchUsers(c, tenantDB(c))
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err := query.First(&user, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
+	}
+	if err := checkNotOutranked(c, &user, ""); err != nil {
+		return err
+	}
+
+	if err := auth.DisableTwoFactor(&user); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to reset two-factor authentication").Wrap(err)
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventTwoFactorDisabled, UserID: &user.ID, Username: user.Username, ActorID: &actorID, Detail: "reset by admin"}, clientInfo(c))
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// currentUser loads the authenticated user
+func currentUser(c echo.Context) (*models.User, error) {
+	userID := c.Get("user_id").(uint)
+
+	var user models.User
+	if err := tenantDB(c).First(&user, userID).Error; err != nil {
+		return nil, apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
+	}
+	return &user, nil
+}
+
+// verifyCurrentSecondFactor re-checks a second factor for a sensitive change
+func verifyCurrentSecondFactor(user *models.User, code, recoveryCode string) error {
+	_, err := auth.VerifySecondFactor(user, code, recoveryCode)
+	switch {
+	case errors.Is(err, auth.ErrTwoFactorNotEnabled):
+		return apierror.New(http.StatusConflict, apierror.CodeTwoFactorState, "two-factor authentication is not enabled")
+	case errors.Is(err, auth.ErrInvalidTwoFactorCode):
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeInvalidTwoFactor, "invalid two-factor code")
+	case err != nil:
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to verify two-factor code").Wrap(err)
+	}
+	return nil
+}
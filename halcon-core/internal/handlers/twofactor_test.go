@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+)
+
+const (
+	totpSecret   = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	recoveryCode = "abcde-fghij"
+)
+
+// seedTwoFactorClerk stores the clerk with 2FA enabled and one unused
+// recovery code
+func seedTwoFactorClerk(t *testing.T, fake *fakeDB) {
+	t.Helper()
+	seedClerk(t, fake)
+	fake.set("users", clerkID, fakeRow{"totp_enabled": true, "totp_secret": totpSecret, "totp_last_step": int64(0)})
+	fake.insert("recovery_codes", fakeRow{"id": int64(1), "tenant_id": int64(tenantA), "user_id": int64(clerkID), "code_hash": auth.HashToken("abcdefghij")})
+}
+
+// totpNow computes the current code of the secret like an authenticator app
+func totpNow(t *testing.T) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(totpSecret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// verifySecondFactor signs the clerk in with their password and completes
+// the login with the given second factor, returning the response status
+func verifySecondFactor(t *testing.T, factor string) int {
+	t.Helper()
+	auth.LoginThrottle.Reset(auth.IPThrottleKey(clientIP), auth.UserThrottleKey(tenantA, "clerk"))
+
+	status, body := call(t, Login, nil, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"username":"clerk","password":%q}`, clerkPassword),
+		ip:     clientIP,
+	})
+	var challenge TwoFactorChallengeResponse
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &challenge) != nil || !challenge.TwoFactorRequired {
+		t.Fatalf("login: got status %d (%s), want a two-factor challenge", status, body)
+	}
+
+	status, _ = call(t, VerifyTwoFactorLogin, nil, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"two_factor_token":%q,%s}`, challenge.TwoFactorToken, factor),
+		ip:     clientIP,
+	})
+	return status
+}
+
+func TestTOTPCodeIsAcceptedOnce(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedTwoFactorClerk(t, fake)
+
+	code := totpNow(t)
+	if status := verifySecondFactor(t, fmt.Sprintf(`"code":%q`, code)); status != http.StatusOK {
+		t.Fatalf("first use of the code: got status %d, want 200", status)
+	}
+	if status := verifySecondFactor(t, fmt.Sprintf(`"code":%q`, code)); status != http.StatusUnauthorized {
+		t.Fatalf("replayed code: got status %d, want 401", status)
+	}
+}
+
+func TestRecoveryCodeIsAcceptedOnce(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedTwoFactorClerk(t, fake)
+
+	if status := verifySecondFactor(t, fmt.Sprintf(`"recovery_code":%q`, recoveryCode)); status != http.StatusOK {
+		t.Fatalf("first use of the recovery code: got status %d, want 200", status)
+	}
+	if status := verifySecondFactor(t, fmt.Sprintf(`"recovery_code":%q`, recoveryCode)); status != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code: got status %d, want 401", status)
+	}
+}
+
+func TestTwoFactorTokenIsSingleUse(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedTwoFactorClerk(t, fake)
+
+	status, body := call(t, Login, nil, testRequest{
+		method: http.MethodPost,
+		body:   fmt.Sprintf(`{"username":"clerk","password":%q}`, clerkPassword),
+		ip:     clientIP,
+	})
+	var challenge TwoFactorChallengeResponse
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &challenge) != nil {
+		t.Fatalf("login: got status %d (%s)", status, body)
+	}
+
+	verify := fmt.Sprintf(`{"two_factor_token":%q,"recovery_code":%q}`, challenge.TwoFactorToken, recoveryCode)
+	if status, body := call(t, VerifyTwoFactorLogin, nil, testRequest{method: http.MethodPost, body: verify, ip: clientIP}); status != http.StatusOK {
+		t.Fatalf("first use of the token: got status %d (%s)", status, body)
+	}
+
+	fake.insert("recovery_codes", fakeRow{"id": int64(2), "tenant_id": int64(tenantA), "user_id": int64(clerkID), "code_hash": auth.HashToken("klmnopqrst")})
+	verify = fmt.Sprintf(`{"two_factor_token":%q,"recovery_code":"klmno-pqrst"}`, challenge.TwoFactorToken)
+	if status, _ := call(t, VerifyTwoFactorLogin, nil, testRequest{method: http.MethodPost, body: verify, ip: clientIP}); status != http.StatusUnauthorized {
+		t.Fatalf("reused two-factor token: got status %d, want 401", status)
+	}
+}
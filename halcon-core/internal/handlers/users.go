@@ -1,39 +1,130 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
-	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
 	"github.com/nietzshn/halcon-core/internal/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/nietzshn/halcon-core/internal/patch"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"gorm.io/gorm"
 )
 
 type CreateUserRequest struct {
-	Username   string          `json:"username" validate:"required"`
-	Password   string          `json:"password" validate:"required,min=6"`
-	Role       models.UserRole `json:"role" validate:"required"`
-	Department string          `json:"department"`
-	FullName   string          `json:"full_name"`
-	Email      string          `json:"email"`
+	Username           string            `json:"username" validate:"required,min=3,max=50"`
+	Password           string            `json:"password" validate:"required,max=72"`
+	Role               models.UserRole   `json:"role" validate:"required,user_role"`
+	Roles              []models.UserRole `json:"roles" validate:"max=20,dive,user_role"`
+	Department         string            `json:"department" validate:"max=100"`
+	Team               string            `json:"team" validate:"max=100"`
+	BranchID           *uint             `json:"branch_id"`
+	FullName           string            `json:"full_name" validate:"max=200"`
+	Email              string            `json:"email" validate:"omitempty,email,max=200"`
+	MustChangePassword bool              `json:"must_change_password"`
 }
 
+type ImpersonateRequest struct {
+	// Reason is recorded in the auth event log, e.g. a support ticket
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+type ImpersonationResponse struct {
+	Token     string       `json:"token"`
+	ExpiresIn int          `json:"expires_in"`
+	User      UserResponse `json:"user"`
+}
+
+type UserListQuery struct {
+	Search     string          `query:"search" validate:"max=100"`
+	Role       models.UserRole `query:"role" validate:"max=50"`
+	Department string          `query:"department" validate:"max=100"`
+	Active     string          `query:"active" validate:"omitempty,oneof=true false"`
+	Deleted    bool            `query:"deleted"`
+	Page       int             `query:"page" validate:"omitempty,min=1"`
+	PerPage    int             `query:"per_page" validate:"omitempty,min=1,max=200"`
+}
+
+type DeleteUserQuery struct {
+	// ReassignTo takes over the user's undelivered orders
+	ReassignTo *uint `query:"reassign_to"`
+}
+
+// UpdateUserRequest is a JSON Merge Patch of a user. Members left out keep
+// their value; null clears the profile fields, the branch and the
+// additional roles.
 type UpdateUserRequest struct {
-	Password   string          `json:"password,omitempty"`
-	Role       models.UserRole `json:"role"`
-	Department string          `json:"department"`
-	FullName   string          `json:"full_name"`
-	Email      string          `json:"email"`
-	IsActive   *bool           `json:"is_active"`
+	Password           patch.Field[string]            `json:"password" validate:"omitempty,max=72"`
+	Role               patch.Field[models.UserRole]   `json:"role" validate:"omitempty,user_role"`
+	Roles              patch.Field[[]models.UserRole] `json:"roles" validate:"omitempty,max=20,dive,user_role"`
+	Department         patch.Field[string]            `json:"department" validate:"omitempty,max=100"`
+	Team               patch.Field[string]            `json:"team" validate:"omitempty,max=100"`
+	BranchID           patch.Field[uint]              `json:"branch_id"`
+	FullName           patch.Field[string]            `json:"full_name" validate:"omitempty,max=200"`
+	Email              patch.Field[string]            `json:"email" validate:"omitempty,email,max=200"`
+	IsActive           patch.Field[bool]              `json:"is_active"`
+	MustChangePassword patch.Field[bool]              `json:"must_change_password"`
 }
 
-// GetUsers returns all users (Admin only)
+// GetUsers returns a page of the users of the caller's branch, or of all
+// branches with branches.all (users.read). Supports filtering by search
+// (username, name or email), role, department and active, and listing
+// deleted users with deleted=true. The total count is sent in the
+// X-Total-Count header.
 func GetUsers(c echo.Context) error {
+	var req UserListQuery
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PerPage == 0 {
+		req.PerPage = 50
+	}
+
+	db := tenantDB(c)
+	if req.Deleted {
+		db = db.Unscoped().Where("users.deleted_at IS NOT NULL")
+	}
+	query, err := branchUsers(c, db.Model(&models.User{}))
+	if err != nil {
+		return err
+	}
+	if req.Search != "" {
+		pattern := "%" + req.Search + "%"
+		query = query.Where("users.username ILIKE ? OR users.full_name ILIKE ? OR users.email ILIKE ?", pattern, pattern, pattern)
+	}
+	if req.Role != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM user_roles JOIN roles ON roles.id = user_roles.role_id WHERE user_roles.user_id = users.id AND roles.name = ?)", req.Role)
+	}
+	if req.Department != "" {
+		query = query.Where("users.department = ?", req.Department)
+	}
+	if req.Active != "" {
+		query = query.Where("users.is_active = ?", req.Active == "true")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to count users")
+	}
+
 	var users []models.User
-	if err := database.DB.Find(&users).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch users")
+	err = query.Preload("Roles").Preload("Branch").
+		Order("users.username").
+		Limit(req.PerPage).
+		Offset((req.Page - 1) * req.PerPage).
+		Find(&users).Error
+	if err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to fetch users")
 	}
 
+	c.Response().Header().Set(HeaderTotalCount, strconv.FormatInt(total, 10))
 	return c.JSON(http.StatusOK, users)
 }
 
@@ -41,95 +132,452 @@ func GetUsers(c echo.Context) error {
 func GetUser(c echo.Context) error {
 	id := c.Param("id")
 
+	query, err := branchUsers(c, tenantDB(c).Preload("Roles").Preload("Branch"))
+	if err != nil {
+		return err
+	}
+
 	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	if err := query.First(&user, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
 	}
 
 	return c.JSON(http.StatusOK, user)
 }
 
-// CreateUser creates a new user (Admin only)
+// CreateUser creates a new user (users.manage). The caller must hold every
+// permission the new user's roles grant.
 func CreateUser(c echo.Context) error {
 	var req CreateUserRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+	roles, err := auth.FindRoles(tenantDB(c).Preload("Permissions"), append([]models.UserRole{req.Role}, req.Roles...))
+	if err != nil {
+		return rolesError("roles", err)
+	}
+	if err := checkGrantableRoles(c, req.Role, roles); err != nil {
+		return err
+	}
+	branchID, err := newUserBranch(c, req.BranchID)
+	if err != nil {
+		return err
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := auth.HashPassword(req.Password, req.Username)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hash password")
+		return passwordError("password", err)
 	}
 
 	user := models.User{
-		Username:     req.Username,
-		PasswordHash: string(hashedPassword),
-		Role:         req.Role,
-		Department:   req.Department,
-		FullName:     req.FullName,
-		Email:        req.Email,
-		IsActive:     true,
+		Username:           req.Username,
+		PasswordHash:       hashedPassword,
+		Role:               req.Role,
+		Department:         req.Department,
+		Team:               req.Team,
+		BranchID:           branchID,
+		FullName:           req.FullName,
+		Email:              req.Email,
+		IsActive:           true,
+		MustChangePassword: req.MustChangePassword,
 	}
 
-	if err := database.DB.Create(&user).Error; err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "failed to create user")
+	if err := tenantDB(c).Create(&user).Error; err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to create user")
+	}
+	if err := auth.SetUserRoles(&user, user.Role, req.Roles); err != nil {
+		return rolesError("roles", err)
 	}
 
 	return c.JSON(http.StatusCreated, user)
 }
 
-// UpdateUser updates an existing user (Admin only)
+// UpdateUser applies a JSON Merge Patch to a user (users.manage). Changing
+// roles also needs roles.manage, and taking a user out of every branch
+// needs branches.all. The caller must hold every permission granted by the
+// roles they assign. The password, email, active flag, forced password
+// change and roles of users with permissions the caller lacks can't be
+// changed.
 func UpdateUser(c echo.Context) error {
 	id := c.Param("id")
 
+	query, err := branchUsers(c, tenantDB(c).Preload("Roles"))
+	if err != nil {
+		return err
+	}
 	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	if err := query.First(&user, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
 	}
 
 	var req UpdateUserRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	if err := bindPatch(c, &req); err != nil {
+		return err
+	}
+	err = notNull(map[string]bool{
+		"password":             req.Password.Null,
+		"role":                 req.Role.Null || req.Role.Set && req.Role.Value == "",
+		"is_active":            req.IsActive.Null,
+		"must_change_password": req.MustChangePassword.Null,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Check the roles before changing anything
+	if (req.Role.Set || req.Roles.Set) && !can(c, models.PermRolesManage) {
+		field := "roles"
+		if req.Role.Set {
+			field = "role"
+		}
+		return fieldForbidden(field, "changing roles requires the roles.manage permission")
+	}
+	names := req.Roles.Value
+	if req.Role.HasValue() {
+		names = append([]models.UserRole{req.Role.Value}, req.Roles.Value...)
+	}
+	if len(names) > 0 {
+		roles, err := auth.FindRoles(tenantDB(c).Preload("Permissions"), names)
+		if err != nil {
+			return rolesError("roles", err)
+		}
+		primary := user.Role
+		if req.Role.Set {
+			primary = req.Role.Value
+		}
+		if err := checkGrantableRoles(c, primary, roles); err != nil {
+			return err
+		}
+	}
+
+	// Setting the password or email of a more privileged user would let the
+	// caller log in as them
+	for _, member := range []struct {
+		field string
+		set   bool
+	}{
+		{"password", req.Password.Set},
+		{"email", req.Email.Set},
+		{"is_active", req.IsActive.Set},
+		{"must_change_password", req.MustChangePassword.Set},
+		{"role", req.Role.Set},
+		{"roles", req.Roles.Set},
+	} {
+		if member.set {
+			if err := checkNotOutranked(c, &user, member.field); err != nil {
+				return err
+			}
+		}
+	}
+
+	if req.Password.HasValue() && user.IsServiceAccount {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+			WithFields(apierror.FieldError{Field: "password", Code: "service_account", Message: "service accounts authenticate with API keys only"})
 	}
 
 	// Update password if provided
-	if req.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if req.Password.HasValue() {
+		hashedPassword, err := auth.HashPassword(req.Password.Value, user.Username)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to hash password")
+			return passwordError("password", err)
 		}
-		user.PasswordHash = string(hashedPassword)
+		user.PasswordHash = hashedPassword
 	}
 
-	// Update other fields
-	user.Role = req.Role
-	user.Department = req.Department
-	user.FullName = req.FullName
-	user.Email = req.Email
-	if req.IsActive != nil {
-		user.IsActive = *req.IsActive
+	// Update the members that were sent; null ones are cleared
+	if req.Department.Set {
+		user.Department = req.Department.Value
+	}
+	if req.Team.Set {
+		user.Team = req.Team.Value
+	}
+	if req.BranchID.HasValue() {
+		branch, err := branchForUser(c, req.BranchID.Value, "branch_id")
+		if err != nil {
+			return err
+		}
+		user.BranchID = &branch.ID
+	} else if req.BranchID.Null {
+		if !can(c, models.PermBranchesAll) {
+			return fieldForbidden("branch_id", "removing a user from their branch requires the branches.all permission")
+		}
+		user.BranchID = nil
+	}
+	if req.FullName.Set {
+		user.FullName = req.FullName.Value
+	}
+	if req.Email.Set {
+		user.Email = req.Email.Value
+	}
+	if req.IsActive.Set {
+		user.IsActive = req.IsActive.Value
+	}
+	if req.MustChangePassword.Set {
+		user.MustChangePassword = req.MustChangePassword.Value
 	}
 
-	if err := database.DB.Save(&user).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user")
+	// A new primary role replaces the old one; roles replaces the others.
+	// Both are saved with the other members so a refused role change
+	// leaves the user as it was.
+	var roles *auth.RoleChange
+	if req.Role.Set || req.Roles.Set {
+		roles = &auth.RoleChange{Primary: user.Role, Extra: otherRoles(&user)}
+		if req.Role.Set {
+			roles.Primary = req.Role.Value
+		}
+		if req.Roles.Set {
+			roles.Extra = req.Roles.Value
+		}
+	}
+	if err := auth.SaveUser(&user, roles); err != nil {
+		return rolesError("roles", err)
+	}
+
+	// Deactivated users and reset passwords end existing sessions
+	if !user.IsActive || req.Password.Set {
+		if err := auth.RevokeUserSessions(user.ID); err != nil {
+			return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to revoke sessions").Wrap(err)
+		}
+	}
+	if req.Password.Set {
+		actorID := c.Get("user_id").(uint)
+		auth.RecordEvent(models.AuthEvent{Type: models.EventPasswordChanged, UserID: &user.ID, Username: user.Username, ActorID: &actorID, Detail: "set by administrator"}, clientInfo(c))
 	}
 
 	return c.JSON(http.StatusOK, user)
 }
 
-// DeleteUser soft deletes a user (Admin only)
+// DeleteUser soft deletes a user (users.manage). With reassign_to, their
+// undelivered orders move to another active user first. Users can't delete
+// themselves or the last active user who can manage roles.
 func DeleteUser(c echo.Context) error {
 	id := c.Param("id")
 
+	var req DeleteUserQuery
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	query, err := branchUsers(c, tenantDB(c))
+	if err != nil {
+		return err
+	}
 	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	if err := query.First(&user, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
+	}
+	if user.ID == c.Get("user_id").(uint) {
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, "you can't delete your own account")
 	}
 
-	if err := database.DB.Delete(&user).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete user")
+	if req.ReassignTo != nil {
+		heirs, err := branchUsers(c, tenantDB(c).Where("is_active = ? AND is_service_account = ?", true, false))
+		if err != nil {
+			return err
+		}
+		var heir models.User
+		if err := heirs.First(&heir, *req.ReassignTo).Error; err != nil || heir.ID == user.ID {
+			return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+				WithFields(apierror.FieldError{Field: "reassign_to", Code: "invalid_user", Message: "must be another active user of your branches"})
+		}
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "user deleted successfully"})
+	reassigned, err := auth.DeleteUser(&user, req.ReassignTo)
+	if err != nil {
+		return roleError(err, "failed to delete user")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"message": "user deleted successfully", "reassigned_orders": reassigned})
+}
+
+// RestoreUser brings back a deleted user (users.manage)
+func RestoreUser(c echo.Context) error {
+	id := c.Param("id")
+
+	query, err := branchUsers(c, tenantDB(c).Unscoped().Where("users.deleted_at IS NOT NULL"))
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err := query.First(&user, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "deleted user not found")
+	}
+
+	if err := auth.RestoreUser(&user); err != nil {
+		return apierror.FromDB(err, apierror.CodeInternal, "failed to restore user")
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// UnlockUser lifts a login lockout before it expires (users.manage). Users
+// with permissions the caller lacks can't be unlocked.
+func UnlockUser(c echo.Context) error {
+	id := c.Param("id")
+	actorID := c.Get("user_id").(uint)
+
+	query, err := branchUsers(c, tenantDB(c))
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err := query.First(&user, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
+	}
+	if err := checkNotOutranked(c, &user, ""); err != nil {
+		return err
+	}
+
+	if err := auth.Unlock(&user); err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to unlock user").Wrap(err)
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventAccountUnlocked, UserID: &user.ID, Username: user.Username, ActorID: &actorID}, clientInfo(c))
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// ImpersonateUser issues a short-lived access token that lets the caller
+// act as another user of their branch scope to see what they see
+// (users.impersonate). There is no refresh token. The caller can't
+// impersonate users with permissions they lack themselves.
+func ImpersonateUser(c echo.Context) error {
+	id := c.Param("id")
+	actorID := c.Get("user_id").(uint)
+
+	var req ImpersonateRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	query, err := branchUsers(c, tenantDB(c))
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err := query.First(&user, id).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
+	}
+	if user.ID == actorID {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeUnprocessable, "you can't impersonate yourself")
+	}
+	if user.IsServiceAccount {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeUnprocessable, "service accounts can't be impersonated")
+	}
+
+	status, err := auth.CurrentStatus(user.ID)
+	if errors.Is(err, auth.ErrUserInactive) {
+		return apierror.New(http.StatusConflict, apierror.CodeAccountDisabled, "user is deactivated")
+	}
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load user").Wrap(err)
+	}
+	if missing := lackedPermission(c, status.Permissions); missing != "" {
+		return apierror.New(http.StatusForbidden, apierror.CodeForbidden, fmt.Sprintf("%s has the %s permission, which you lack", user.Username, missing))
+	}
+
+	var actor models.User
+	if err := tenantDB(c).First(&actor, actorID).Error; err != nil {
+		return apierror.New(http.StatusNotFound, apierror.CodeUserNotFound, "user not found")
+	}
+	token, expiresIn, err := utils.GenerateImpersonationToken(&user, &actor)
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to issue token").Wrap(err)
+	}
+	auth.RecordEvent(models.AuthEvent{Type: models.EventImpersonationStarted, UserID: &user.ID, Username: user.Username, ActorID: &actorID, Detail: req.Reason}, clientInfo(c))
+
+	response := ImpersonationResponse{Token: token, ExpiresIn: expiresIn, User: newUserResponse(&user)}
+	response.User.Impersonator = &ImpersonatorResponse{ID: actor.ID, Username: actor.Username}
+	return c.JSON(http.StatusOK, response)
+}
+
+// otherRoles returns the loaded roles of the user except the primary one
+func otherRoles(user *models.User) []models.UserRole {
+	var roles []models.UserRole
+	for _, role := range user.Roles {
+		if role.Name != user.Role {
+			roles = append(roles, role.Name)
+		}
+	}
+	return roles
+}
+
+// lackedPermission returns the first permission of the set the caller
+// lacks, or "" when they hold all of them
+func lackedPermission(c echo.Context, permissions auth.PermissionSet) models.Permission {
+	for _, permission := range permissions.List() {
+		if !can(c, permission) {
+			return permission
+		}
+	}
+	return ""
+}
+
+// checkGrantableRoles refuses roles that grant permissions the caller
+// lacks, so users.manage can't be used to hand out more access than it has.
+// roles must be loaded with their permissions.
+func checkGrantableRoles(c echo.Context, primary models.UserRole, roles []models.Role) error {
+	for _, role := range roles {
+		granted := auth.PermissionSet{}
+		for _, p := range role.Permissions {
+			granted[p.Permission] = true
+		}
+		if missing := lackedPermission(c, granted); missing != "" {
+			field := "roles"
+			if role.Name == primary {
+				field = "role"
+			}
+			return fieldForbidden(field, fmt.Sprintf("the %s role grants the %s permission, which you lack", role.Name, missing))
+		}
+	}
+	return nil
+}
+
+// checkNotOutranked refuses a change to the field of a user who holds
+// permissions the caller lacks, or any change to them when field is empty
+func checkNotOutranked(c echo.Context, user *models.User, field string) error {
+	permissions, err := auth.UserPermissions(tenantDB(c), user.ID)
+	if err != nil {
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load user").Wrap(err)
+	}
+	if missing := lackedPermission(c, permissions); missing != "" {
+		message := fmt.Sprintf("%s has the %s permission, which you lack", user.Username, missing)
+		if field == "" {
+			return apierror.New(http.StatusForbidden, apierror.CodeForbidden, message)
+		}
+		return fieldForbidden(field, message)
+	}
+	return nil
+}
+
+// branchUsers limits a query to the users of the caller's branch unless
+// their roles grant branches.all
+func branchUsers(c echo.Context, query *gorm.DB) (*gorm.DB, error) {
+	status, err := auth.CurrentStatus(c.Get("user_id").(uint))
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load user").Wrap(err)
+	}
+	return branchScope(c, query, status, "users.branch_id"), nil
+}
+
+// newUserBranch picks the branch of a new user: the requested one, else
+// the caller's own branch for callers limited to it, else the default
+// branch
+func newUserBranch(c echo.Context, requested *uint) (*uint, error) {
+	if requested != nil {
+		branch, err := branchForUser(c, *requested, "branch_id")
+		if err != nil {
+			return nil, err
+		}
+		return &branch.ID, nil
+	}
+	if can(c, models.PermBranchesAll) {
+		return nil, nil
+	}
+	status, err := auth.CurrentStatus(c.Get("user_id").(uint))
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to load user").Wrap(err)
+	}
+	return status.BranchID, nil
 }
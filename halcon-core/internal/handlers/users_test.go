@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nietzshn/halcon-core/internal/models"
+)
+
+const (
+	salesRole   = 3
+	managerID   = 50
+	managerRole = 51
+)
+
+// managerActor manages users, roles and service accounts of every branch
+// but lacks the other permissions an Admin has
+var managerActor = testActor{id: managerID, username: "manager", roles: []models.UserRole{"Manager"}, permissions: []models.Permission{
+	models.PermUsersManage, models.PermRolesManage, models.PermServiceAccountsManage, models.PermBranchesAll,
+}}
+
+// seedUsers stores tenant A's admin, whose role grants every permission,
+// and the clerk, whose role grants none
+func seedUsers(t *testing.T, fake *fakeDB) {
+	t.Helper()
+	impersonationToken(t, fake)
+	fake.insert("roles", fakeRow{"id": int64(salesRole), "tenant_id": int64(tenantA), "name": "Sales", "is_system": true})
+	fake.insert("user_roles", fakeRow{"user_id": int64(clerkID), "role_id": int64(salesRole)})
+}
+
+// seedManager stores the user managerActor acts as
+func seedManager(fake *fakeDB) {
+	fake.insert("users", fakeRow{"id": int64(managerID), "tenant_id": int64(tenantA), "username": "manager", "role": "Manager", "is_active": true, "is_service_account": false})
+	fake.insert("roles", fakeRow{"id": int64(managerRole), "tenant_id": int64(tenantA), "name": "Manager"})
+	fake.insert("user_roles", fakeRow{"user_id": int64(managerID), "role_id": int64(managerRole)})
+	for _, p := range managerActor.permissions {
+		fake.insert("role_permissions", fakeRow{"role_id": int64(managerRole), "permission": string(p)})
+	}
+}
+
+func TestUpdateUserRefusedRoleChangeKeepsTheOtherFields(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedUsers(t, fake)
+
+	status, body := call(t, UpdateUser, &adminActor, testRequest{
+		method: http.MethodPatch,
+		params: withID(adminA),
+		body:   `{"email":"someone@example.com","role":"Sales"}`,
+	})
+	if status != http.StatusConflict {
+		t.Fatalf("demoting the last role manager: got status %d (%s), want 409", status, body)
+	}
+
+	admin := fake.rows("users", fakeRow{"id": int64(adminA)})[0]
+	if admin["email"] == "someone@example.com" || admin["role"] != "Admin" {
+		t.Fatalf("admin saved as email %v, role %v after a refused role change", admin["email"], admin["role"])
+	}
+	if roles := fake.rows("user_roles", fakeRow{"user_id": int64(adminA)}); len(roles) != 1 || roles[0]["role_id"] != int64(adminRole) {
+		t.Fatalf("admin has roles %v after a refused role change", roles)
+	}
+}
+
+func TestUsersWithPermissionsTheCallerLacksCantBeChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(id int64) (int, string)
+	}{
+		{"email", func(id int64) (int, string) {
+			return call(t, UpdateUser, &managerActor, testRequest{method: http.MethodPatch, params: withID(id), body: `{"email":"someone@example.com"}`})
+		}},
+		{"role", func(id int64) (int, string) {
+			return call(t, UpdateUser, &managerActor, testRequest{method: http.MethodPatch, params: withID(id), body: `{"role":"Sales"}`})
+		}},
+		{"reset 2FA", func(id int64) (int, string) {
+			return call(t, ResetUserTwoFactor, &managerActor, testRequest{method: http.MethodPost, params: withID(id)})
+		}},
+		{"unlock", func(id int64) (int, string) {
+			return call(t, UnlockUser, &managerActor, testRequest{method: http.MethodPost, params: withID(id)})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testDB(t)
+			testKeys(t)
+			seedUsers(t, fake)
+			seedManager(fake)
+
+			if status, body := tt.handler(adminA); status != http.StatusForbidden {
+				t.Errorf("admin: got status %d (%s), want 403", status, body)
+			}
+			if status, body := tt.handler(clerkID); status != http.StatusOK {
+				t.Errorf("clerk: got status %d (%s), want 200", status, body)
+			}
+		})
+	}
+}
+
+func TestRolesGrantingPermissionsTheCallerLacksCantBeAssigned(t *testing.T) {
+	fake := testDB(t)
+	testKeys(t)
+	seedUsers(t, fake)
+	seedManager(fake)
+
+	status, body := call(t, UpdateUser, &managerActor, testRequest{
+		method: http.MethodPatch,
+		params: withID(clerkID),
+		body:   `{"roles":["Admin"]}`,
+	})
+	if status != http.StatusForbidden {
+		t.Errorf("giving the clerk the Admin role: got status %d (%s), want 403", status, body)
+	}
+
+	status, body = call(t, CreateServiceAccount, &managerActor, testRequest{
+		method: http.MethodPost,
+		body:   `{"username":"erp","role":"Admin"}`,
+	})
+	if status != http.StatusForbidden {
+		t.Errorf("creating an Admin service account: got status %d (%s), want 403", status, body)
+	}
+	if accounts := fake.rows("users", fakeRow{"username": "erp"}); len(accounts) != 0 {
+		t.Errorf("refused service account was stored: %v", accounts)
+	}
+}
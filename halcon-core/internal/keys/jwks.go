@@ -0,0 +1,51 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is the public form of a signing key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, newest first
+func (s *KeySet) JWKS() JWKS {
+	keys := s.Keys()
+	doc := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		doc.Keys = append(doc.Keys, key.JWK())
+	}
+	return doc
+}
+
+// JWK returns the public key in JWK form
+func (k *Key) JWK() JWK {
+	jwk := JWK{Use: "sig", Algorithm: k.Algorithm, KeyID: k.ID}
+	switch pub := k.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	}
+	return jwk
+}
@@ -0,0 +1,347 @@
+// Package keys manages the asymmetric keys that sign access tokens. Keys are
+// PEM files named <kid>.pem in the keys directory; the newest key that has
+// been published for PublishDelay signs new tokens and every key in the
+// directory verifies them, so a rotated key keeps accepting the tokens it
+// issued until it is removed.
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+)
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// rsaKeyBits is the size of generated RSA keys
+const rsaKeyBits = 3072
+
+// reloadInterval is how often the directory is re-read, and limits how
+// often an unknown kid triggers an extra reload
+const reloadInterval = 30 * time.Second
+
+// PublishDelay is how long a new key is published in the JWKS before it
+// signs tokens, matching the max-age clients may cache the JWKS for
+const PublishDelay = 5 * time.Minute
+
+// kidTimeFormat prefixes key IDs so they sort by creation time
+const kidTimeFormat = "20060102T150405Z"
+
+// ErrUnknownKey is returned when a token names a kid that is not loaded
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key is a signing key identified by its kid
+type Key struct {
+	ID        string
+	Algorithm string
+	Signer    crypto.Signer
+	CreatedAt time.Time
+}
+
+// Public returns the public half of the key
+func (k *Key) Public() crypto.PublicKey {
+	return k.Signer.Public()
+}
+
+// KeySet holds the keys loaded from a directory
+type KeySet struct {
+	mu         sync.RWMutex
+	dir        string
+	keys       map[string]*Key
+	signing    *Key
+	lastReload time.Time
+}
+
+// Default is the key set used by the server, loaded by Init
+var Default *KeySet
+
+// Init loads the keys from JWT_KEYS_DIR into Default. In development an
+// empty directory gets a freshly generated key; production refuses to start.
+func Init() error {
+	dir := config.AppConfig.JWTKeysDir
+
+	existing, err := List(dir)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		if config.AppConfig.IsProduction() {
+			return fmt.Errorf("no signing keys in %s; run \"halconctl keys generate\"", dir)
+		}
+		key, err := Generate(dir, config.AppConfig.JWTAlgorithm)
+		if err != nil {
+			return err
+		}
+		log.Printf("Generated development signing key %s in %s", key.ID, dir)
+	}
+
+	set, err := Load(dir)
+	if err != nil {
+		return err
+	}
+	Default = set
+	go set.watch()
+	return nil
+}
+
+// Load reads every key in dir and selects the signing key with Signing
+func Load(dir string) (*KeySet, error) {
+	set := &KeySet{dir: dir}
+	if err := set.reload(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// SigningKey returns the key that signs new tokens
+func (s *KeySet) SigningKey() *Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.signing
+}
+
+// VerificationKey returns the key with the given kid. An unknown kid
+// reloads the directory, at most once per reloadInterval, so keys added by
+// another instance are picked up without a restart.
+func (s *KeySet) VerificationKey(kid string) (*Key, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	stale := time.Since(s.lastReload) > reloadInterval
+	s.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if stale {
+		if err := s.reload(); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		key, ok = s.keys[kid]
+		s.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// watch reloads the directory every reloadInterval so added, rotated and
+// removed keys take effect without a restart
+func (s *KeySet) watch() {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		previous := s.SigningKey()
+		if err := s.reload(); err != nil {
+			log.Printf("Failed to reload signing keys: %v", err)
+			continue
+		}
+		if current := s.SigningKey(); previous == nil || current.ID != previous.ID {
+			log.Printf("Signing new tokens with key %s", current.ID)
+		}
+	}
+}
+
+// Keys returns every loaded key, newest first
+func (s *KeySet) Keys() []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sortNewestFirst(keys)
+	return keys
+}
+
+// reload replaces the loaded keys with the contents of the directory
+func (s *KeySet) reload() error {
+	keys, err := List(s.dir)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no signing keys in %s", s.dir)
+	}
+
+	byID := make(map[string]*Key, len(keys))
+	for _, key := range keys {
+		byID[key.ID] = key
+	}
+
+	s.mu.Lock()
+	s.keys = byID
+	s.signing = Signing(keys, time.Now())
+	s.lastReload = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// List reads every key in dir, newest first. A missing directory has no keys.
+func List(dir string) ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sortNewestFirst(keys)
+	return keys, nil
+}
+
+// Signing picks the key that signs new tokens from keys sorted newest first:
+// the newest key created at least PublishDelay before now, so clients that
+// cached the JWKS already know it. Without one, the oldest key signs.
+func Signing(keys []*Key, now time.Time) *Key {
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if !key.CreatedAt.After(now.Add(-PublishDelay)) {
+			return key
+		}
+	}
+	return keys[len(keys)-1]
+}
+
+// Generate creates a new key with the given algorithm and writes it to dir.
+// It is published right away and signs new tokens after PublishDelay.
+func Generate(dir, algorithm string) (*Key, error) {
+	var signer crypto.Signer
+	switch algorithm {
+	case AlgEdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		signer = priv
+	case AlgRS256:
+		priv, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		signer = priv
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q, use %s or %s", algorithm, AlgEdDSA, AlgRS256)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %w", err)
+	}
+	now := time.Now().UTC()
+	key := &Key{
+		ID:        now.Format(kidTimeFormat) + "-" + hex.EncodeToString(suffix),
+		Algorithm: algorithm,
+		Signer:    signer,
+		CreatedAt: now.Truncate(time.Second),
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create keys directory: %w", err)
+	}
+
+	path := filepath.Join(dir, key.ID+".pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, block, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+
+	return key, nil
+}
+
+// Remove deletes the key with the given kid from dir. Running servers drop
+// it from verification and the JWKS at their next reload, within
+// reloadInterval.
+func Remove(dir, kid string) error {
+	if kid == "" || strings.ContainsAny(kid, `/\.`) {
+		return fmt.Errorf("invalid key id %q", kid)
+	}
+
+	err := os.Remove(filepath.Join(dir, kid+".pem"))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("key %q not found", kid)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove key: %w", err)
+	}
+	return nil
+}
+
+// readKey parses a PKCS#8 PEM private key named <kid>.pem
+func readKey(path string) (*Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("invalid key %s: expected a PKCS#8 PRIVATE KEY block", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", path, err)
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+	key := &Key{ID: kid}
+	switch priv := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgEdDSA
+		key.Signer = priv
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgRS256
+		key.Signer = priv
+	default:
+		return nil, fmt.Errorf("invalid key %s: unsupported key type %T", path, parsed)
+	}
+
+	if created, err := time.Parse(kidTimeFormat, strings.SplitN(kid, "-", 2)[0]); err == nil {
+		key.CreatedAt = created
+	} else if info, err := os.Stat(path); err == nil {
+		key.CreatedAt = info.ModTime().UTC()
+	}
+	return key, nil
+}
+
+func sortNewestFirst(keys []*Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID > keys[j].ID
+	})
+}
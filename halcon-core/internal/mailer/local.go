@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/utils"
+)
+
+// FileMailer writes every message as an .eml file into Dir, for development
+// and for pickup by an external mail relay
+type FileMailer struct {
+	Dir  string
+	From *mail.Address
+}
+
+// Send writes msg to a new file in Dir
+func (m *FileMailer) Send(msg Message) error {
+	data, err := render(m.From, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	suffix, err := utils.RandomToken(6)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405Z"), suffix)
+	if err := os.WriteFile(filepath.Join(m.Dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	return nil
+}
+
+// LogMailer prints messages to the server log instead of sending them.
+// Development only, since messages may carry password reset links.
+type LogMailer struct {
+	From *mail.Address
+}
+
+// Send logs msg
+func (m *LogMailer) Send(msg Message) error {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
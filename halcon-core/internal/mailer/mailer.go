@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/utils"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer selected by MAIL_DRIVER, set by Init
+var Default Mailer
+
+// Init creates the Default mailer from the configuration
+func Init() error {
+	m, err := New(config.AppConfig)
+	if err != nil {
+		return err
+	}
+	Default = m
+	return nil
+}
+
+// New returns the mailer configured by MAIL_DRIVER
+func New(cfg *config.Config) (Mailer, error) {
+	from, err := mail.ParseAddress(cfg.MailFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+
+	switch cfg.MailDriver {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     from,
+		}, nil
+	case "file":
+		return &FileMailer{Dir: cfg.MailDir, From: from}, nil
+	case "log":
+		return &LogMailer{From: from}, nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.MailDriver)
+}
+
+// Send delivers msg with the Default mailer
+func Send(msg Message) error {
+	if Default == nil {
+		return fmt.Errorf("mailer is not initialized")
+	}
+	return Default.Send(msg)
+}
+
+// render encodes msg as an RFC 5322 message with a quoted-printable body
+func render(from *mail.Address, msg Message) ([]byte, error) {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+
+	id, err := utils.RandomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", id, domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer sends messages through an SMTP server. STARTTLS is used when
+// the server offers it, and authentication requires it unless the server
+// is on localhost.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     *mail.Address
+}
+
+// Send delivers msg to the SMTP server
+func (m *SMTPMailer) Send(msg Message) error {
+	data, err := render(m.From, msg)
+	if err != nil {
+		return err
+	}
+	to, _ := mail.ParseAddress(msg.To)
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	if err := smtp.SendMail(addr, auth, m.From.Address, []string{to.Address}, data); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", to.Address, err)
+	}
+	return nil
+}
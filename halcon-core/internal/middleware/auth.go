@@ -1,40 +1,287 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/tenant"
 	"github.com/nietzshn/halcon-core/internal/utils"
 )
 
-// AuthMiddleware validates JWT tokens
+// passwordChangeRoutes are reachable while a password change is pending
+var passwordChangeRoutes = map[string]bool{
+	"/api/auth/me":              true,
+	"/api/auth/change-password": true,
+	"/api/auth/logout":          true,
+}
+
+// twoFactorSetupRoutes are reachable by users whose role requires 2FA
+// before they have enrolled
+var twoFactorSetupRoutes = map[string]bool{
+	"/api/auth/me":              true,
+	"/api/auth/change-password": true,
+	"/api/auth/logout":          true,
+	"/api/auth/2fa/setup":       true,
+	"/api/auth/2fa/enable":      true,
+}
+
+// impersonationWriteRoutes are the only changes an impersonation token
+// can make, besides reading: working on orders the way the user does, and
+// logging out. Anything else could change credentials, users, roles,
+// branches or company settings, or start another impersonation.
+var impersonationWriteRoutes = map[string]bool{
+	"POST /api/auth/logout":         true,
+	"POST /api/orders":              true,
+	"PUT /api/orders/:id":           true,
+	"PATCH /api/orders/:id":         true,
+	"POST /api/orders/:id/evidence": true,
+}
+
+// apiKeyRoutes are reachable with an API key without a scope
+var apiKeyRoutes = map[string]bool{
+	"/api/auth/me": true,
+}
+
+// HeaderAPIKey carries an API key as an alternative to the Authorization header
+const HeaderAPIKey = "X-API-Key"
+
+// AuthMiddleware authenticates requests with a JWT access token, or with a
+// service account API key sent in X-API-Key or as a Bearer token. The user
+// must belong to the tenant the request was addressed to; it runs after
+// TenantMiddleware. Requests with an impersonation token act as the
+// impersonated user, can only read and work on orders, and are all recorded
+// in the auth event log with both users.
 func AuthMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if key := c.Request().Header.Get(HeaderAPIKey); key != "" {
+				if err := authenticateAPIKey(c, key); err != nil {
+					return err
+				}
+				return next(c)
+			}
+
 			authHeader := c.Request().Header.Get("Authorization")
 			if authHeader == "" {
-				return echo.NewHTTPError(http.StatusUnauthorized, "missing authorization header")
+				return apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "missing authorization header")
 			}
 
 			// Extract token from "Bearer <token>"
 			parts := strings.Split(authHeader, " ")
 			if len(parts) != 2 || parts[0] != "Bearer" {
-				return echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization format")
+				return apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid authorization format")
 			}
 
 			token := parts[1]
+			if strings.HasPrefix(token, auth.APIKeyPrefix) {
+				if err := authenticateAPIKey(c, token); err != nil {
+					return err
+				}
+				return next(c)
+			}
+
 			claims, err := utils.ValidateToken(token)
+			if err != nil || claims.Purpose != "" {
+				return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "invalid or expired token")
+			}
+
+			// Tokens revoked by logout are rejected until they expire
+			revoked, err := auth.IsRevoked(claims.ID)
 			if err != nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired token")
+				return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to verify token").Wrap(err)
+			}
+			if revoked {
+				return apierror.New(http.StatusUnauthorized, apierror.CodeTokenRevoked, "token has been revoked")
+			}
+
+			// The stored role and active flag win over the token claims
+			status, err := currentStatus(claims.UserID)
+			if err != nil {
+				return err
+			}
+
+			// Tokens issued before tenants existed carry no tenant
+			tokenTenant := claims.TenantID
+			if tokenTenant == 0 {
+				tokenTenant = tenant.DefaultID
+			}
+			if tokenTenant != status.TenantID {
+				return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "invalid or expired token")
+			}
+			if err := checkTenant(c, status); err != nil {
+				return err
 			}
 
 			// Store claims in context for use in handlers
 			c.Set("user_id", claims.UserID)
-			c.Set("username", claims.Username)
-			c.Set("role", claims.Role)
+			c.Set("username", status.Username)
+			c.Set("role", status.Role)
+			c.Set("roles", status.Roles)
+			c.Set("permissions", status.Permissions)
+			c.Set("token_id", claims.ID)
+			if claims.ExpiresAt != nil {
+				c.Set("token_expires_at", claims.ExpiresAt.Time)
+			}
 
+			if claims.Act != nil {
+				return serveImpersonated(c, next, claims, status)
+			}
+			if err := checkPendingSetup(c, status); err != nil {
+				return err
+			}
 			return next(c)
 		}
 	}
 }
+
+// checkPendingSetup limits users who must change their password or enroll
+// in 2FA to the routes that do so
+func checkPendingSetup(c echo.Context, status *auth.UserStatus) error {
+	// Users with a temporary password may only change it
+	if status.MustChangePassword && !passwordChangeRoutes[c.Path()] {
+		return apierror.New(http.StatusForbidden, apierror.CodePasswordChange, "password must be changed before continuing")
+	}
+
+	// Roles that require 2FA may only enroll until they have
+	if auth.TwoFactorRequired(status.Roles...) && !status.TwoFactorEnabled && !twoFactorSetupRoutes[c.Path()] {
+		return apierror.New(http.StatusForbidden, apierror.CodeTwoFactorSetup, "two-factor authentication must be set up before continuing")
+	}
+	return nil
+}
+
+// serveImpersonated handles a request made with an impersonation token. The
+// impersonator must still be active and allowed to impersonate; requests
+// they may not make are refused, and every request is recorded.
+func serveImpersonated(c echo.Context, next echo.HandlerFunc, claims *utils.JWTClaims, status *auth.UserStatus) error {
+	actor, err := currentStatus(claims.Act.UserID)
+	if err != nil {
+		return err
+	}
+	if actor.TenantID != requestTenant(c) || !actor.Permissions.Has(models.PermUsersImpersonate) {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "impersonation is no longer allowed")
+	}
+	c.Set("impersonator_id", claims.Act.UserID)
+	c.Set("impersonator_username", actor.Username)
+
+	method := c.Request().Method
+	if method != http.MethodGet && method != http.MethodHead && !impersonationWriteRoutes[method+" "+c.Path()] {
+		err = apierror.New(http.StatusForbidden, apierror.CodeImpersonation, "this action is not allowed while impersonating a user")
+	} else if err = checkPendingSetup(c, status); err == nil {
+		err = next(c)
+	}
+
+	userID := claims.UserID
+	client := auth.ClientInfo{IPAddress: c.RealIP(), UserAgent: c.Request().UserAgent(), TenantID: requestTenant(c)}
+	auth.RecordEvent(models.AuthEvent{
+		Type:     models.EventImpersonatedRequest,
+		UserID:   &userID,
+		Username: status.Username,
+		ActorID:  &claims.Act.UserID,
+		Detail:   fmt.Sprintf("%s %s: %d", method, c.Request().URL.RequestURI(), responseStatus(c, err)),
+	}, client)
+	return err
+}
+
+// responseStatus returns the status a handler responded with, or will once
+// the error handler renders its error
+func responseStatus(c echo.Context, err error) int {
+	var apiErr *apierror.Error
+	var httpErr *echo.HTTPError
+	switch {
+	case err == nil:
+		return c.Response().Status
+	case errors.As(err, &apiErr):
+		return apiErr.Status
+	case errors.As(err, &httpErr):
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// authenticateAPIKey verifies an API key, its IP allowlist and its scope for
+// the route, and stores the service account in the context
+func authenticateAPIKey(c echo.Context, raw string) error {
+	client := auth.ClientInfo{IPAddress: c.RealIP(), UserAgent: c.Request().UserAgent(), TenantID: requestTenant(c)}
+
+	key, err := auth.AuthenticateAPIKey(raw, client.IPAddress)
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyIPNotAllowed):
+		auth.RecordEvent(models.AuthEvent{Type: models.EventAPIKeyRejected, UserID: &key.UserID, Detail: fmt.Sprintf("key %s used from an address outside its allowlist", key.Prefix)}, client)
+		return apierror.New(http.StatusForbidden, apierror.CodeForbidden, "API key is not allowed from this address")
+	case errors.Is(err, auth.ErrInvalidAPIKey):
+		return apierror.New(http.StatusUnauthorized, apierror.CodeInvalidAPIKey, "invalid or expired API key")
+	case err != nil:
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to verify API key").Wrap(err)
+	}
+
+	// Deactivating or deleting the service account disables its keys
+	status, err := currentStatus(key.UserID)
+	if err != nil {
+		return err
+	}
+	if err := checkTenant(c, status); err != nil {
+		return err
+	}
+
+	if !apiKeyRoutes[c.Path()] {
+		scope := apiKeyScope(c)
+		if scope == "" {
+			return apierror.New(http.StatusForbidden, apierror.CodeInsufficientScope, "this endpoint can't be used with an API key")
+		}
+		if !auth.HasScope(key, scope) {
+			return apierror.New(http.StatusForbidden, apierror.CodeInsufficientScope, fmt.Sprintf("API key lacks the %s scope", scope))
+		}
+	}
+
+	c.Set("user_id", key.UserID)
+	c.Set("username", status.Username)
+	c.Set("role", status.Role)
+	c.Set("roles", status.Roles)
+	c.Set("permissions", status.Permissions)
+	c.Set("api_key_id", key.ID)
+	return nil
+}
+
+// apiKeyScope returns the scope a request needs, derived from the resource
+// under /api and the method, or "" when API keys can't use the route
+func apiKeyScope(c echo.Context) string {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(c.Path(), "/api/"), "/")
+
+	access := "write"
+	if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+		access = "read"
+	}
+
+	scope := resource + ":" + access
+	if !models.IsAPIKeyScope(scope) {
+		return ""
+	}
+	return scope
+}
+
+// checkTenant refuses credentials of a user of another tenant than the one
+// the request was addressed to
+func checkTenant(c echo.Context, status *auth.UserStatus) error {
+	if status.TenantID != requestTenant(c) {
+		return apierror.New(http.StatusUnauthorized, apierror.CodeTenantMismatch, "credentials belong to another tenant")
+	}
+	return nil
+}
+
+// currentStatus loads the stored status of the authenticated user
+func currentStatus(userID uint) (*auth.UserStatus, error) {
+	status, err := auth.CurrentStatus(userID)
+	if errors.Is(err, auth.ErrUserInactive) {
+		return nil, apierror.New(http.StatusUnauthorized, apierror.CodeAccountDisabled, "account is disabled")
+	}
+	if err != nil {
+		return nil, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to verify user").Wrap(err)
+	}
+	return status, nil
+}
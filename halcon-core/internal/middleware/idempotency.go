@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"gorm.io/gorm"
+)
+
+// HeaderIdempotencyKey is the request header carrying the client supplied key
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// HeaderIdempotentReplayed is set on responses replayed from a stored record
+const HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyMiddleware stores the response of POST and PATCH requests sent
+// with an Idempotency-Key header and replays it when the same user retries
+// the request with the same key. Must run after AuthMiddleware.
+func IdempotencyMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			key := req.Header.Get(HeaderIdempotencyKey)
+			if key == "" || (req.Method != http.MethodPost && req.Method != http.MethodPatch) {
+				return next(c)
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "idempotency key is too long")
+			}
+
+			userID, ok := c.Get("user_id").(uint)
+			if !ok {
+				return apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "user not found in context")
+			}
+
+			// Buffer the body so it can be fingerprinted and still read by the handler
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "failed to read request body")
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash, err := requestFingerprint(req, body)
+			if err != nil {
+				return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "invalid request body")
+			}
+
+			var existing models.IdempotencyRecord
+			err = database.DB.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error
+			switch {
+			case err == nil && existing.ExpiresAt.Before(time.Now()):
+				// Expired keys may be reused for a new request
+				if err := database.DB.Delete(&existing).Error; err != nil {
+					return apierror.FromDB(err, apierror.CodeInternal, "failed to process idempotency key")
+				}
+			case err == nil:
+				return replayIdempotentResponse(c, &existing, hash)
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				return apierror.FromDB(err, apierror.CodeInternal, "failed to process idempotency key")
+			}
+
+			record := models.IdempotencyRecord{
+				Key:         key,
+				UserID:      userID,
+				Method:      req.Method,
+				Path:        req.URL.Path,
+				RequestHash: hash,
+				ExpiresAt:   time.Now().Add(time.Duration(config.AppConfig.IdempotencyTTLHours) * time.Hour),
+			}
+
+			// The unique index on (user_id, key) guards against concurrent first attempts
+			if err := database.DB.Create(&record).Error; err != nil {
+				if apierror.IsUniqueViolation(err, "idx_idempotency_user_key") {
+					return apierror.New(http.StatusConflict, apierror.CodeIdempotencyPending, "a request with this idempotency key is already in progress").Wrap(err)
+				}
+				return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to process idempotency key").Wrap(err)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+
+			// A panicking handler must not leave the key pending until it expires
+			defer func() {
+				if r := recover(); r != nil {
+					releaseIdempotencyKey(&record)
+					panic(r)
+				}
+			}()
+
+			if err := next(c); err != nil {
+				// Render the error now so the resulting response can be stored
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError {
+				// Server errors are not final, allow the client to retry with the same key
+				releaseIdempotencyKey(&record)
+				return nil
+			}
+
+			record.Completed = true
+			record.StatusCode = status
+			record.ContentType = c.Response().Header().Get(echo.HeaderContentType)
+			record.Body = recorder.body.Bytes()
+			if err := database.DB.Save(&record).Error; err != nil {
+				// The response is already sent, free the key so a retry is not refused
+				log.Printf("Failed to store idempotent response for key %q: %v", record.Key, err)
+				releaseIdempotencyKey(&record)
+			}
+
+			return nil
+		}
+	}
+}
+
+// releaseIdempotencyKey deletes a pending record so the key can be retried
+func releaseIdempotencyKey(record *models.IdempotencyRecord) {
+	if err := database.DB.Delete(record).Error; err != nil {
+		log.Printf("Failed to release idempotency key %q: %v", record.Key, err)
+	}
+}
+
+// replayIdempotentResponse writes a stored response back to the client
+func replayIdempotentResponse(c echo.Context, record *models.IdempotencyRecord, hash string) error {
+	req := c.Request()
+	if record.RequestHash != hash || record.Method != req.Method || record.Path != req.URL.Path {
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeIdempotencyMismatch, "idempotency key was already used with a different request")
+	}
+
+	if !record.Completed {
+		return apierror.New(http.StatusConflict, apierror.CodeIdempotencyPending, "a request with this idempotency key is already in progress")
+	}
+
+	c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+	if record.ContentType == "" {
+		return c.NoContent(record.StatusCode)
+	}
+	return c.Blob(record.StatusCode, record.ContentType, record.Body)
+}
+
+// requestFingerprint hashes the parts of a request that make it unique.
+// Multipart bodies are hashed part by part because clients pick a new
+// boundary on every attempt.
+func requestFingerprint(req *http.Request, body []byte) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		h.Write(body)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		h.Write([]byte(part.FormName() + "\x00" + part.FileName() + "\x00"))
+		if _, err := io.Copy(h, part); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// responseRecorder tees everything written to the client into a buffer
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
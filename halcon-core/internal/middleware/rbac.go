@@ -1,29 +1,47 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
 	"github.com/nietzshn/halcon-core/internal/models"
 )
 
-// RoleMiddleware checks if the user has one of the required roles
-func RoleMiddleware(allowedRoles ...models.UserRole) echo.MiddlewareFunc {
+// RequirePermission lets the request through when the user's roles grant
+// any of the permissions. Refusals are recorded in the auth event log.
+func RequirePermission(permissions ...models.Permission) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			userRole, ok := c.Get("role").(models.UserRole)
+			granted, ok := c.Get("permissions").(auth.PermissionSet)
 			if !ok {
-				return echo.NewHTTPError(http.StatusUnauthorized, "user role not found")
+				return apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "user permissions not found")
 			}
 
-			// Check if user's role is in the allowed roles
-			for _, role := range allowedRoles {
-				if userRole == role {
-					return next(c)
-				}
+			if !granted.Has(permissions...) {
+				recordDenial(c, permissions)
+				return apierror.New(http.StatusForbidden, apierror.CodeForbidden, "insufficient permissions")
 			}
-
-			return echo.NewHTTPError(http.StatusForbidden, "insufficient permissions")
+			return next(c)
 		}
 	}
 }
+
+// recordDenial logs a request refused for lacking all of the permissions
+func recordDenial(c echo.Context, permissions []models.Permission) {
+	event := models.AuthEvent{
+		Type:   models.EventPermissionDenied,
+		Detail: fmt.Sprintf("%s %s needs %s", c.Request().Method, c.Path(), auth.JoinPermissions(permissions)),
+	}
+	if userID, ok := c.Get("user_id").(uint); ok {
+		event.UserID = &userID
+	}
+	event.Username, _ = c.Get("username").(string)
+	if actorID, ok := c.Get("impersonator_id").(uint); ok {
+		event.ActorID = &actorID
+	}
+	client := auth.ClientInfo{IPAddress: c.RealIP(), UserAgent: c.Request().UserAgent(), TenantID: requestTenant(c)}
+	auth.RecordEvent(event, client)
+}
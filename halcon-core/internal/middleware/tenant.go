@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/tenant"
+	"github.com/nietzshn/halcon-core/internal/utils"
+)
+
+// TenantMiddleware resolves the tenant a request is addressed to and puts
+// it in the request context, which limits the handlers' queries to it. In
+// multi-tenant mode the tenant slug comes from the TENANT_HEADER header or,
+// for browser navigations such as the single sign-on redirect, the tenant
+// query parameter, else from the subdomain of TENANT_BASE_DOMAIN, else from
+// the tid claim of a valid access token; requests naming none belong to the
+// default tenant. Unknown or inactive tenants get a 404.
+func TenantMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			t, err := resolveTenant(c.Request())
+			if errors.Is(err, auth.ErrTenantNotFound) {
+				return apierror.New(http.StatusNotFound, apierror.CodeTenantNotFound, "tenant not found")
+			}
+			if err != nil {
+				return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to resolve tenant").Wrap(err)
+			}
+
+			c.Set("tenant_id", t.ID)
+			c.SetRequest(c.Request().WithContext(tenant.NewContext(c.Request().Context(), t.ID)))
+			return next(c)
+		}
+	}
+}
+
+// resolveTenant finds the tenant named by a request
+func resolveTenant(r *http.Request) (*models.Tenant, error) {
+	cfg := config.AppConfig
+	if !cfg.MultiTenant {
+		return auth.TenantByID(tenant.DefaultID)
+	}
+
+	if cfg.TenantHeader != "" {
+		if slug := strings.TrimSpace(r.Header.Get(cfg.TenantHeader)); slug != "" {
+			return auth.TenantBySlug(strings.ToLower(slug))
+		}
+	}
+	if slug := strings.TrimSpace(r.URL.Query().Get("tenant")); slug != "" {
+		return auth.TenantBySlug(strings.ToLower(slug))
+	}
+	if slug := subdomain(r.Host, cfg.TenantBaseDomain); slug != "" {
+		return auth.TenantBySlug(slug)
+	}
+	if id := tokenTenant(r); id != 0 {
+		return auth.TenantByID(id)
+	}
+	return auth.TenantByID(tenant.DefaultID)
+}
+
+// tokenTenant returns the tenant claim of the request's access token, or 0
+// when there is no valid one. AuthMiddleware still checks the token itself.
+func tokenTenant(r *http.Request) uint {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.HasPrefix(token, auth.APIKeyPrefix) {
+		return 0
+	}
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return 0
+	}
+	return claims.TenantID
+}
+
+// subdomain returns the first label of host when host is a direct
+// subdomain of base, e.g. "acme" for acme.halcon.example.com
+func subdomain(host, base string) string {
+	if base == "" {
+		return ""
+	}
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+		host = host[:i]
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(base))
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// requestTenant returns the tenant resolved by TenantMiddleware
+func requestTenant(c echo.Context) uint {
+	id, _ := c.Get("tenant_id").(uint)
+	return id
+}
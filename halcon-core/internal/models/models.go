@@ -1,12 +1,34 @@
 package models
 
 import (
+	"database/sql"
+	"regexp"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-// UserRole represents the role of a user in the system
+// Tenant is a company hosted on the deployment. Users, orders, roles,
+// branches and the audit log belong to a tenant and are invisible to every
+// other tenant; see package tenant. The settings tailor the order workflow:
+// AllowDirectDispatch lets orders go from Ordered straight to In Route,
+// RequireDeliveryEvidence refuses Delivered without a photo, and a non-zero
+// DeliverySLAHours gives new orders a due date.
+type Tenant struct {
+	ID                      uint      `gorm:"primarykey" json:"id"`
+	Slug                    string    `gorm:"type:varchar(50);uniqueIndex;not null" json:"slug"`
+	Name                    string    `gorm:"type:varchar(100);not null" json:"name"`
+	LogoURL                 string    `gorm:"type:varchar(500);not null;default:''" json:"logo_url"`
+	IsActive                bool      `gorm:"not null;default:true" json:"is_active"`
+	AllowDirectDispatch     bool      `gorm:"not null;default:false" json:"allow_direct_dispatch"`
+	RequireDeliveryEvidence bool      `gorm:"not null;default:false" json:"require_delivery_evidence"`
+	DeliverySLAHours        int       `gorm:"not null;default:0" json:"delivery_sla_hours"`
+	CreatedAt               time.Time `json:"created_at"`
+	UpdatedAt               time.Time `json:"updated_at"`
+}
+
+// UserRole is the name of a role. The five built-in roles are seeded by
+// the migrations; admins can define more, stored in the roles table.
 type UserRole string
 
 const (
@@ -17,19 +39,134 @@ const (
 	RoleRoute      UserRole = "Route"
 )
 
-// User represents a system user with role-based access
+// DefaultRoles lists the built-in roles
+var DefaultRoles = []UserRole{RoleAdmin, RoleSales, RolePurchasing, RoleWarehouse, RoleRoute}
+
+// roleNamePattern is the accepted form of a role name
+var roleNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 _.-]{0,49}$`)
+
+// IsValid reports whether r is a well-formed role name. Whether the role
+// exists is checked against the roles table.
+func (r UserRole) IsValid() bool {
+	return roleNamePattern.MatchString(string(r))
+}
+
+// Permission names an action a role can grant
+type Permission string
+
+const (
+	PermOrdersRead              Permission = "orders.read"
+	PermOrdersReadInProcess     Permission = "orders.read.in_process"
+	PermOrdersCreate            Permission = "orders.create"
+	PermOrdersUpdate            Permission = "orders.update"
+	PermOrdersTransitionProcess Permission = "orders.transition.in_process"
+	PermOrdersTransitionRoute   Permission = "orders.transition.in_route"
+	PermOrdersTransitionDeliver Permission = "orders.transition.delivered"
+	PermOrdersDelete            Permission = "orders.delete"
+	PermOrdersRestore           Permission = "orders.restore"
+	PermOrdersScopeDepartment   Permission = "orders.scope.department"
+	PermOrdersScopeAll          Permission = "orders.scope.all"
+	PermOrdersTransfer          Permission = "orders.transfer"
+	PermBranchesAll             Permission = "branches.all"
+	PermBranchesManage          Permission = "branches.manage"
+	PermEvidenceUpload          Permission = "evidence.upload"
+	PermUsersRead               Permission = "users.read"
+	PermUsersManage             Permission = "users.manage"
+	PermUsersImpersonate        Permission = "users.impersonate"
+	PermRolesManage             Permission = "roles.manage"
+	PermServiceAccountsManage   Permission = "service_accounts.manage"
+	PermAuthEventsRead          Permission = "auth_events.read"
+	PermTenantManage            Permission = "tenant.manage"
+)
+
+// PermissionDescriptions lists every permission with what it allows, in
+// display order
+var PermissionDescriptions = []struct {
+	Permission  Permission `json:"permission"`
+	Description string     `json:"description"`
+}{
+	{PermOrdersRead, "View orders"},
+	{PermOrdersReadInProcess, "View orders that are in process"},
+	{PermOrdersCreate, "Create orders"},
+	{PermOrdersUpdate, "Edit the delivery address and notes of orders"},
+	{PermOrdersTransitionProcess, "Move orders from Ordered to In Process"},
+	{PermOrdersTransitionRoute, "Move orders from In Process to In Route"},
+	{PermOrdersTransitionDeliver, "Move orders from In Route to Delivered"},
+	{PermOrdersDelete, "Move orders to the recycle bin"},
+	{PermOrdersRestore, "Restore orders from the recycle bin"},
+	{PermOrdersScopeDepartment, "Act on orders created in the same department, not only the user's and their team's"},
+	{PermOrdersScopeAll, "Act on orders created by anyone"},
+	{PermOrdersTransfer, "Transfer orders to another branch"},
+	{PermBranchesAll, "Act on the orders and users of every branch, not only the user's own"},
+	{PermBranchesManage, "Create and edit branches"},
+	{PermEvidenceUpload, "Upload delivery evidence photos"},
+	{PermUsersRead, "View users"},
+	{PermUsersManage, "Create, edit and delete users"},
+	{PermUsersImpersonate, "Act as another user to see what they see, without destructive changes"},
+	{PermRolesManage, "Define roles and their permissions"},
+	{PermServiceAccountsManage, "Manage service accounts and API keys"},
+	{PermAuthEventsRead, "View the authentication event log"},
+	{PermTenantManage, "Edit the company name, logo, order workflow and delivery SLA"},
+}
+
+// IsValid reports whether p is a known permission
+func (p Permission) IsValid() bool {
+	for _, d := range PermissionDescriptions {
+		if d.Permission == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Role bundles permissions. System roles are the built-in ones and can't be
+// renamed or deleted.
+type Role struct {
+	ID          uint             `gorm:"primarykey" json:"id"`
+	TenantID    uint             `gorm:"not null;uniqueIndex:idx_roles_tenant_name" json:"-"`
+	Name        UserRole         `gorm:"type:varchar(50);uniqueIndex:idx_roles_tenant_name;not null" json:"name"`
+	Description string           `gorm:"type:varchar(255);not null;default:''" json:"description"`
+	IsSystem    bool             `gorm:"not null;default:false" json:"is_system"`
+	Permissions []RolePermission `gorm:"foreignKey:RoleID" json:"-"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// RolePermission grants a permission to a role
+type RolePermission struct {
+	RoleID     uint       `gorm:"primaryKey"`
+	Permission Permission `gorm:"primaryKey;type:varchar(64)"`
+}
+
+// User represents a system user. Role is the primary role, shown as the
+// user's role; Roles holds every role the user has, the primary one included,
+// and their permissions add up.
 type User struct {
-	ID           uint           `gorm:"primarykey" json:"id"`
-	Username     string         `gorm:"uniqueIndex;not null" json:"username"`
-	PasswordHash string         `gorm:"not null" json:"-"`
-	Role         UserRole       `gorm:"type:varchar(20);not null" json:"role"`
-	Department   string         `gorm:"type:varchar(100)" json:"department"`
-	FullName     string         `gorm:"type:varchar(200)" json:"full_name"`
-	Email        string         `gorm:"type:varchar(200)" json:"email"`
-	IsActive     bool           `gorm:"default:true" json:"is_active"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                 uint           `gorm:"primarykey" json:"id"`
+	TenantID           uint           `gorm:"not null;uniqueIndex:idx_users_tenant_username" json:"-"`
+	Username           string         `gorm:"uniqueIndex:idx_users_tenant_username;not null" json:"username"`
+	PasswordHash       string         `gorm:"not null" json:"-"`
+	Role               UserRole       `gorm:"type:varchar(50);not null" json:"role"`
+	Department         string         `gorm:"type:varchar(100)" json:"department"`
+	Team               string         `gorm:"type:varchar(100);not null;default:''" json:"team"`
+	BranchID           *uint          `gorm:"index" json:"branch_id"`
+	Branch             *Branch        `gorm:"foreignKey:BranchID" json:"branch,omitempty"`
+	FullName           string         `gorm:"type:varchar(200)" json:"full_name"`
+	Email              string         `gorm:"type:varchar(200)" json:"email"`
+	IsActive           bool           `gorm:"default:true" json:"is_active"`
+	MustChangePassword bool           `gorm:"not null;default:false" json:"must_change_password"`
+	FailedLoginCount   int            `gorm:"not null;default:0" json:"failed_login_count"`
+	LockedUntil        *time.Time     `json:"locked_until,omitempty"`
+	TOTPSecret         string         `gorm:"column:totp_secret;type:varchar(64)" json:"-"`
+	TOTPEnabled        bool           `gorm:"column:totp_enabled;not null;default:false" json:"totp_enabled"`
+	TOTPLastStep       int64          `gorm:"column:totp_last_step;not null;default:0" json:"-"`
+	IsServiceAccount   bool           `gorm:"not null;default:false" json:"is_service_account"`
+	OIDCIssuer         string         `gorm:"column:oidc_issuer;type:varchar(255);not null;default:''" json:"-"`
+	OIDCSubject        string         `gorm:"column:oidc_subject;type:varchar(255);not null;default:''" json:"-"`
+	Roles              []Role         `gorm:"many2many:user_roles" json:"roles,omitempty"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // OrderStatus represents the current state of an order
@@ -42,24 +179,330 @@ const (
 	StatusDelivered OrderStatus = "Delivered"
 )
 
+// AllStatuses lists every valid order status in workflow order
+var AllStatuses = []OrderStatus{StatusOrdered, StatusInProcess, StatusInRoute, StatusDelivered}
+
+// IsValid reports whether s is one of the known order statuses
+func (s OrderStatus) IsValid() bool {
+	for _, status := range AllStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 // Order represents a customer order with tracking and evidence
 type Order struct {
-	ID                uint           `gorm:"primarykey" json:"id"`
-	InvoiceNumber     string         `gorm:"uniqueIndex;not null" json:"invoice_number"`
-	CustomerName      string         `gorm:"type:varchar(200);not null" json:"customer_name"`
-	CustomerNumber    string         `gorm:"type:varchar(100);not null;index" json:"customer_number"`
-	Status            OrderStatus    `gorm:"type:varchar(20);not null;default:'Ordered'" json:"status"`
-	DeliveryAddress   string         `gorm:"type:text" json:"delivery_address"`
-	Notes             string         `gorm:"type:text" json:"notes"`
-	EvidencePhotoURL  string         `gorm:"type:varchar(500)" json:"evidence_photo_url"`
-	IsDeleted         bool           `gorm:"default:false;index" json:"is_deleted"`
-	CreatedBy         uint           `gorm:"not null" json:"created_by"`
-	CreatedByUser     User           `gorm:"foreignKey:CreatedBy" json:"created_by_user,omitempty"`
-	LastModifiedBy    uint           `json:"last_modified_by"`
-	LastModifiedUser  User           `gorm:"foreignKey:LastModifiedBy" json:"last_modified_by_user,omitempty"`
-	CreatedAt         time.Time      `json:"created_at"`
-	UpdatedAt         time.Time      `json:"updated_at"`
-	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
+	ID               uint           `gorm:"primarykey" json:"id"`
+	TenantID         uint           `gorm:"not null;uniqueIndex:idx_orders_tenant_invoice_number" json:"-"`
+	InvoiceNumber    string         `gorm:"uniqueIndex:idx_orders_tenant_invoice_number;not null" json:"invoice_number"`
+	CustomerName     string         `gorm:"type:varchar(200);not null" json:"customer_name"`
+	CustomerNumber   string         `gorm:"type:varchar(100);not null;index" json:"customer_number"`
+	Status           OrderStatus    `gorm:"type:varchar(20);not null;default:'Ordered'" json:"status"`
+	DeliveryAddress  string         `gorm:"type:text" json:"delivery_address"`
+	Notes            string         `gorm:"type:text" json:"notes"`
+	EvidencePhotoURL string         `gorm:"type:varchar(500)" json:"evidence_photo_url"`
+	IsDeleted        bool           `gorm:"default:false;index" json:"is_deleted"`
+	DueAt            *time.Time     `gorm:"index" json:"due_at,omitempty"`
+	BranchID         uint           `gorm:"not null;index" json:"branch_id"`
+	Branch           *Branch        `gorm:"foreignKey:BranchID" json:"branch,omitempty"`
+	CreatedBy        uint           `gorm:"not null" json:"created_by"`
+	CreatedByUser    User           `gorm:"foreignKey:CreatedBy" json:"created_by_user,omitempty"`
+	LastModifiedBy   uint           `json:"last_modified_by"`
+	LastModifiedUser User           `gorm:"foreignKey:LastModifiedBy" json:"last_modified_by_user,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Branch is a location with its own warehouse and drivers. Orders and users
+// belong to a branch and only see their own branch's orders unless their
+// roles grant branches.all. New users and orders without a branch get the
+// default branch.
+type Branch struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	TenantID  uint      `gorm:"not null;uniqueIndex:idx_branches_tenant_code" json:"-"`
+	Code      string    `gorm:"type:varchar(20);uniqueIndex:idx_branches_tenant_code;not null" json:"code"`
+	Name      string    `gorm:"type:varchar(100);not null" json:"name"`
+	Address   string    `gorm:"type:text;not null;default:''" json:"address"`
+	IsDefault bool      `gorm:"not null;default:false" json:"is_default"`
+	IsActive  bool      `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrderTransfer records an order moving from one branch to another
+type OrderTransfer struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	TenantID      uint      `gorm:"not null;index" json:"-"`
+	OrderID       uint      `gorm:"not null;index" json:"order_id"`
+	FromBranchID  uint      `gorm:"not null" json:"from_branch_id"`
+	FromBranch    *Branch   `gorm:"foreignKey:FromBranchID" json:"from_branch,omitempty"`
+	ToBranchID    uint      `gorm:"not null" json:"to_branch_id"`
+	ToBranch      *Branch   `gorm:"foreignKey:ToBranchID" json:"to_branch,omitempty"`
+	TransferredBy uint      `gorm:"not null" json:"transferred_by"`
+	Note          string    `gorm:"type:text;not null;default:''" json:"note"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// IdempotencyRecord stores the response of a request made with an
+// Idempotency-Key header so that retries can be replayed safely
+type IdempotencyRecord struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	Key         string    `gorm:"column:idempotency_key;type:varchar(255);not null;uniqueIndex:idx_idempotency_user_key" json:"key"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+	Method      string    `gorm:"type:varchar(10);not null" json:"method"`
+	Path        string    `gorm:"type:varchar(500);not null" json:"path"`
+	RequestHash string    `gorm:"type:varchar(64);not null" json:"request_hash"`
+	Completed   bool      `gorm:"default:false" json:"completed"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `gorm:"type:varchar(100)" json:"content_type"`
+	Body        []byte    `json:"-"`
+	ExpiresAt   time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RefreshToken is a hashed, single-use refresh token. Tokens issued by
+// rotating a refresh token share the FamilyID of the original login.
+type RefreshToken struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	FamilyID     string     `gorm:"type:varchar(64);not null;index" json:"family_id"`
+	TokenHash    string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ReplacedByID *uint      `json:"replaced_by_id,omitempty"`
+	IPAddress    string     `gorm:"type:varchar(64)" json:"ip_address"`
+	UserAgent    string     `gorm:"type:varchar(500)" json:"user_agent"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RevokedToken records the jti of an access token revoked before expiry
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey;type:varchar(64)" json:"jti"`
+	UserID    uint      `gorm:"not null" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	RevokedAt time.Time `gorm:"not null" json:"revoked_at"`
+}
+
+// AuthEventType identifies what happened in an AuthEvent
+type AuthEventType string
+
+const (
+	EventLoginSucceeded     AuthEventType = "login_succeeded"
+	EventLoginFailed        AuthEventType = "login_failed"
+	EventLoginThrottled     AuthEventType = "login_throttled"
+	EventAccountLocked      AuthEventType = "account_locked"
+	EventAccountUnlocked    AuthEventType = "account_unlocked"
+	EventLogout             AuthEventType = "logout"
+	EventRefreshTokenReuse  AuthEventType = "refresh_token_reused"
+	EventTokenRefreshed     AuthEventType = "token_refreshed"
+	EventTokenRefreshFailed AuthEventType = "token_refresh_failed"
+	EventPermissionDenied   AuthEventType = "permission_denied"
+	EventTwoFactorFailed    AuthEventType = "two_factor_failed"
+	EventTwoFactorEnabled   AuthEventType = "two_factor_enabled"
+	EventTwoFactorDisabled  AuthEventType = "two_factor_disabled"
+	EventRecoveryCodeUsed   AuthEventType = "recovery_code_used"
+	EventPasswordChanged    AuthEventType = "password_changed"
+	EventPasswordResetSent  AuthEventType = "password_reset_requested"
+	EventPasswordReset      AuthEventType = "password_reset"
+	EventAPIKeyCreated      AuthEventType = "api_key_created"
+	EventAPIKeyRevoked      AuthEventType = "api_key_revoked"
+	EventAPIKeyRejected     AuthEventType = "api_key_rejected"
+	EventSSOUserCreated     AuthEventType = "sso_user_provisioned"
+	// Invitations: ActorID is the admin, Detail the invited address
+	EventInvitationSent     AuthEventType = "invitation_sent"
+	EventInvitationRevoked  AuthEventType = "invitation_revoked"
+	EventInvitationAccepted AuthEventType = "invitation_accepted"
+	// Impersonation: UserID is the impersonated user, ActorID the admin
+	EventImpersonationStarted AuthEventType = "impersonation_started"
+	EventImpersonationEnded   AuthEventType = "impersonation_ended"
+	EventImpersonatedRequest  AuthEventType = "impersonated_request"
+)
+
+// failedAuthEvents lists the event types that record a refused attempt
+var failedAuthEvents = map[AuthEventType]bool{
+	EventLoginFailed:        true,
+	EventLoginThrottled:     true,
+	EventAccountLocked:      true,
+	EventRefreshTokenReuse:  true,
+	EventTokenRefreshFailed: true,
+	EventTwoFactorFailed:    true,
+	EventAPIKeyRejected:     true,
+	EventPermissionDenied:   true,
+}
+
+// AuthEventOutcome says whether the attempt an AuthEvent records succeeded
+type AuthEventOutcome string
+
+const (
+	OutcomeSuccess AuthEventOutcome = "success"
+	OutcomeFailure AuthEventOutcome = "failure"
+)
+
+// Outcome returns the outcome events of this type record
+func (t AuthEventType) Outcome() AuthEventOutcome {
+	if failedAuthEvents[t] {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// AuthEvent is an entry of the authentication audit log. UserID is nil
+// when the username did not match an account.
+type AuthEvent struct {
+	ID        uint             `gorm:"primarykey" json:"id"`
+	TenantID  uint             `gorm:"not null;index" json:"-"`
+	Type      AuthEventType    `gorm:"column:event_type;type:varchar(50);not null;index" json:"type"`
+	Outcome   AuthEventOutcome `gorm:"type:varchar(10);not null;default:success;index" json:"outcome"`
+	UserID    *uint            `gorm:"index" json:"user_id,omitempty"`
+	Username  string           `gorm:"type:varchar(50);index" json:"username"`
+	ActorID   *uint            `gorm:"index" json:"actor_id,omitempty"`
+	IPAddress string           `gorm:"type:varchar(64);index" json:"ip_address"`
+	UserAgent string           `gorm:"type:varchar(500)" json:"user_agent"`
+	Detail    string           `gorm:"type:varchar(255)" json:"detail"`
+	CreatedAt time.Time        `gorm:"index" json:"created_at"`
+}
+
+// RecoveryCode is a hashed single-use code that replaces a TOTP code when
+// the authenticator device is lost
+type RecoveryCode struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"type:varchar(64);not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PasswordResetToken is a hashed single-use token emailed to a user who
+// forgot their password
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	IPAddress string     `gorm:"type:varchar(64)" json:"ip_address"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// InvitationStatus is the state of an invitation, derived from its
+// timestamps
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationRevoked  InvitationStatus = "revoked"
+	InvitationExpired  InvitationStatus = "expired"
+)
+
+// Invitation offers an account to an email address. The invitee receives a
+// hashed single-use link and chooses a username and password to accept it;
+// the account gets the role, department and branch picked by the admin.
+type Invitation struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	TenantID      uint       `gorm:"not null;index" json:"-"`
+	Email         string     `gorm:"type:varchar(200);not null" json:"email"`
+	FullName      string     `gorm:"type:varchar(200);not null;default:''" json:"full_name"`
+	Role          UserRole   `gorm:"type:varchar(50);not null" json:"role"`
+	Department    string     `gorm:"type:varchar(100);not null;default:''" json:"department"`
+	BranchID      *uint      `json:"branch_id"`
+	Branch        *Branch    `gorm:"foreignKey:BranchID" json:"branch,omitempty"`
+	TokenHash     string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	ExpiresAt     time.Time  `gorm:"not null" json:"expires_at"`
+	SentCount     int        `gorm:"not null;default:0" json:"sent_count"`
+	LastSentAt    *time.Time `json:"last_sent_at,omitempty"`
+	InvitedBy     uint       `gorm:"not null" json:"invited_by"`
+	InvitedByUser *User      `gorm:"foreignKey:InvitedBy" json:"invited_by_user,omitempty"`
+	AcceptedAt    *time.Time `json:"accepted_at,omitempty"`
+	UserID        *uint      `json:"user_id,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Status returns the state of the invitation at the given time
+func (i *Invitation) Status(now time.Time) InvitationStatus {
+	switch {
+	case i.AcceptedAt != nil:
+		return InvitationAccepted
+	case i.RevokedAt != nil:
+		return InvitationRevoked
+	case !now.Before(i.ExpiresAt):
+		return InvitationExpired
+	}
+	return InvitationPending
+}
+
+// APIKeyScopes lists the scopes an API key can be granted. A scope names a
+// resource under /api and read (GET) or write (any other method) access.
+var APIKeyScopes = []string{"orders:read", "orders:write", "users:read", "users:write"}
+
+// IsAPIKeyScope reports whether scope is one of APIKeyScopes
+func IsAPIKeyScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey authenticates a service account. Only the SHA-256 of the key is
+// stored; Prefix identifies it in listings. Scopes and AllowedIPs are
+// comma-separated, and an empty AllowedIPs accepts any address.
+type APIKey struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"type:varchar(100);not null" json:"name"`
+	Prefix     string     `gorm:"type:varchar(16);not null" json:"prefix"`
+	KeyHash    string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Scopes     string     `gorm:"type:varchar(255);not null" json:"scopes"`
+	AllowedIPs string     `gorm:"column:allowed_ips;type:varchar(1000);not null;default:''" json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `gorm:"type:varchar(64)" json:"last_used_ip"`
+	UsageCount int64      `gorm:"not null;default:0" json:"usage_count"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy  *uint      `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// OIDCLoginState holds the PKCE verifier and nonce of a single sign-on
+// login between the redirect to the identity provider and the callback. It
+// is found by the hash of the state parameter and used once, and signs in a
+// user of the tenant that started the login.
+type OIDCLoginState struct {
+	ID           uint      `gorm:"primarykey"`
+	TenantID     uint      `gorm:"not null"`
+	StateHash    string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Nonce        string    `gorm:"type:varchar(64);not null"`
+	CodeVerifier string    `gorm:"type:varchar(128);not null"`
+	RedirectPath string    `gorm:"type:varchar(255);not null;default:''"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+// SSOHandoff is a hashed one-time code that lets the web client obtain the
+// session of a completed single sign-on login
+type SSOHandoff struct {
+	ID        uint      `gorm:"primarykey"`
+	CodeHash  string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	UserID    uint      `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// IsSSO reports whether the user is linked to an identity provider account
+func (u *User) IsSSO() bool {
+	return u.OIDCSubject != ""
+}
+
+// TableName specifies the table name for Tenant model
+func (Tenant) TableName() string {
+	return "tenants"
 }
 
 // TableName specifies the table name for User model
@@ -71,3 +514,112 @@ func (User) TableName() string {
 func (Order) TableName() string {
 	return "orders"
 }
+
+// TableName specifies the table name for IdempotencyRecord model
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_records"
+}
+
+// TableName specifies the table name for RefreshToken model
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// TableName specifies the table name for RevokedToken model
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// TableName specifies the table name for AuthEvent model
+func (AuthEvent) TableName() string {
+	return "auth_events"
+}
+
+// TableName specifies the table name for RecoveryCode model
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// TableName specifies the table name for PasswordResetToken model
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+// TableName specifies the table name for Invitation model
+func (Invitation) TableName() string {
+	return "invitations"
+}
+
+// TableName specifies the table name for APIKey model
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// TableName specifies the table name for OIDCLoginState model
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
+
+// TableName specifies the table name for SSOHandoff model
+func (SSOHandoff) TableName() string {
+	return "sso_handoffs"
+}
+
+// TableName specifies the table name for Role model
+func (Role) TableName() string {
+	return "roles"
+}
+
+// TableName specifies the table name for RolePermission model
+func (RolePermission) TableName() string {
+	return "role_permissions"
+}
+
+// TableName specifies the table name for Branch model
+func (Branch) TableName() string {
+	return "branches"
+}
+
+// TableName specifies the table name for OrderTransfer model
+func (OrderTransfer) TableName() string {
+	return "order_transfers"
+}
+
+// BeforeCreate puts a new user without a branch in the default branch of
+// their tenant
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	if u.BranchID != nil {
+		return nil
+	}
+	var ids []uint
+	if err := tx.Model(&Branch{}).Where("tenant_id = ? AND is_default = ?", u.TenantID, true).Limit(1).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		u.BranchID = &ids[0]
+	}
+	return nil
+}
+
+// BeforeCreate puts a new order without a branch in its creator's branch,
+// else the default branch of its tenant
+func (o *Order) BeforeCreate(tx *gorm.DB) error {
+	if o.BranchID != 0 {
+		return nil
+	}
+	var id sql.NullInt64
+	err := tx.Raw(`SELECT COALESCE(
+		(SELECT branch_id FROM users WHERE id = ? AND tenant_id = ?),
+		(SELECT id FROM branches WHERE tenant_id = ? AND is_default LIMIT 1))`, o.CreatedBy, o.TenantID, o.TenantID).Row().Scan(&id)
+	if err != nil {
+		return err
+	}
+	o.BranchID = uint(id.Int64)
+	return nil
+}
+
+// AfterCreate gives a new user its primary role in user_roles, so every
+// way of creating users grants the role's permissions
+func (u *User) AfterCreate(tx *gorm.DB) error {
+	return tx.Exec("INSERT INTO user_roles (user_id, role_id) SELECT ?, id FROM roles WHERE tenant_id = ? AND name = ? ON CONFLICT DO NOTHING", u.ID, u.TenantID, u.Role).Error
+}
@@ -0,0 +1,350 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nietzshn/halcon-core/internal/config"
+)
+
+const (
+	// httpTimeout bounds every request to the identity provider
+	httpTimeout = 10 * time.Second
+	// discoveryTTL is how long the discovery document is cached
+	discoveryTTL = time.Hour
+	// jwksMinRefresh limits JWKS reloads triggered by unknown key IDs
+	jwksMinRefresh = 30 * time.Second
+	// maxResponseBytes caps documents read from the identity provider
+	maxResponseBytes = 1 << 20
+)
+
+// signingAlgorithms are the ID token algorithms accepted from the provider
+var signingAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}
+
+// httpClient is used for every request to the identity provider
+var httpClient = &http.Client{Timeout: httpTimeout}
+
+// ErrNotConfigured is returned when OIDC_ISSUER_URL is empty
+var ErrNotConfigured = errors.New("single sign-on is not configured")
+
+// Provider talks to an OpenID Connect identity provider using the
+// authorization code flow with PKCE
+type Provider struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	mu           sync.Mutex
+	discovery    *discoveryDocument
+	discoveredAt time.Time
+	keys         map[string]crypto.PublicKey
+	keysLoaded   time.Time
+}
+
+// discoveryDocument is the part of the provider metadata that is used
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the verified claims of an ID token. Raw holds every claim so
+// configurable ones such as groups can be read.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Raw               map[string]interface{}
+}
+
+// Default is the provider configured by OIDC_*, set by Init
+var Default *Provider
+
+// Init configures the Default provider when single sign-on is enabled. The
+// provider metadata is fetched on first use, so an unreachable identity
+// provider doesn't prevent the server from starting.
+func Init() {
+	cfg := config.AppConfig
+	if !cfg.OIDCEnabled() {
+		Default = nil
+		return
+	}
+	Default = &Provider{
+		IssuerURL:    strings.TrimRight(cfg.OIDCIssuerURL, "/"),
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+		Scopes:       strings.Fields(cfg.OIDCScopes),
+	}
+}
+
+// Discover fetches the provider metadata, which checks that the identity
+// provider is reachable and announces the configured issuer
+func (p *Provider) Discover() error {
+	_, err := p.metadata()
+	return err
+}
+
+// CodeChallenge derives the S256 PKCE challenge of a code verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the authorization endpoint URL the browser is sent to
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) (string, error) {
+	doc, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", CodeChallenge(codeVerifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims
+// of the ID token, which must carry the given nonce
+func (p *Provider) Exchange(code, codeVerifier, nonce string) (*Claims, error) {
+	doc, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with status %d: %s %s", status, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	return p.verifyIDToken(tokens.IDToken, nonce, doc.Issuer)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token
+func (p *Provider) verifyIDToken(raw, nonce, issuer string) (*Claims, error) {
+	mapClaims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, mapClaims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(kid)
+	},
+		jwt.WithValidMethods(signingAlgorithms),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	if got, _ := mapClaims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("invalid id_token: nonce mismatch")
+	}
+	// With several audiences the token must have been issued to us
+	if aud, _ := mapClaims.GetAudience(); len(aud) > 1 {
+		if azp, _ := mapClaims["azp"].(string); azp != p.ClientID {
+			return nil, fmt.Errorf("invalid id_token: authorized party mismatch")
+		}
+	}
+
+	claims := &Claims{Issuer: issuer, Raw: mapClaims}
+	claims.Subject, _ = mapClaims["sub"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.EmailVerified, _ = mapClaims["email_verified"].(bool)
+	claims.Name, _ = mapClaims["name"].(string)
+	claims.PreferredUsername, _ = mapClaims["preferred_username"].(string)
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid id_token: missing subject")
+	}
+	return claims, nil
+}
+
+// StringsClaim reads a claim holding a string or a list of strings, such
+// as a groups claim
+func (c *Claims) StringsClaim(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// StringClaim reads a string claim
+func (c *Claims) StringClaim(name string) string {
+	s, _ := c.Raw[name].(string)
+	return s
+}
+
+// metadata returns the cached discovery document, fetching it when missing
+// or stale
+func (p *Provider) metadata() (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.IssuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc discoveryDocument
+	status, err := p.doJSON(req, &doc)
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document from %s: status %d, %v", p.IssuerURL, status, err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != p.IssuerURL {
+		return nil, fmt.Errorf("OIDC discovery issuer %q does not match %q", doc.Issuer, p.IssuerURL)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing endpoints")
+	}
+
+	p.discovery = &doc
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// verificationKey returns the provider key with the given kid, reloading
+// the JWKS when the kid is unknown so key rotation at the provider works
+func (p *Provider) verificationKey(kid string) (crypto.PublicKey, error) {
+	doc, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysLoaded) < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, doc.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jsonWebKeySet
+	status, err := p.doJSON(req, &set)
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: status %d, %v", status, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	p.keys = keys
+	p.keysLoaded = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a key by kid. A token without kid is accepted when the
+// provider publishes a single key.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// doJSON performs a request and decodes the JSON response body into v,
+// returning the HTTP status
+func (p *Provider) doJSON(req *http.Request, v interface{}) (int, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,36 @@
+// Package patch provides the field type of JSON Merge Patch (RFC 7396)
+// request bodies, which tells a member that was left out from one that was
+// sent as null
+package patch
+
+import "encoding/json"
+
+// MIMEMergePatch is the media type of JSON Merge Patch documents
+const MIMEMergePatch = "application/merge-patch+json"
+
+// Field is a member of a merge patch. A member left out of the document
+// keeps the stored value, null clears it and any other value replaces it.
+type Field[T any] struct {
+	Value T
+	Set   bool // the member was present in the document
+	Null  bool // the member was null
+}
+
+// UnmarshalJSON records that the member was present, and whether it was null
+func (f *Field[T]) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if string(data) == "null" {
+		f.Null = true
+		var zero T
+		f.Value = zero
+		return nil
+	}
+	f.Null = false
+	return json.Unmarshal(data, &f.Value)
+}
+
+// HasValue reports whether the member was present with a value other than
+// null
+func (f Field[T]) HasValue() bool {
+	return f.Set && !f.Null
+}
@@ -0,0 +1,186 @@
+package setup
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/nietzshn/halcon-core/internal/auth"
+	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/database"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/tenant"
+	"github.com/nietzshn/halcon-core/internal/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidToken is returned when the setup token doesn't match
+	ErrInvalidToken = errors.New("invalid setup token")
+	// ErrAlreadyCompleted is returned once an admin user exists
+	ErrAlreadyCompleted = errors.New("setup has already been completed")
+)
+
+// minProductionAdminPasswordLength applies to ADMIN_PASSWORD in production
+const minProductionAdminPasswordLength = 12
+
+// knownDefaultPasswords are never accepted as ADMIN_PASSWORD in production
+var knownDefaultPasswords = []string{"admin", "admin123", "password", "changeme", "halcon"}
+
+var (
+	mu        sync.Mutex
+	tokenHash []byte // SHA-256 of the active setup token, nil when not required
+)
+
+// Init prepares first-run bootstrap of the default tenant; the admins of
+// other tenants are created with "halconctl tenant create". When no admin
+// user exists it creates one from ADMIN_PASSWORD, or otherwise activates a
+// one-time setup token that must be presented to POST /api/setup to create
+// the first admin.
+func Init() error {
+	exists, err := adminExists(database.ForTenant(tenant.DefaultID))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	cfg := config.AppConfig
+	if cfg.AdminPassword != "" {
+		return createConfiguredAdmin()
+	}
+
+	token := cfg.SetupToken
+	if token == "" {
+		if token, err = utils.RandomToken(24); err != nil {
+			return err
+		}
+		log.Println("==================================================================")
+		log.Println("No admin user exists. Complete setup with this one-time token:")
+		log.Printf("    %s", token)
+		log.Println("POST /api/setup {\"setup_token\": ..., \"username\": ..., \"password\": ...}")
+		log.Println("The token is valid until setup completes or the server restarts.")
+		log.Println("==================================================================")
+	} else {
+		log.Println("No admin user exists. Complete setup with the configured SETUP_TOKEN via POST /api/setup")
+	}
+
+	mu.Lock()
+	sum := sha256.Sum256([]byte(token))
+	tokenHash = sum[:]
+	mu.Unlock()
+
+	return nil
+}
+
+// Required reports whether first-run setup is still pending
+func Required() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return tokenHash != nil
+}
+
+// Complete verifies the setup token and creates the first admin user from
+// the given user and password. The token is single-use.
+func Complete(token string, admin *models.User, password string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if tokenHash == nil {
+		return ErrAlreadyCompleted
+	}
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(sum[:], tokenHash) != 1 {
+		return ErrInvalidToken
+	}
+
+	hashedPassword, err := auth.HashPassword(password, admin.Username)
+	if err != nil {
+		return err
+	}
+
+	admin.PasswordHash = hashedPassword
+	admin.Role = models.RoleAdmin
+	admin.IsActive = true
+	admin.MustChangePassword = false
+
+	err = database.ForTenant(tenant.DefaultID).Transaction(func(tx *gorm.DB) error {
+		exists, err := adminExists(tx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrAlreadyCompleted
+		}
+		return tx.Create(admin).Error
+	})
+	if err != nil && !errors.Is(err, ErrAlreadyCompleted) {
+		return err
+	}
+
+	tokenHash = nil
+	if err == nil {
+		log.Printf("Setup completed, admin user %q created", admin.Username)
+	}
+	return err
+}
+
+// createConfiguredAdmin creates the first admin from ADMIN_USERNAME and
+// ADMIN_PASSWORD. The password must be changed at first login.
+func createConfiguredAdmin() error {
+	cfg := config.AppConfig
+	if cfg.IsProduction() {
+		if err := checkProductionPassword(cfg.AdminPassword); err != nil {
+			return err
+		}
+	}
+
+	hashedPassword, err := auth.HashPassword(cfg.AdminPassword, cfg.AdminUsername)
+	if err != nil {
+		return fmt.Errorf("ADMIN_PASSWORD: %w", err)
+	}
+
+	admin := models.User{
+		Username:           cfg.AdminUsername,
+		PasswordHash:       hashedPassword,
+		Role:               models.RoleAdmin,
+		Department:         "Administration",
+		FullName:           "System Administrator",
+		Email:              cfg.AdminEmail,
+		IsActive:           true,
+		MustChangePassword: true,
+	}
+	if err := database.ForTenant(tenant.DefaultID).Create(&admin).Error; err != nil {
+		return fmt.Errorf("failed to create admin user: %w", err)
+	}
+
+	log.Printf("Admin user %q created from ADMIN_PASSWORD, the password must be changed at first login", admin.Username)
+	return nil
+}
+
+// checkProductionPassword rejects default or short admin passwords
+func checkProductionPassword(password string) error {
+	for _, known := range knownDefaultPasswords {
+		if strings.EqualFold(password, known) {
+			return fmt.Errorf("ADMIN_PASSWORD is a well-known default and is not allowed in production")
+		}
+	}
+	if len(password) < minProductionAdminPasswordLength {
+		return fmt.Errorf("ADMIN_PASSWORD must be at least %d characters in production", minProductionAdminPasswordLength)
+	}
+	return nil
+}
+
+// adminExists reports whether an active admin user exists
+func adminExists(db *gorm.DB) (bool, error) {
+	var count int64
+	if err := db.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check for admin users: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,159 @@
+// Package tenant isolates the data of the companies hosted on one
+// deployment. Tables of models with a TenantID field are tenant-owned: a
+// GORM plugin adds the tenant of the statement's context to every query,
+// update and delete on them and stamps it on every insert. Statements on
+// those tables without a tenant fail with ErrMissingTenant instead of
+// silently reading every tenant's rows, so a handler can't forget the
+// filter. Code that legitimately works across tenants, such as loading a
+// user by ID while authenticating, opts out with WithoutTenant.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultID is the tenant created by the migrations. It owns all data in
+// single-tenant mode.
+const DefaultID uint = 1
+
+// ErrMissingTenant is returned for statements on tenant-owned tables whose
+// context carries no tenant
+var ErrMissingTenant = errors.New("tenant: statement on a tenant-owned table without a tenant")
+
+// ErrTenantUpsert is returned for inserts on tenant-owned tables that update
+// the existing row on conflict, which could take over a row of another
+// tenant with the same key. GORM's Save falls back to such an insert when
+// the update matched no row.
+var ErrTenantUpsert = errors.New("tenant: upserts on tenant-owned tables are not allowed")
+
+// fieldName is the model field that marks tenant-owned tables
+const fieldName = "TenantID"
+
+type contextKey int
+
+const (
+	tenantKey contextKey = iota
+	systemKey
+)
+
+// NewContext returns a context whose statements are limited to the tenant
+func NewContext(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// FromContext returns the tenant of a context
+func FromContext(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(tenantKey).(uint)
+	return id, ok && id != 0
+}
+
+// WithoutTenant returns a context whose statements see every tenant.
+// Inserts must then set TenantID themselves.
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemKey, true)
+}
+
+func isSystem(ctx context.Context) bool {
+	system, _ := ctx.Value(systemKey).(bool)
+	return system
+}
+
+// Register installs the tenant callbacks on a database handle
+func Register(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register("tenant:query", scopeStatement); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("tenant:row", scopeStatement); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant:update", scopeStatement); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("tenant:delete", scopeStatement); err != nil {
+		return err
+	}
+	// Before the BeforeCreate hooks so they can rely on TenantID
+	return cb.Create().Before("gorm:before_create").Register("tenant:create", stampTenant)
+}
+
+// tenantField returns the tenant field of the statement's model, or nil
+// for tables that aren't tenant-owned
+func tenantField(db *gorm.DB) *schema.Field {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return nil
+	}
+	return db.Statement.Schema.LookUpField(fieldName)
+}
+
+// scopeStatement limits queries, updates and deletes to the tenant
+func scopeStatement(db *gorm.DB) {
+	field := tenantField(db)
+	if field == nil {
+		return
+	}
+
+	ctx := db.Statement.Context
+	id, ok := FromContext(ctx)
+	if !ok {
+		if !isSystem(ctx) {
+			db.AddError(ErrMissingTenant)
+		}
+		return
+	}
+
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id},
+	}})
+}
+
+// stampTenant sets the tenant of inserted rows, or checks that system
+// inserts name one
+func stampTenant(db *gorm.DB) {
+	field := tenantField(db)
+	if field == nil {
+		return
+	}
+
+	ctx := db.Statement.Context
+	id, ok := FromContext(ctx)
+	if !ok && !isSystem(ctx) {
+		db.AddError(ErrMissingTenant)
+		return
+	}
+	if onConflict, upsert := db.Statement.Clauses["ON CONFLICT"].Expression.(clause.OnConflict); upsert && ok && !onConflict.DoNothing {
+		db.AddError(ErrTenantUpsert)
+		return
+	}
+
+	eachRow(db.Statement.ReflectValue, func(row reflect.Value) {
+		if ok {
+			if err := field.Set(ctx, row, id); err != nil {
+				db.AddError(err)
+			}
+			return
+		}
+		if _, zero := field.ValueOf(ctx, row); zero {
+			db.AddError(ErrMissingTenant)
+		}
+	})
+}
+
+// eachRow calls fn for the struct or every element of a slice
+func eachRow(value reflect.Value, fn func(reflect.Value)) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			fn(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		fn(value)
+	}
+}
@@ -0,0 +1,193 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// widget is a tenant-owned model
+type widget struct {
+	ID       uint
+	TenantID uint
+	Name     string
+}
+
+// setting is a shared model without a tenant
+type setting struct {
+	ID   uint
+	Name string
+}
+
+// dryRunDB returns a handle with the tenant callbacks that builds SQL
+// without a database
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=halcon_test"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := Register(db); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	return db
+}
+
+func TestScopesStatementsToTenant(t *testing.T) {
+	db := dryRunDB(t).WithContext(NewContext(context.Background(), 7))
+
+	tests := []struct {
+		name string
+		run  func(db *gorm.DB) *gorm.DB
+	}{
+		{"query", func(db *gorm.DB) *gorm.DB { return db.Where("name = ?", "a").Find(&[]widget{}) }},
+		{"first by id", func(db *gorm.DB) *gorm.DB { return db.First(&widget{}, 3) }},
+		{"row", func(db *gorm.DB) *gorm.DB {
+			var count int64
+			return db.Model(&widget{}).Count(&count)
+		}},
+		{"update", func(db *gorm.DB) *gorm.DB { return db.Model(&widget{ID: 3}).Update("name", "b") }},
+		{"delete", func(db *gorm.DB) *gorm.DB { return db.Delete(&widget{ID: 3}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt := tt.run(db).Statement
+			if stmt.Error != nil {
+				t.Fatalf("unexpected error: %v", stmt.Error)
+			}
+			sql := stmt.SQL.String()
+			if !strings.Contains(sql, `"widgets"."tenant_id" = $`) {
+				t.Fatalf("statement is not limited to the tenant: %s", sql)
+			}
+			if !containsVar(stmt.Vars, uint(7)) {
+				t.Fatalf("tenant 7 is not bound in %v", stmt.Vars)
+			}
+		})
+	}
+}
+
+func TestRejectsStatementsWithoutTenant(t *testing.T) {
+	db := dryRunDB(t)
+
+	tests := []struct {
+		name string
+		run  func(db *gorm.DB) *gorm.DB
+	}{
+		{"query", func(db *gorm.DB) *gorm.DB { return db.Find(&[]widget{}) }},
+		{"row", func(db *gorm.DB) *gorm.DB {
+			var count int64
+			return db.Model(&widget{}).Count(&count)
+		}},
+		{"update", func(db *gorm.DB) *gorm.DB { return db.Model(&widget{ID: 3}).Update("name", "b") }},
+		{"delete", func(db *gorm.DB) *gorm.DB { return db.Delete(&widget{ID: 3}) }},
+		{"create", func(db *gorm.DB) *gorm.DB { return db.Create(&widget{Name: "a"}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.run(db.WithContext(context.Background())).Error; !errors.Is(err, ErrMissingTenant) {
+				t.Fatalf("got %v, want ErrMissingTenant", err)
+			}
+		})
+	}
+}
+
+func TestSharedTablesAreNotScoped(t *testing.T) {
+	stmt := dryRunDB(t).Find(&[]setting{}).Statement
+	if stmt.Error != nil {
+		t.Fatalf("unexpected error: %v", stmt.Error)
+	}
+	if sql := stmt.SQL.String(); strings.Contains(sql, "tenant_id") {
+		t.Fatalf("shared table got a tenant filter: %s", sql)
+	}
+}
+
+func TestSystemContextSeesEveryTenant(t *testing.T) {
+	db := dryRunDB(t).WithContext(WithoutTenant(context.Background()))
+
+	stmt := db.Find(&[]widget{}).Statement
+	if stmt.Error != nil {
+		t.Fatalf("unexpected error: %v", stmt.Error)
+	}
+	if sql := stmt.SQL.String(); strings.Contains(sql, "tenant_id") {
+		t.Fatalf("system query got a tenant filter: %s", sql)
+	}
+}
+
+func TestStampsTenantOnInsert(t *testing.T) {
+	db := dryRunDB(t).WithContext(NewContext(context.Background(), 7))
+
+	single := widget{Name: "a", TenantID: 9}
+	if err := db.Create(&single).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if single.TenantID != 7 {
+		t.Fatalf("TenantID = %d, want 7 even when another tenant was set", single.TenantID)
+	}
+
+	batch := []widget{{Name: "a"}, {Name: "b"}}
+	if err := db.Create(&batch).Error; err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	for i, w := range batch {
+		if w.TenantID != 7 {
+			t.Fatalf("batch[%d].TenantID = %d, want 7", i, w.TenantID)
+		}
+	}
+}
+
+func TestSystemInsertsMustNameTenant(t *testing.T) {
+	db := dryRunDB(t).WithContext(WithoutTenant(context.Background()))
+
+	if err := db.Create(&widget{Name: "a"}).Error; !errors.Is(err, ErrMissingTenant) {
+		t.Fatalf("got %v, want ErrMissingTenant", err)
+	}
+	if err := db.Create(&[]widget{{Name: "a", TenantID: 2}, {Name: "b"}}).Error; !errors.Is(err, ErrMissingTenant) {
+		t.Fatalf("batch with a missing tenant: got %v, want ErrMissingTenant", err)
+	}
+
+	w := widget{Name: "a", TenantID: 2}
+	if err := db.Create(&w).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if w.TenantID != 2 {
+		t.Fatalf("TenantID = %d, want 2", w.TenantID)
+	}
+}
+
+func TestRejectsUpserts(t *testing.T) {
+	db := dryRunDB(t).WithContext(NewContext(context.Background(), 7))
+
+	err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&widget{ID: 3, Name: "a"}).Error
+	if !errors.Is(err, ErrTenantUpsert) {
+		t.Fatalf("update on conflict: got %v, want ErrTenantUpsert", err)
+	}
+
+	err = db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "id"}}, DoUpdates: clause.AssignmentColumns([]string{"name"})}).Create(&widget{ID: 3, Name: "a"}).Error
+	if !errors.Is(err, ErrTenantUpsert) {
+		t.Fatalf("assignments on conflict: got %v, want ErrTenantUpsert", err)
+	}
+
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&widget{Name: "a"}).Error; err != nil {
+		t.Fatalf("do nothing on conflict: %v", err)
+	}
+}
+
+func containsVar(vars []interface{}, want interface{}) bool {
+	for _, v := range vars {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
@@ -1,45 +1,110 @@
 package utils
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/nietzshn/halcon-core/internal/config"
+	"github.com/nietzshn/halcon-core/internal/keys"
 	"github.com/nietzshn/halcon-core/internal/models"
 )
 
+// PurposeTwoFactor marks the intermediate token issued after the password
+// step of a login that still needs a second factor
+const PurposeTwoFactor = "2fa"
+
+// twoFactorTokenTTL is how long the user has to enter the second factor
+const twoFactorTokenTTL = 5 * time.Minute
+
 type JWTClaims struct {
-	UserID   uint             `json:"user_id"`
-	Username string           `json:"username"`
-	Role     models.UserRole  `json:"role"`
+	UserID             uint            `json:"user_id"`
+	TenantID           uint            `json:"tid,omitempty"`
+	Username           string          `json:"username"`
+	Role               models.UserRole `json:"role"`
+	MustChangePassword bool            `json:"must_change_password,omitempty"`
+	// Purpose is empty for access tokens. Tokens with a purpose are only
+	// accepted by the endpoint that handles that purpose.
+	Purpose string `json:"purpose,omitempty"`
+	// Act names the admin acting as the user in impersonation tokens, after
+	// the act claim of RFC 8693
+	Act *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT token for a user
+// Actor is the impersonating user of an impersonation token
+type Actor struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// GenerateToken creates a new short-lived access token for a user. Each
+// token carries a unique jti so it can be revoked before it expires.
 func GenerateToken(user *models.User) (string, error) {
-	expirationTime := time.Now().Add(time.Duration(config.AppConfig.JWTExpirationHours) * time.Hour)
+	return signToken(user, "", nil, time.Duration(config.AppConfig.AccessTokenTTLMinutes)*time.Minute)
+}
+
+// GenerateTwoFactorToken creates the short-lived token that identifies a
+// user between the password and second factor steps of a login
+func GenerateTwoFactorToken(user *models.User) (string, int, error) {
+	token, err := signToken(user, PurposeTwoFactor, nil, twoFactorTokenTTL)
+	return token, int(twoFactorTokenTTL.Seconds()), err
+}
+
+// GenerateImpersonationToken creates an access token that lets actor act as
+// user for IMPERSONATION_TTL_MINUTES
+func GenerateImpersonationToken(user, actor *models.User) (string, int, error) {
+	ttl := time.Duration(config.AppConfig.ImpersonationTTLMinutes) * time.Minute
+	token, err := signToken(user, "", &Actor{UserID: actor.ID, Username: actor.Username}, ttl)
+	return token, int(ttl.Seconds()), err
+}
+
+// signToken signs the claims of a user with the current signing key
+func signToken(user *models.User, purpose string, act *Actor, ttl time.Duration) (string, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
 
+	now := time.Now()
 	claims := &JWTClaims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Role:     user.Role,
+		UserID:             user.ID,
+		TenantID:           user.TenantID,
+		Username:           user.Username,
+		Role:               user.Role,
+		MustChangePassword: user.MustChangePassword,
+		Purpose:            purpose,
+		Act:                act,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+	key := keys.Default.SigningKey()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Signer)
 }
 
-// ValidateToken validates and parses a JWT token
+// ValidateToken validates and parses a JWT token, verifying it with the key
+// named by its kid header
 func ValidateToken(tokenString string) (*JWTClaims, error) {
 	claims := &JWTClaims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.AppConfig.JWTSecret), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, err := keys.Default.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// The algorithm is bound to the key, never taken from the token alone
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+		}
+		return key.Public(), nil
+	}, jwt.WithValidMethods([]string{keys.AlgEdDSA, keys.AlgRS256}))
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,187 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/nietzshn/halcon-core/internal/apierror"
+	"github.com/nietzshn/halcon-core/internal/models"
+	"github.com/nietzshn/halcon-core/internal/patch"
+)
+
+// invoicePattern accepts invoice numbers such as INV-2024-0001: letters,
+// digits and dashes, starting with a letter or digit, 3 to 50 characters
+var invoicePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{2,49}$`)
+
+// Validator implements echo.Validator on top of go-playground/validator,
+// adding the domain rules used by request structs
+type Validator struct {
+	validate *validator.Validate
+}
+
+// New creates a Validator with the custom domain tags registered:
+//
+//	user_role      a well-formed role name; existence is checked by handlers
+//	permission     one of the models.Permission constants
+//	order_status   a valid models.OrderStatus
+//	invoice_number letters, digits and dashes, 3 to 50 characters
+//	api_key_scope  one of models.APIKeyScopes
+//
+// Rules on patch.Field members apply to the value sent; members left out or
+// sent as null count as empty.
+func New() *Validator {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report fields by their JSON name so errors match the request body
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	v.RegisterValidation("user_role", func(fl validator.FieldLevel) bool {
+		return models.UserRole(fl.Field().String()).IsValid()
+	})
+	v.RegisterValidation("permission", func(fl validator.FieldLevel) bool {
+		return models.Permission(fl.Field().String()).IsValid()
+	})
+	v.RegisterValidation("order_status", func(fl validator.FieldLevel) bool {
+		return models.OrderStatus(fl.Field().String()).IsValid()
+	})
+	v.RegisterValidation("invoice_number", func(fl validator.FieldLevel) bool {
+		return invoicePattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("api_key_scope", func(fl validator.FieldLevel) bool {
+		return models.IsAPIKeyScope(fl.Field().String())
+	})
+
+	v.RegisterCustomTypeFunc(patchValue[string], patch.Field[string]{})
+	v.RegisterCustomTypeFunc(patchValue[bool], patch.Field[bool]{})
+	v.RegisterCustomTypeFunc(patchValue[uint], patch.Field[uint]{})
+	v.RegisterCustomTypeFunc(patchValue[models.UserRole], patch.Field[models.UserRole]{})
+	v.RegisterCustomTypeFunc(patchValue[[]models.UserRole], patch.Field[[]models.UserRole]{})
+	v.RegisterCustomTypeFunc(patchValue[models.OrderStatus], patch.Field[models.OrderStatus]{})
+
+	return &Validator{validate: v}
+}
+
+// Validate checks a request struct and returns an *apierror.Error with one
+// entry per invalid field
+func (v *Validator) Validate(i interface{}) error {
+	err := v.validate.Struct(i)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "invalid request").Wrap(err)
+	}
+
+	fields := make([]apierror.FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		fields = append(fields, apierror.FieldError{
+			Field:   fieldPath(fe),
+			Code:    fe.Tag(),
+			Message: fieldMessage(fe),
+		})
+	}
+
+	return apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "request validation failed").
+		WithFields(fields...)
+}
+
+// patchValue unwraps a patch.Field for validation, returning nil for a
+// member that was left out or null
+func patchValue[T any](field reflect.Value) interface{} {
+	if f, ok := field.Interface().(patch.Field[T]); ok && f.HasValue() {
+		return f.Value
+	}
+	return nil
+}
+
+// fieldPath returns the JSON path of the field without the struct name
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return fe.Field()
+}
+
+// fieldMessage builds a human readable message for a failed rule
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "field is required"
+	case "min":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "required_without":
+		return fmt.Sprintf("field is required when %s is empty", snakeCase(fe.Param()))
+	case "len":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be exactly %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must have exactly %s items", fe.Param())
+	case "numeric":
+		return "must contain only digits"
+	case "email":
+		return "must be a valid email address"
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fe.Param())
+	case "user_role":
+		return "must start with a letter and contain at most 50 letters, digits, spaces, dots, dashes or underscores"
+	case "permission":
+		return "must be a known permission, see GET /api/permissions"
+	case "order_status":
+		return fmt.Sprintf("must be one of: %s", joinValues(models.AllStatuses))
+	case "invoice_number":
+		return "must be 3 to 50 letters, digits or dashes"
+	case "api_key_scope":
+		return fmt.Sprintf("must be one of: %s", strings.Join(models.APIKeyScopes, ", "))
+	}
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
+
+// snakeCase converts a Go field name such as RecoveryCode to recovery_code,
+// matching the JSON names used in requests
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func joinValues[T ~string](values []T) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = string(v)
+	}
+	return strings.Join(parts, ", ")
+}